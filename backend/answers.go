@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// AcceptAnswerRequest represents the JSON body for accepting an answer.
+type AcceptAnswerRequest struct {
+	CommentID int `json:"commentId"`
+}
+
+// acceptedAnswerHandler handles:
+//   - PUT    /posts/{id}/answer → accept a comment as the answer, body { "commentId": 1 }
+//   - DELETE /posts/{id}/answer → mark the post as unresolved again
+//
+// Only the author of the post or a moderator can do this. Accepting a
+// different comment replaces the previous answer.
+func (s *server) acceptedAnswerHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var req AcceptAnswerRequest
+	switch r.Method {
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if req.CommentID == 0 {
+			writeFieldError(w, "commentId", "Missing commentId")
+			return
+		}
+	case http.MethodDelete:
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+
+	var updated Post
+	err := s.store.Tx(func(tx Store) error {
+		before, err := tx.Post(id)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Post not found"}
+		}
+		if err != nil {
+			return err
+		}
+
+		allowed, err := canModifyPost(tx, user.ID, id)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Only the author of the post or a moderator can accept an answer"}
+		}
+
+		archived, err := isTopicArchived(tx, before.TopicID)
+		if err != nil {
+			return err
+		}
+		if archived {
+			return &statusError{http.StatusForbidden, "This topic is archived and read-only"}
+		}
+
+		// The answer must be a visible top-level comment on this post
+		var accepted *int
+		if req.CommentID != 0 {
+			comment, err := tx.Comment(req.CommentID)
+			if err == errNotFound {
+				return &statusError{http.StatusNotFound, "Comment not found"}
+			}
+			if err != nil {
+				return err
+			}
+			if comment.PostID != id {
+				return &statusError{http.StatusBadRequest, "Comment belongs to a different post"}
+			}
+			if comment.ParentID != nil {
+				return &statusError{http.StatusBadRequest, "Only top-level comments can be accepted as the answer"}
+			}
+			accepted = &req.CommentID
+		}
+
+		if updated, err = tx.AcceptAnswer(id, accepted); err != nil {
+			return err
+		}
+		// Choosing the answer to someone else's question is a moderator action
+		if before.Author != user.Username {
+			action := "answer.accept"
+			if accepted == nil {
+				action = "answer.unaccept"
+			}
+			if err := tx.RecordAudit(user.ID, action, "post", id, before, updated); err != nil {
+				return err
+			}
+		}
+		votes, err := tx.PostVotes(user.ID, []int{id})
+		updated.MyVote = votes[id]
+		return err
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to update accepted answer")
+		return
+	}
+	published := updated
+	published.MyVote = 0
+	s.events.publish(Event{"post.updated", updated.TopicID, updated.ID, published})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		writeError(w, "Failed to encode post", http.StatusInternalServerError)
+	}
+}
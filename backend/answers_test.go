@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAcceptAnswer(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		username string
+		audited  bool
+	}{
+		{"author", "bob", false},
+		{"topic moderator", "tom", true},
+		{"global moderator", "mona", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			w := ts.do(http.MethodPut, "/posts/1/answer", tt.username, AcceptAnswerRequest{CommentID: ts.comment.ID})
+			wantStatus(t, w, http.StatusOK)
+			post := decode[Post](t, w)
+			if !post.IsResolved || post.AcceptedCommentID == nil || *post.AcceptedCommentID != ts.comment.ID {
+				t.Errorf("post = %+v, want comment %d accepted", post, ts.comment.ID)
+			}
+
+			w = ts.do(http.MethodDelete, "/posts/1/answer", tt.username, nil)
+			wantStatus(t, w, http.StatusOK)
+			if post := decode[Post](t, w); post.IsResolved || post.AcceptedCommentID != nil {
+				t.Errorf("post = %+v, want no accepted answer", post)
+			}
+
+			// Only choosing the answer to someone else's question is audited
+			audited := ts.auditCount("answer.accept", ts.post.ID) == 1 && ts.auditCount("answer.unaccept", ts.post.ID) == 1
+			if audited != tt.audited {
+				t.Errorf("audited = %v, want %v", audited, tt.audited)
+			}
+		})
+	}
+
+	t.Run("comment on another post", func(t *testing.T) {
+		ts := newTestServer(t)
+		other, err := ts.store.CreateComment(ts.homeworkPost.ID, nil, 0, ts.users["bob"].ID, "Try this")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := ts.do(http.MethodPut, "/posts/1/answer", "bob", AcceptAnswerRequest{CommentID: other.ID})
+		wantError(t, w, http.StatusBadRequest, "")
+	})
+
+	t.Run("reply", func(t *testing.T) {
+		ts := newTestServer(t)
+		reply, err := ts.store.CreateComment(ts.post.ID, &ts.comment.ID, 1, ts.users["bob"].ID, "Thanks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := ts.do(http.MethodPut, "/posts/1/answer", "bob", AcceptAnswerRequest{CommentID: reply.ID})
+		wantError(t, w, http.StatusBadRequest, "")
+	})
+
+	t.Run("missing commentId", func(t *testing.T) {
+		ts := newTestServer(t)
+		wantError(t, ts.do(http.MethodPut, "/posts/1/answer", "bob", map[string]any{}), http.StatusBadRequest, "commentId")
+	})
+
+	accept := AcceptAnswerRequest{CommentID: 1}
+	runRequests(t, []request{
+		{"anonymous", http.MethodPut, "/posts/1/answer", "", accept, http.StatusUnauthorized},
+		{"another member", http.MethodPut, "/posts/1/answer", "carol", accept, http.StatusForbidden},
+		{"moderator of another topic", http.MethodPut, "/posts/2/answer", "tom", accept, http.StatusForbidden},
+		{"read-only", http.MethodPut, "/posts/1/answer", "rita", accept, http.StatusForbidden},
+		{"banned", http.MethodDelete, "/posts/1/answer", "ben", nil, http.StatusForbidden},
+		{"archived topic", http.MethodPut, "/posts/3/answer", "bob", accept, http.StatusForbidden},
+		{"unknown post", http.MethodPut, "/posts/9999/answer", "alice", accept, http.StatusNotFound},
+		{"unknown comment", http.MethodPut, "/posts/1/answer", "bob", AcceptAnswerRequest{CommentID: 9999}, http.StatusNotFound},
+		{"invalid JSON", http.MethodPut, "/posts/1/answer", "bob", "{", http.StatusBadRequest},
+		{"invalid post id", http.MethodPut, "/posts/x/answer", "bob", accept, http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/posts/1/answer", "bob", accept, http.StatusMethodNotAllowed},
+	})
+}
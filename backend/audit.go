@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// AuditEntry is one privileged action in the audit log. Before and After
+// are JSON snapshots of the target, null where there is nothing to show
+// (no Before for something created, no After for something deleted).
+type AuditEntry struct {
+	ID         int             `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"targetType"`
+	TargetID   int             `json:"targetId"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// AuditFilter narrows down the audit log for AuditLog. Empty fields
+// match everything; From and To are inclusive.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	From, To   time.Time
+}
+
+// auditHandler handles GET /audit and pages through the audit log,
+// newest first. Optional filters: actor (username, in any case),
+// action (e.g. post.edit), targetType, and from/to (RFC 3339 times,
+// inclusive).
+// Only moderators can read the log.
+func (s *server) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := s.requirePermission(w, r, permViewAudit, 0, "Only moderators can view the audit log"); !ok {
+		return
+	}
+
+	page, err := parsePageRequest(r, auditOrders)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := AuditFilter{Actor: q.Get("actor"), Action: q.Get("action"), TargetType: q.Get("targetType")}
+	for _, bound := range []struct {
+		param string
+		t     *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := q.Get(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, "Invalid "+bound.param+" parameter; use an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		*bound.t = t.UTC()
+	}
+
+	entries, err := s.store.AuditLog(filter, page)
+	if err != nil {
+		writeError(w, "Failed to query audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		writeError(w, "Failed to encode audit log", http.StatusInternalServerError)
+	}
+}
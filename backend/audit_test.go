@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	ts := newTestServer(t)
+	wantStatus(t, ts.do(http.MethodPut, "/posts/1", "mona", UpdatePostRequest{Title: "Edited", Content: "By mona"}), http.StatusOK)
+	wantStatus(t, ts.do(http.MethodDelete, "/comments/1", "tom", nil), http.StatusNoContent)
+	wantStatus(t, ts.do(http.MethodPost, "/users/5/ban", "alice", SanctionRequest{Reason: "Spam"}), http.StatusCreated)
+
+	hourAgo := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	inAnHour := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, tt := range []struct {
+		name   string
+		params url.Values
+		want   []string
+	}{
+		{"everything", nil, []string{"user.ban", "comment.delete", "post.edit"}},
+		{"actor", url.Values{"actor": {"mona"}}, []string{"post.edit"}},
+		{"actor in another case", url.Values{"actor": {"Mona"}}, []string{"post.edit"}},
+		{"action", url.Values{"action": {"comment.delete"}}, []string{"comment.delete"}},
+		{"targetType", url.Values{"targetType": {"user"}}, []string{"user.ban"}},
+		{"time range", url.Values{"from": {hourAgo}, "to": {inAnHour}}, []string{"user.ban", "comment.delete", "post.edit"}},
+		{"from the future", url.Values{"from": {inAnHour}}, nil},
+		{"until an hour ago", url.Values{"to": {hourAgo}}, nil},
+		{"one page", url.Values{"limit": {"1"}}, []string{"user.ban"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ts.do(http.MethodGet, "/audit?"+tt.params.Encode(), "mona", nil)
+			wantStatus(t, w, http.StatusOK)
+			page := decode[Page[AuditEntry]](t, w)
+			var got []string
+			for _, e := range page.Items {
+				got = append(got, e.Action)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("actions = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("actions = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	runRequests(t, []request{
+		{"anonymous", http.MethodGet, "/audit", "", nil, http.StatusUnauthorized},
+		{"member", http.MethodGet, "/audit", "bob", nil, http.StatusForbidden},
+		{"topic moderator", http.MethodGet, "/audit", "tom", nil, http.StatusForbidden},
+		{"invalid from", http.MethodGet, "/audit?from=yesterday", "mona", nil, http.StatusBadRequest},
+		{"invalid to", http.MethodGet, "/audit?to=2024-01-01", "mona", nil, http.StatusBadRequest},
+		{"invalid cursor", http.MethodGet, "/audit?cursor=x", "mona", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/audit", "mona", nil, http.StatusMethodNotAllowed},
+	})
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sessionCookieName is the cookie that carries the session token for browsers.
+const sessionCookieName = "forum_session"
+
+// sessionTTL is how long a session token stays valid after login.
+const sessionTTL = 7 * 24 * time.Hour
+
+// minPasswordLength is the shortest password accepted at registration.
+const minPasswordLength = 8
+
+// maxPasswordBytes is where bcrypt stops reading a password.
+const maxPasswordBytes = 72
+
+// seedPassword is the password given to the seeded users.
+const seedPassword = "password"
+
+// contextKey is the type for values stored in a request context.
+type contextKey int
+
+const userContextKey contextKey = iota
+
+// RegisterRequest represents the JSON body for registration.
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoginRequest represents the JSON body for login.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoginResponse is returned by /register and /login.
+type LoginResponse struct {
+	Token string `json:"token"`
+	User  User   `json:"user"`
+}
+
+// loadSessionSecret returns the HMAC key used to sign session tokens:
+// FORUM_SESSION_SECRET, or a random key so tokens only live as long as
+// the process.
+func loadSessionSecret() ([]byte, error) {
+	if secret := os.Getenv("FORUM_SESSION_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	slog.Warn("FORUM_SESSION_SECRET not set, using a random key; sessions will not survive a restart")
+	return secret, nil
+}
+
+// hashPassword returns the bcrypt hash of a password.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// signSessionID returns the token handed to clients for a session id.
+func (s *server) signSessionID(id string) string {
+	mac := hmac.New(sha256.New, s.sessionSecret)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySessionToken checks the signature of a token and returns its session id.
+func (s *server) verifySessionToken(token string) (string, bool) {
+	id, _, found := strings.Cut(token, ".")
+	if !found || id == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(s.signSessionID(id)), []byte(token)) {
+		return "", false
+	}
+	return id, true
+}
+
+// createSession stores a new session for the user and returns its signed token.
+func (s *server) createSession(st UserStore, userID int) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	id := hex.EncodeToString(raw)
+	expires := time.Now().UTC().Add(sessionTTL)
+
+	if err := st.CreateSession(id, userID, expires); err != nil {
+		return "", time.Time{}, err
+	}
+	return s.signSessionID(id), expires, nil
+}
+
+// tokenFromRequest reads the session token from the Authorization header,
+// falling back to the session cookie.
+func tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, found := strings.CutPrefix(header, "Bearer "); found {
+			return strings.TrimSpace(token)
+		}
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// userForToken resolves a session token to its user.
+// It returns nil if the token is invalid or expired.
+func (s *server) userForToken(token string) (*User, error) {
+	id, ok := s.verifySessionToken(token)
+	if !ok {
+		return nil, nil
+	}
+
+	user, err := s.store.SessionUser(id)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Sanction, err = s.store.ActiveSanction(user.ID); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// withSession resolves the caller's session token, if any, and stores
+// the matching user in the request context.
+func (s *server) withSession(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFromRequest(r)
+		if token == "" {
+			handler(w, r)
+			return
+		}
+
+		user, err := s.userForToken(token)
+		if err != nil {
+			writeError(w, "Failed to check session", http.StatusInternalServerError)
+			return
+		}
+		if user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+		}
+		handler(w, r)
+	}
+}
+
+// currentUser returns the logged-in user for the request, or nil.
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
+}
+
+// requireUser returns the logged-in user, or writes 401 and returns false.
+func requireUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	user := currentUser(r)
+	if user == nil {
+		writeError(w, "Login required", http.StatusUnauthorized)
+		return nil, false
+	}
+	return user, true
+}
+
+// writeSession sets the session cookie and writes { token, user }
+// with the given status.
+func writeSession(w http.ResponseWriter, user User, token string, expires time.Time, status int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(LoginResponse{Token: token, User: user}); err != nil {
+		writeError(w, "Failed to encode session", http.StatusInternalServerError)
+	}
+}
+
+// registerHandler handles POST /register
+// It takes { "username": "carol", "password": "..." }, creates the user
+// and logs them in.
+func (s *server) registerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var v validator
+	username := v.username("username", req.Username)
+	v.password("password", req.Password)
+	if !v.valid(w) {
+		return
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		writeError(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	// The new user and their first session are created together
+	var user User
+	var token string
+	var expires time.Time
+	err = s.store.Tx(func(tx Store) error {
+		var err error
+		user, err = tx.CreateUser(username, hash, roleMember)
+		if err == errUsernameTaken {
+			return &statusError{http.StatusConflict, "Username is already taken"}
+		}
+		if err != nil {
+			return err
+		}
+		token, expires, err = s.createSession(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to create user")
+		return
+	}
+
+	writeSession(w, user, token, expires, http.StatusCreated)
+}
+
+// loginHandler handles POST /login
+// It takes { "username": "alice", "password": "..." }, checks the password
+// and returns { token, user }.
+func (s *server) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var v validator
+	username := v.text("username", "Username", req.Username, 1, maxUsernameLength)
+	v.check(req.Password != "", "password", "Password is required")
+	if !v.valid(w) {
+		return
+	}
+
+	user, hash, err := s.store.UserByName(username)
+	if err != nil && err != errNotFound {
+		writeError(w, "Failed to query user", http.StatusInternalServerError)
+		return
+	}
+	// Unknown users and users without a password get the same answer
+	// as a wrong password.
+	if err == errNotFound || hash == "" ||
+		bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+		writeError(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	// Banned and suspended users are told why instead of getting a session
+	sanction, err := s.store.ActiveSanction(user.ID)
+	if err != nil {
+		writeError(w, "Failed to check sanctions", http.StatusInternalServerError)
+		return
+	}
+	if sanction != nil {
+		writeError(w, sanctionMessage(sanction), http.StatusForbidden)
+		return
+	}
+
+	token, expires, err := s.createSession(s.store, user.ID)
+	if err != nil {
+		writeError(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	writeSession(w, user, token, expires, http.StatusOK)
+}
+
+// logoutHandler handles POST /logout and ends the caller's session.
+func (s *server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if id, ok := s.verifySessionToken(tokenFromRequest(r)); ok {
+		if err := s.store.DeleteSession(id); err != nil {
+			writeError(w, "Failed to end session", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// meHandler handles GET /me and returns the logged-in user with the
+// topics they moderate and any sanction against them. Sanctioned users
+// can still use it, so the client can explain why writes are refused.
+func (s *server) meHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.store.User(current.ID)
+	if err != nil {
+		writeError(w, "Failed to query user", http.StatusInternalServerError)
+		return
+	}
+	user.Sanction = current.Sanction
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(user); err != nil {
+		writeError(w, "Failed to encode user", http.StatusInternalServerError)
+	}
+}
+
+// runPasswordCommand handles "password <username>", which sets a user's
+// password to the first line read from in. Users created before
+// password login have none, and this is how they get one.
+func runPasswordCommand(store Store, args []string, in io.Reader) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a username")
+	}
+	username := args[0]
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	password := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	var v validator
+	v.password("password", password)
+	if v.errs != nil {
+		return v.errs
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return store.Tx(func(tx Store) error {
+		user, _, err := tx.UserByName(username)
+		if err == errNotFound {
+			return fmt.Errorf("no user named %q", username)
+		}
+		if err != nil {
+			return err
+		}
+		return tx.SetPassword(user.ID, hash)
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	ts := newTestServer(t)
+
+	w := ts.do(http.MethodPost, "/register", "", RegisterRequest{Username: "dave", Password: "long enough"})
+	wantStatus(t, w, http.StatusCreated)
+	if len(w.Result().Cookies()) == 0 {
+		t.Error("no session cookie set")
+	}
+	resp := decode[LoginResponse](t, w)
+	if resp.User.Username != "dave" || resp.User.Role != roleMember {
+		t.Errorf("registered user = %+v, want dave, a member", resp.User)
+	}
+
+	// The new session works straight away
+	ts.tokens["dave"] = resp.Token
+	w = ts.do(http.MethodGet, "/me", "dave", nil)
+	wantStatus(t, w, http.StatusOK)
+
+	runRequests(t, []request{
+		{"taken", http.MethodPost, "/register", "", RegisterRequest{"bob", "long enough"}, http.StatusConflict},
+		{"taken in another case", http.MethodPost, "/register", "", RegisterRequest{"Bob", "long enough"}, http.StatusConflict},
+		{"taken in capitals", http.MethodPost, "/register", "", RegisterRequest{"ALICE", "long enough"}, http.StatusConflict},
+		{"wrong method", http.MethodGet, "/register", "", nil, http.StatusMethodNotAllowed},
+		{"invalid JSON", http.MethodPost, "/register", "", "{", http.StatusBadRequest},
+	})
+
+	for _, tt := range []struct {
+		name  string
+		req   RegisterRequest
+		field string
+	}{
+		{"short password", RegisterRequest{"dave2", "short"}, "password"},
+		{"short username", RegisterRequest{"da", "long enough"}, "username"},
+		{"bad username", RegisterRequest{"da ve", "long enough"}, "username"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ts.do(http.MethodPost, "/register", "", tt.req)
+			wantError(t, w, http.StatusBadRequest, tt.field)
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		req    any
+		status int
+		field  string
+	}{
+		{"correct password", LoginRequest{"bob", fixturePassword}, http.StatusOK, ""},
+		{"username in another case", LoginRequest{"BoB", fixturePassword}, http.StatusOK, ""},
+		{"wrong password", LoginRequest{"bob", "wrong password"}, http.StatusUnauthorized, ""},
+		{"unknown user", LoginRequest{"nobody", fixturePassword}, http.StatusUnauthorized, ""},
+		{"missing password", LoginRequest{"bob", ""}, http.StatusBadRequest, "password"},
+		{"missing username", LoginRequest{"", fixturePassword}, http.StatusBadRequest, "username"},
+		{"banned user", LoginRequest{"ben", fixturePassword}, http.StatusForbidden, ""},
+		{"invalid JSON", "{", http.StatusBadRequest, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			w := ts.do(http.MethodPost, "/login", "", tt.req)
+			if tt.status != http.StatusOK {
+				wantError(t, w, tt.status, tt.field)
+				return
+			}
+			wantStatus(t, w, http.StatusOK)
+			resp := decode[LoginResponse](t, w)
+			if resp.Token == "" || resp.User.ID != ts.users["bob"].ID {
+				t.Errorf("login response = %+v", resp)
+			}
+		})
+	}
+
+	t.Run("wrong method", func(t *testing.T) {
+		ts := newTestServer(t)
+		wantError(t, ts.do(http.MethodGet, "/login", "", nil), http.StatusMethodNotAllowed, "")
+	})
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	ts := newTestServer(t)
+	limit := ts.config.RateLimits.Login.requests
+	for range limit {
+		w := ts.do(http.MethodPost, "/login", "", LoginRequest{"bob", "wrong password"})
+		wantStatus(t, w, http.StatusUnauthorized)
+	}
+
+	w := ts.do(http.MethodPost, "/login", "", LoginRequest{"bob", fixturePassword})
+	wantError(t, w, http.StatusTooManyRequests, "")
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ts := newTestServer(t)
+
+	wantStatus(t, ts.do(http.MethodPost, "/logout", "bob", nil), http.StatusNoContent)
+	// The token no longer works
+	wantError(t, ts.do(http.MethodGet, "/me", "bob", nil), http.StatusUnauthorized, "")
+
+	// Logging out without a session is not an error
+	wantStatus(t, ts.do(http.MethodPost, "/logout", "", nil), http.StatusNoContent)
+	wantError(t, ts.do(http.MethodGet, "/logout", "", nil), http.StatusMethodNotAllowed, "")
+}
+
+func TestMe(t *testing.T) {
+	ts := newTestServer(t)
+
+	w := ts.do(http.MethodGet, "/me", "tom", nil)
+	wantStatus(t, w, http.StatusOK)
+	me := decode[User](t, w)
+	if me.Username != "tom" || len(me.ModeratedTopicIDs) != 1 || me.ModeratedTopicIDs[0] != ts.general.ID {
+		t.Errorf("/me for tom = %+v, want him as moderator of General", me)
+	}
+
+	// Banned users can still see who they are, and why they are banned
+	w = ts.do(http.MethodGet, "/me", "ben", nil)
+	wantStatus(t, w, http.StatusOK)
+	if me := decode[User](t, w); me.Sanction == nil {
+		t.Error("/me for a banned user has no sanction")
+	}
+
+	// The session cookie works as well as the header
+	r := httptest.NewRequest(http.MethodGet, "/me", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: ts.token("bob")})
+	w = httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, r)
+	wantStatus(t, w, http.StatusOK)
+
+	wantError(t, ts.do(http.MethodGet, "/me", "", nil), http.StatusUnauthorized, "")
+	wantError(t, ts.do(http.MethodPost, "/me", "bob", nil), http.StatusMethodNotAllowed, "")
+
+	// A token with a bad signature is ignored
+	ts.tokens["forged"] = ts.token("bob") + "x"
+	wantError(t, ts.do(http.MethodGet, "/me", "forged", nil), http.StatusUnauthorized, "")
+}
+
+func TestPasswordCommand(t *testing.T) {
+	ts := newTestServer(t)
+	// Users from before password login have no password and cannot log in
+	if _, err := ts.store.CreateUser("olga", "", roleMember); err != nil {
+		t.Fatal(err)
+	}
+	wantError(t, ts.do(http.MethodPost, "/login", "", LoginRequest{"olga", ""}), http.StatusBadRequest, "password")
+
+	if err := runPasswordCommand(ts.store, []string{"olga"}, strings.NewReader("new password\n")); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, ts.do(http.MethodPost, "/login", "", LoginRequest{"olga", "new password"}), http.StatusOK)
+
+	// A new password ends the user's sessions
+	if err := runPasswordCommand(ts.store, []string{"bob"}, strings.NewReader("another password\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	wantError(t, ts.do(http.MethodGet, "/me", "bob", nil), http.StatusUnauthorized, "")
+	wantError(t, ts.do(http.MethodPost, "/login", "", LoginRequest{"bob", fixturePassword}), http.StatusUnauthorized, "")
+	wantStatus(t, ts.do(http.MethodPost, "/login", "", LoginRequest{"bob", "another password"}), http.StatusOK)
+
+	for _, tt := range []struct {
+		name  string
+		args  []string
+		input string
+	}{
+		{"no username", nil, "new password\n"},
+		{"unknown user", []string{"nobody"}, "new password\n"},
+		{"short password", []string{"olga"}, "short\n"},
+		{"no password", []string{"olga"}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runPasswordCommand(ts.store, tt.args, strings.NewReader(tt.input)); err == nil {
+				t.Error("runPasswordCommand succeeded, want an error")
+			}
+		})
+	}
+}
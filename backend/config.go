@@ -0,0 +1,456 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings that can change without editing code. Each
+// setting comes from, in increasing order of priority, its default, the
+// config file, a FORUM_* environment variable and a command-line flag.
+type Config struct {
+	Addr            string
+	Store           string
+	DatabasePath    string
+	PostgresURL     string
+	AllowedOrigins  []string
+	SeedOnStart     bool
+	LogLevel        slog.Level
+	TrashRetention  time.Duration
+	DuplicateWindow time.Duration
+	RateLimits      RateLimits
+
+	// sources records where loadConfig took each setting's value from,
+	// keyed by setting name, for printConfig. Settings left at their
+	// default are not in it.
+	sources map[string]string
+}
+
+// RateLimits are the limits on the write endpoints. Reads are never
+// limited.
+type RateLimits struct {
+	Register rateLimit
+	Login    rateLimit
+	Posts    rateLimit
+	Comments rateLimit
+	Edits    rateLimit
+	Votes    rateLimit
+	Reports  rateLimit
+}
+
+// defaultConfig is the configuration when nothing is set: the server
+// listens on :8080, stores its data in forum.db and allows every origin,
+// which suits local development.
+func defaultConfig() Config {
+	return Config{
+		Addr:            ":8080",
+		Store:           storeSQLite,
+		DatabasePath:    "forum.db",
+		AllowedOrigins:  []string{"*"},
+		LogLevel:        slog.LevelInfo,
+		TrashRetention:  defaultTrashRetention,
+		DuplicateWindow: 10 * time.Minute,
+		RateLimits: RateLimits{
+			Register: rateLimit{5, time.Hour},
+			Login:    rateLimit{10, 5 * time.Minute},
+			Posts:    rateLimit{5, 10 * time.Minute},
+			Comments: rateLimit{20, 10 * time.Minute},
+			Edits:    rateLimit{30, 10 * time.Minute},
+			Votes:    rateLimit{60, time.Minute},
+			Reports:  rateLimit{10, time.Hour},
+		},
+	}
+}
+
+// setting is one configurable value. Its name is the key in the config
+// file and the flag name; the environment variable is derived from it.
+type setting struct {
+	name  string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, value string) error
+}
+
+// env is the environment variable for s, e.g. FORUM_LOG_LEVEL.
+func (s setting) env() string {
+	return "FORUM_" + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+// isBool lets the flag be given without a value, as in -seed.
+func (s setting) isBool() bool {
+	return s.name == "seed"
+}
+
+var settings = []setting{
+	{
+		name:  "addr",
+		usage: "address to listen on, host:port",
+		get:   func(c *Config) string { return c.Addr },
+		set: func(c *Config, v string) error {
+			host, port, err := net.SplitHostPort(v)
+			if err != nil {
+				return err
+			}
+			if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+				return fmt.Errorf("invalid port %q", port)
+			}
+			c.Addr = net.JoinHostPort(host, port)
+			return nil
+		},
+	},
+	{
+		name:  "store",
+		usage: "where the server keeps its data: sqlite, postgres, or memory for a throwaway forum",
+		get:   func(c *Config) string { return c.Store },
+		set: func(c *Config, v string) error {
+			if v != storeSQLite && v != storePostgres && v != storeMemory {
+				return errors.New("want sqlite, postgres or memory")
+			}
+			c.Store = v
+			return nil
+		},
+	},
+	{
+		name:  "db",
+		usage: "path of the SQLite database file",
+		get:   func(c *Config) string { return c.DatabasePath },
+		set: func(c *Config, v string) error {
+			if strings.TrimSpace(v) == "" {
+				return errors.New("must not be empty")
+			}
+			if strings.ContainsAny(v, "?#") {
+				return errors.New("must not contain ? or #")
+			}
+			c.DatabasePath = v
+			return nil
+		},
+	},
+	{
+		name:  "postgres-url",
+		usage: "connection URL of the Postgres database, for store postgres",
+		// The password is never printed
+		get: func(c *Config) string {
+			if u, err := url.Parse(c.PostgresURL); err == nil {
+				return u.Redacted()
+			}
+			return ""
+		},
+		set: func(c *Config, v string) error {
+			u, err := url.Parse(v)
+			if err != nil {
+				return err
+			}
+			if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+				return errors.New("want a postgres:// URL")
+			}
+			c.PostgresURL = v
+			return nil
+		},
+	},
+	{
+		name:  "allowed-origins",
+		usage: "comma-separated origins that may call the API from a browser, or * for any",
+		get:   func(c *Config) string { return strings.Join(c.AllowedOrigins, ",") },
+		set: func(c *Config, v string) error {
+			var origins []string
+			for _, origin := range strings.Split(v, ",") {
+				origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+				if origin == "" {
+					continue
+				}
+				if origin != "*" {
+					u, err := url.Parse(origin)
+					if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+						return fmt.Errorf("invalid origin %q, want scheme://host[:port]", origin)
+					}
+				}
+				origins = append(origins, origin)
+			}
+			c.AllowedOrigins = origins
+			return nil
+		},
+	},
+	{
+		name:  "seed",
+		usage: "insert sample data into empty tables on start",
+		get:   func(c *Config) string { return strconv.FormatBool(c.SeedOnStart) },
+		set: func(c *Config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return errors.New("want true or false")
+			}
+			c.SeedOnStart = b
+			return nil
+		},
+	},
+	{
+		name:  "log-level",
+		usage: "least severe log messages to print: debug, info, warn or error",
+		get:   func(c *Config) string { return strings.ToLower(c.LogLevel.String()) },
+		set: func(c *Config, v string) error {
+			switch strings.ToLower(v) {
+			case "debug":
+				c.LogLevel = slog.LevelDebug
+			case "info":
+				c.LogLevel = slog.LevelInfo
+			case "warn":
+				c.LogLevel = slog.LevelWarn
+			case "error":
+				c.LogLevel = slog.LevelError
+			default:
+				return errors.New("want debug, info, warn or error")
+			}
+			return nil
+		},
+	},
+	{
+		name:  "trash-retention",
+		usage: "how long deleted content stays in the trash, e.g. 168h; 0 keeps it forever",
+		get:   func(c *Config) string { return formatDuration(c.TrashRetention) },
+		set:   durationSetter(func(c *Config) *time.Duration { return &c.TrashRetention }),
+	},
+	{
+		name:  "duplicate-window",
+		usage: "how long the same post or comment cannot be posted again; 0 allows it",
+		get:   func(c *Config) string { return formatDuration(c.DuplicateWindow) },
+		set:   durationSetter(func(c *Config) *time.Duration { return &c.DuplicateWindow }),
+	},
+	rateLimitSetting("register", "registrations", func(c *Config) *rateLimit { return &c.RateLimits.Register }),
+	rateLimitSetting("login", "logins", func(c *Config) *rateLimit { return &c.RateLimits.Login }),
+	rateLimitSetting("posts", "new posts", func(c *Config) *rateLimit { return &c.RateLimits.Posts }),
+	rateLimitSetting("comments", "new comments", func(c *Config) *rateLimit { return &c.RateLimits.Comments }),
+	rateLimitSetting("edits", "edits and deletions of posts and comments", func(c *Config) *rateLimit { return &c.RateLimits.Edits }),
+	rateLimitSetting("votes", "votes", func(c *Config) *rateLimit { return &c.RateLimits.Votes }),
+	rateLimitSetting("reports", "reports", func(c *Config) *rateLimit { return &c.RateLimits.Reports }),
+}
+
+// durationSetter sets a duration that must not be negative.
+func durationSetter(field func(c *Config) *time.Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.New("must not be negative")
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// rateLimitSetting is the setting rate-limit-<name>, written as
+// requests/period, e.g. 5/10m.
+func rateLimitSetting(name, what string, field func(c *Config) *rateLimit) setting {
+	return setting{
+		name:  "rate-limit-" + name,
+		usage: what + " allowed per caller, as requests/period",
+		get: func(c *Config) string {
+			limit := field(c)
+			return strconv.Itoa(limit.requests) + "/" + formatDuration(limit.per)
+		},
+		set: func(c *Config, v string) error {
+			requests, per, ok := strings.Cut(v, "/")
+			n, err := strconv.Atoi(strings.TrimSpace(requests))
+			if !ok || err != nil || n <= 0 {
+				return errors.New("want a positive number of requests per period, e.g. 5/10m")
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(per))
+			if err != nil || d <= 0 {
+				return errors.New("want a positive period, e.g. 5/10m")
+			}
+			*field(c) = rateLimit{n, d}
+			return nil
+		},
+	}
+}
+
+// formatDuration is time.Duration.String without the trailing zero
+// units, so 10m0s is written 10m.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// flagValue stores a flag's value until the file and environment have
+// been applied, since flags take priority over both.
+type flagValue struct {
+	s      setting
+	values map[string]string
+}
+
+func (f flagValue) String() string { return "" }
+
+func (f flagValue) Set(v string) error {
+	f.values[f.s.name] = v
+	return nil
+}
+
+func (f flagValue) IsBoolFlag() bool { return f.s.isBool() }
+
+// loadConfig builds the configuration from the config file, the
+// environment and the flags in args, and returns the arguments left
+// after the flags. The config file is named by -config or FORUM_CONFIG
+// and is optional.
+func loadConfig(args []string) (Config, []string, error) {
+	c := defaultConfig()
+	c.sources = make(map[string]string)
+
+	fs := flag.NewFlagSet("forum", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configPath := fs.String("config", os.Getenv("FORUM_CONFIG"), "")
+	flags := make(map[string]string)
+	for _, s := range settings {
+		fs.Var(flagValue{s, flags}, s.name, s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, nil, err
+	}
+
+	// apply sets s from source; where names the value in an error
+	apply := func(s setting, v, source, where string) error {
+		if err := s.set(&c, v); err != nil {
+			return fmt.Errorf("%s: %w", where, err)
+		}
+		c.sources[s.name] = source
+		return nil
+	}
+
+	if *configPath != "" {
+		values, err := readConfigFile(*configPath)
+		if err != nil {
+			return c, nil, err
+		}
+		for _, s := range settings {
+			if v, ok := values[s.name]; ok {
+				if err := apply(s, v, *configPath, *configPath+": "+s.name); err != nil {
+					return c, nil, err
+				}
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env()); ok {
+			if err := apply(s, v, s.env(), s.env()); err != nil {
+				return c, nil, err
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flags[s.name]; ok {
+			if err := apply(s, v, "flag", "-"+s.name); err != nil {
+				return c, nil, err
+			}
+		}
+	}
+
+	if len(c.AllowedOrigins) == 0 {
+		return c, nil, errors.New("allowed-origins: at least one origin is required")
+	}
+	if c.Store == storePostgres && c.PostgresURL == "" {
+		return c, nil, errors.New("postgres-url: required with store postgres")
+	}
+	return c, fs.Args(), nil
+}
+
+// readConfigFile reads a JSON object of settings, such as
+//
+//	{"addr": ":9000", "allowed-origins": ["http://localhost:5173"], "seed": true}
+//
+// Lists are joined with commas, so they mean the same as in a flag.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, v := range raw {
+		if !slices.ContainsFunc(settings, func(s setting) bool { return s.name == name }) {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		switch v := v.(type) {
+		case string:
+			values[name] = v
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		case float64:
+			values[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s: %s: list items must be strings", path, name)
+				}
+				items[i] = s
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			return nil, fmt.Errorf("%s: %s: unsupported value", path, name)
+		}
+	}
+	return values, nil
+}
+
+// printConfig writes every setting with its value and where the value
+// came from.
+func printConfig(w io.Writer, c Config) {
+	fmt.Fprintln(w, "Configuration:")
+	for _, s := range settings {
+		source := cmp.Or(c.sources[s.name], "default")
+		fmt.Fprintf(w, "  %-22s %-32s (%s)\n", s.name, s.get(&c), source)
+	}
+}
+
+// configUsage describes the settings for the usage message.
+func configUsage() string {
+	defaults := defaultConfig()
+	var b strings.Builder
+	b.WriteString("\nOptions, before the command (each can also be set as FORUM_<NAME> or in the\nconfig file, with flags taking priority over the environment over the file):\n")
+	fmt.Fprintf(&b, "  -%-22s %s\n", "config FILE", "JSON file of settings (default $FORUM_CONFIG, if set)")
+	for _, s := range settings {
+		fmt.Fprintf(&b, "  -%-22s %s (default %s)\n", s.name, s.usage, s.get(&defaults))
+	}
+	return b.String()
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin for a request from
+// origin, or "" if that origin is not allowed.
+func (c *Config) allowOrigin(origin string) string {
+	if slices.Contains(c.AllowedOrigins, "*") {
+		return "*"
+	}
+	if origin != "" && slices.Contains(c.AllowedOrigins, origin) {
+		return origin
+	}
+	return ""
+}
+
+// initLogging sends log messages at or above the configured level to
+// standard error.
+func initLogging(level slog.Level) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+}
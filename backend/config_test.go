@@ -0,0 +1,169 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// clearConfigEnv unsets every FORUM_* variable loadConfig reads until
+// the test ends, so the environment the tests run in does not count.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	names := []string{"FORUM_CONFIG"}
+	for _, s := range settings {
+		names = append(names, s.env())
+	}
+	for _, name := range names {
+		t.Setenv(name, "") // restores the variable afterwards
+		os.Unsetenv(name)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string // contents of the config file, if any
+		env  map[string]string
+		args []string
+		// The setting checked, its value and where it came from: a
+		// source of "file" is the config file
+		setting, want, source string
+		rest                  []string
+	}{
+		{name: "default", setting: "addr", want: ":8080", source: "default"},
+		{name: "file over default", file: `{"addr": ":7000"}`, setting: "addr", want: ":7000", source: "file"},
+		{name: "env over file", file: `{"addr": ":7000"}`, env: map[string]string{"FORUM_ADDR": ":9000"},
+			setting: "addr", want: ":9000", source: "FORUM_ADDR"},
+		{name: "flag over env", file: `{"addr": ":7000"}`, env: map[string]string{"FORUM_ADDR": ":9000"}, args: []string{"-addr", ":9001"},
+			setting: "addr", want: ":9001", source: "flag"},
+		{name: "flag before a command", args: []string{"-store", "memory", "migrate", "up"},
+			setting: "store", want: "memory", source: "flag", rest: []string{"migrate", "up"}},
+		{name: "bool flag", args: []string{"-seed"}, setting: "seed", want: "true", source: "flag"},
+		{name: "list in file", file: `{"allowed-origins": ["http://a.example", "https://b.example/"]}`,
+			setting: "allowed-origins", want: "http://a.example,https://b.example", source: "file"},
+		{name: "rate limit", env: map[string]string{"FORUM_RATE_LIMIT_POSTS": "3/1m"},
+			setting: "rate-limit-posts", want: "3/1m", source: "FORUM_RATE_LIMIT_POSTS"},
+		{name: "zero duration", args: []string{"-trash-retention", "0"}, setting: "trash-retention", want: "0s", source: "flag"},
+		{name: "log level in capitals", env: map[string]string{"FORUM_LOG_LEVEL": "WARN"},
+			setting: "log-level", want: "warn", source: "FORUM_LOG_LEVEL"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			path := filepath.Join(t.TempDir(), "config.json")
+			if tt.file != "" {
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("FORUM_CONFIG", path)
+			}
+			for name, v := range tt.env {
+				t.Setenv(name, v)
+			}
+
+			c, rest, err := loadConfig(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i := slices.IndexFunc(settings, func(s setting) bool { return s.name == tt.setting })
+			if got := settings[i].get(&c); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.setting, got, tt.want)
+			}
+			source := tt.source
+			if source == "file" {
+				source = path
+			}
+			if got := cmp.Or(c.sources[tt.setting], "default"); got != source {
+				t.Errorf("%s comes from %q, want %q", tt.setting, got, source)
+			}
+			if !slices.Equal(rest, tt.rest) {
+				t.Errorf("arguments left = %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		// where names the setting in the error
+		where string
+	}{
+		{name: "unknown store", args: []string{"-store", "mysql"}, where: "-store"},
+		{name: "unknown store in env", env: map[string]string{"FORUM_STORE": "mysql"}, where: "FORUM_STORE"},
+		{name: "unknown store in file", file: `{"store": "mysql"}`, where: "store"},
+		// A valid flag does not hide an invalid value it overrides
+		{name: "invalid env under a flag", env: map[string]string{"FORUM_STORE": "mysql"}, args: []string{"-store", "memory"}, where: "FORUM_STORE"},
+		{name: "postgres without URL", args: []string{"-store", "postgres"}, where: "postgres-url"},
+		{name: "negative limit", args: []string{"-rate-limit-posts", "-1/10m"}, where: "-rate-limit-posts"},
+		{name: "zero limit", env: map[string]string{"FORUM_RATE_LIMIT_VOTES": "0/1m"}, where: "FORUM_RATE_LIMIT_VOTES"},
+		{name: "limit without period", args: []string{"-rate-limit-login", "5"}, where: "-rate-limit-login"},
+		{name: "negative period", args: []string{"-rate-limit-login", "5/-1m"}, where: "-rate-limit-login"},
+		{name: "malformed period", args: []string{"-rate-limit-edits", "5/often"}, where: "-rate-limit-edits"},
+		{name: "malformed duration", args: []string{"-trash-retention", "7days"}, where: "-trash-retention"},
+		{name: "negative duration", env: map[string]string{"FORUM_DUPLICATE_WINDOW": "-1m"}, where: "FORUM_DUPLICATE_WINDOW"},
+		{name: "port out of range", args: []string{"-addr", ":99999"}, where: "-addr"},
+		{name: "invalid bool", env: map[string]string{"FORUM_SEED": "maybe"}, where: "FORUM_SEED"},
+		{name: "invalid origin", args: []string{"-allowed-origins", "example.com"}, where: "-allowed-origins"},
+		{name: "no origins", args: []string{"-allowed-origins", ","}, where: "allowed-origins"},
+		{name: "unknown setting in file", file: `{"colour": "blue"}`, where: "colour"},
+		{name: "unknown flag", args: []string{"-colour", "blue"}, where: "colour"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("FORUM_CONFIG", path)
+			}
+			for name, v := range tt.env {
+				t.Setenv(name, v)
+			}
+
+			_, _, err := loadConfig(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.where) {
+				t.Errorf("loadConfig = %v, want an error about %s", err, tt.where)
+			}
+		})
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	clearConfigEnv(t)
+	// Each configuration keeps its own sources
+	fromFlag, _, err := loadConfig([]string{"-addr", ":9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byDefault, _, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		c    Config
+		want string
+	}{
+		{"flag", fromFlag, ":9000"},
+		{"default", byDefault, ":8080"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			printConfig(&b, tt.c)
+			want := fmt.Sprintf("  %-22s %-32s (%s)\n", "addr", tt.want, tt.name)
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("printConfig wrote\n%s\nwant a line %q", b.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect is the flavour of SQL a database speaks. Queries are written
+// once, for SQLite with ? placeholders, and rewritten where Postgres
+// needs something else.
+type dialect int
+
+const (
+	sqliteDialect dialect = iota
+	postgresDialect
+)
+
+// rebind turns the ? and ?N placeholders of query into the $N that
+// Postgres expects. Question marks inside string literals are left alone.
+func (d dialect) rebind(query string) string {
+	if d != postgresDialect || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inString = !inString
+		case c == '?' && !inString:
+			b.WriteByte('$')
+			// ?N names the parameter itself; a bare ? takes the next one
+			if i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9' {
+				continue
+			}
+			n++
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// insertID runs an INSERT and returns the id of the new row. Postgres
+// drivers have no LastInsertId, so there the id comes from RETURNING.
+func (d dialect) insertID(q querier, query string, args ...any) (int, error) {
+	if d == postgresDialect {
+		var id int
+		err := q.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// isForeignKeyError reports whether err is a foreign key violation.
+func isForeignKeyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23503" // foreign_key_violation
+	}
+	return false
+}
+
+// isUniqueError reports whether err is a unique constraint violation.
+func isUniqueError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" // unique_violation
+	}
+	return false
+}
+
+// isSerializationError reports whether err means Postgres rolled back
+// a transaction because it conflicted with another one, so it can be
+// tried again.
+func isSerializationError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01" // serialization_failure, deadlock_detected
+	}
+	return false
+}
+
+// database is an open SQL database together with its dialect. Its Exec,
+// Query and QueryRow take SQLite-style queries and rebind them.
+type database struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db *database) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db *database) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *database) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+// Begin starts a transaction in the same dialect. SQLite transactions
+// take the write lock when they begin (see databaseDSN), so they never
+// overlap; Postgres ones are serializable to the same effect, and may
+// fail with a serialization error instead, for withTx to retry.
+func (db *database) Begin() (*transaction, error) {
+	var opts *sql.TxOptions
+	if db.dialect == postgresDialect {
+		opts = &sql.TxOptions{Isolation: sql.LevelSerializable}
+	}
+	tx, err := db.DB.BeginTx(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Tx: tx, dialect: db.dialect}, nil
+}
+
+// transaction is to *sql.Tx what database is to *sql.DB.
+type transaction struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *transaction) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *transaction) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *transaction) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+// querier is implemented by *database and *transaction, so helpers can
+// run either on their own or as part of a transaction.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// maxTxAttempts is how many times withTx runs a transaction that keeps
+// failing because it conflicts with others.
+const maxTxAttempts = 5
+
+// withTx runs fn in a transaction on db, committing if it returns nil
+// and rolling back otherwise. If Postgres rolls it back because it
+// conflicted with another transaction, fn is run again in a new one, so
+// fn must leave nothing behind but its changes to the database.
+func withTx(db *database, fn func(tx *transaction) error) error {
+	for attempt := 1; ; attempt++ {
+		err := runTx(db, fn)
+		if attempt == maxTxAttempts || !isSerializationError(err) {
+			return err
+		}
+	}
+}
+
+// runTx makes one attempt at withTx.
+func runTx(db *database, fn func(tx *transaction) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// fts5Available reports whether the linked SQLite was built with FTS5
+// (go build -tags sqlite_fts5).
+func fts5Available(db *database) bool {
+	var used int
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used); err != nil {
+		return false
+	}
+	return used == 1
+}
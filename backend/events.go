@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event is a change to a post or comment, pushed to clients over
+// GET /events. Type is one of post.created, post.updated, post.deleted,
+// post.pinned, post.unpinned and the same for comment; accepting an
+// answer is a post.updated, and restoring something from the trash a
+// post.created or comment.created. Data is the post or comment as it is
+// now, or just its id once it has been deleted.
+type Event struct {
+	Type    string `json:"type"`
+	TopicID int    `json:"topicId"`
+	PostID  int    `json:"postId"`
+	Data    any    `json:"data"`
+}
+
+// deletedRef is the Data of a *.deleted event.
+type deletedRef struct {
+	ID int `json:"id"`
+}
+
+// eventBufferSize is how many events a subscriber can fall behind by
+// before it is dropped. Its client reconnects and refetches.
+const eventBufferSize = 64
+
+// eventHeartbeat is how often an idle stream sends a comment line, so
+// that proxies do not time the connection out.
+const eventHeartbeat = 30 * time.Second
+
+// subscription receives the events for one topic, one post, or (with
+// both ids zero) everything. The bus closes events when it drops the
+// subscriber.
+type subscription struct {
+	topicID int
+	postID  int
+	events  chan Event
+}
+
+// matches reports whether e is one the subscriber asked for.
+func (s *subscription) matches(e Event) bool {
+	return (s.topicID == 0 || s.topicID == e.TopicID) &&
+		(s.postID == 0 || s.postID == e.PostID)
+}
+
+// eventBus fans events out from the write handlers to the open streams.
+// It is in-process only, so every server sees just its own writes.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[*subscription]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: map[*subscription]struct{}{}}
+}
+
+func (b *eventBus) subscribe(topicID, postID int) *subscription {
+	s := &subscription{topicID: topicID, postID: postID, events: make(chan Event, eventBufferSize)}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *eventBus) unsubscribe(s *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.events)
+	}
+}
+
+// publish hands e to every matching subscriber without blocking. Call it
+// after the transaction that made the change has committed.
+func (b *eventBus) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if !s.matches(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			// Too far behind; end its stream rather than skip events
+			delete(b.subs, s)
+			close(s.events)
+		}
+	}
+}
+
+// eventsHandler handles GET /events?topicId=1 or ?postId=1
+// It streams matching events as Server-Sent Events until the client
+// disconnects. With neither parameter it streams every event.
+func (s *server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ids [2]int
+	for i, param := range []string{"topicId", "postId"} {
+		s := r.URL.Query().Get(param)
+		if s == "" {
+			continue
+		}
+		id, err := strconv.Atoi(s)
+		if err != nil || id <= 0 {
+			writeError(w, "Invalid "+param+" parameter", http.StatusBadRequest)
+			return
+		}
+		ids[i] = id
+	}
+
+	rc := http.NewResponseController(w)
+	sub := s.events.subscribe(ids[0], ids[1])
+	defer s.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
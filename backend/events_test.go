@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openStream starts GET /events with the query on a live server and
+// returns a function that reads the next event from the stream.
+func openStream(t *testing.T, srv *httptest.Server, query string) func() Event {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, Content-Type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	lines := bufio.NewReader(resp.Body)
+	return func() Event {
+		t.Helper()
+		var name string
+		for {
+			line, err := lines.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading stream: %v", err)
+			}
+			if value, ok := strings.CutPrefix(line, "event: "); ok {
+				name = strings.TrimSpace(value)
+			}
+			if value, ok := strings.CutPrefix(line, "data: "); ok {
+				var e Event
+				if err := json.Unmarshal([]byte(value), &e); err != nil {
+					t.Fatal(err)
+				}
+				if e.Type != name {
+					t.Errorf("event %q has type %q", name, e.Type)
+				}
+				return e
+			}
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	ts := newTestServer(t)
+	srv := httptest.NewServer(ts.handler)
+	// Cleanups run last first, so the streams end before the server closes
+	t.Cleanup(srv.Close)
+
+	// The response headers come once the stream is subscribed
+	all := openStream(t, srv, "")
+	homework := openStream(t, srv, "?topicId=2")
+	post := openStream(t, srv, "?postId=1")
+
+	wantStatus(t, ts.do(http.MethodPut, "/posts/1", "bob", UpdatePostRequest{Title: "Edited", Content: "New content"}), http.StatusOK)
+	wantStatus(t, ts.do(http.MethodPost, "/posts/2/comments", "bob", CreateCommentRequest{Content: "Try this"}), http.StatusCreated)
+
+	if e := all(); e.Type != "post.updated" || e.PostID != 1 {
+		t.Errorf("first event = %+v, want post 1 updated", e)
+	}
+	if e := all(); e.Type != "comment.created" || e.PostID != 2 {
+		t.Errorf("second event = %+v, want a comment on post 2", e)
+	}
+	// Each stream only gets the events it asked for
+	if e := homework(); e.Type != "comment.created" || e.TopicID != 2 {
+		t.Errorf("Homework event = %+v, want the comment on post 2", e)
+	}
+	if e := post(); e.Type != "post.updated" || e.PostID != 1 {
+		t.Errorf("post 1 event = %+v, want it updated", e)
+	}
+
+	runRequests(t, []request{
+		{"invalid topicId", http.MethodGet, "/events?topicId=x", "", nil, http.StatusBadRequest},
+		{"zero postId", http.MethodGet, "/events?postId=0", "", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/events", "bob", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestEventBusDropsSlowSubscribers(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(0, 0)
+	for range eventBufferSize + 1 {
+		bus.publish(Event{Type: "post.updated", TopicID: 1, PostID: 1})
+	}
+	received := 0
+	for range sub.events {
+		received++
+	}
+	if received != eventBufferSize {
+		t.Errorf("received %d events before the stream closed, want %d", received, eventBufferSize)
+	}
+	// Unsubscribing after being dropped is harmless
+	bus.unsubscribe(sub)
+}
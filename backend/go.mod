@@ -2,4 +2,17 @@ module backend
 
 go 1.25.5
 
-require github.com/mattn/go-sqlite3 v1.14.33 // indirect
+require (
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.33
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/yuin/goldmark v1.8.2
+	golang.org/x/crypto v0.45.0
+	golang.org/x/text v0.31.0
+)
+
+require (
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	golang.org/x/net v0.47.0 // indirect
+)
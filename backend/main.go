@@ -1,15 +1,17 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"slices"
 	"strconv"
-	"strings"
-
-	_ "github.com/mattn/go-sqlite3"
+	"time"
 )
 
 // Topic represents a discussion topic in the forum.
@@ -17,118 +19,174 @@ type Topic struct {
 	ID          int    `json:"id"`
 	Title       string `json:"title"`
 	Description string `json:"description"`
+	IsArchived  bool   `json:"isArchived"`
 }
 
 // Post represents a discussion post under a topic.
+// UpdatedAt is null until the post is first edited. Score is the sum of
+// all votes and MyVote is the caller's own vote (1, -1 or 0 for none).
+// A post is resolved once one of its comments is accepted as the answer.
+// Content is the Markdown source and ContentHTML its sanitized rendering.
 type Post struct {
-	ID       int    `json:"id"`
-	TopicID  int    `json:"topicId"`
-	Title    string `json:"title"`
-	Content  string `json:"content"`
-	Author   string `json:"author"`
-	IsPinned bool   `json:"isPinned"`
+	ID                int        `json:"id"`
+	TopicID           int        `json:"topicId"`
+	Title             string     `json:"title"`
+	Content           string     `json:"content"`
+	ContentHTML       string     `json:"contentHtml"`
+	Author            string     `json:"author"`
+	IsPinned          bool       `json:"isPinned"`
+	Score             int        `json:"score"`
+	MyVote            int        `json:"myVote"`
+	AcceptedCommentID *int       `json:"acceptedCommentId"`
+	IsResolved        bool       `json:"isResolved"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	UpdatedAt         *time.Time `json:"updatedAt"`
 }
 
 // Comment represents a comment under a post.
+// UpdatedAt is null until the comment is first edited. ParentID is null
+// for top-level comments; replies are nested under their parent when
+// comments are listed. Score and MyVote work as for Post, and so do
+// Content and ContentHTML.
 type Comment struct {
-	ID       int    `json:"id"`
-	PostID   int    `json:"postId"`
-	Content  string `json:"content"`
-	Author   string `json:"author"`
-	IsPinned bool   `json:"isPinned"`
+	ID          int        `json:"id"`
+	PostID      int        `json:"postId"`
+	ParentID    *int       `json:"parentId"`
+	Depth       int        `json:"depth"`
+	Content     string     `json:"content"`
+	ContentHTML string     `json:"contentHtml"`
+	Author      string     `json:"author"`
+	IsPinned    bool       `json:"isPinned"`
+	Score       int        `json:"score"`
+	MyVote      int        `json:"myVote"`
+	IsDeleted   bool       `json:"isDeleted"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   *time.Time `json:"updatedAt"`
+	Replies     []Comment  `json:"replies,omitempty"`
 }
 
 // User represents a forum user.
+// IsModerator is true for forum-wide moderators (the admin and moderator
+// roles); ModeratedTopicIDs lists the topics the user moderates on top
+// of that, and is only filled in where it is returned to clients.
+// Sanction is set on the logged-in user while they are banned or suspended.
 type User struct {
+	ID                int       `json:"id"`
+	Username          string    `json:"username"`
+	Role              string    `json:"role"`
+	IsModerator       bool      `json:"isModerator"`
+	ModeratedTopicIDs []int     `json:"moderatedTopicIds"`
+	Sanction          *Sanction `json:"sanction,omitempty"`
+}
+
+// CreateTopicRequest represents the JSON body for creating a topic.
+type CreateTopicRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// UpdateTopicRequest represents the JSON body for renaming a topic.
+type UpdateTopicRequest struct {
 	ID          int    `json:"id"`
-	Username    string `json:"username"`
-	IsModerator bool   `json:"isModerator"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// DeleteTopicRequest represents the JSON body for deleting a topic.
+type DeleteTopicRequest struct {
+	ID int `json:"id"`
+}
+
+// ArchiveTopicRequest represents the JSON body for archiving/unarchiving a topic.
+type ArchiveTopicRequest struct {
+	ID       int  `json:"id"`
+	Archived bool `json:"archived"`
 }
 
 // CreatePostRequest represents the JSON body for creating a post.
+// TopicID is only read by the deprecated POST /posts; the topic is
+// otherwise in the path.
 type CreatePostRequest struct {
 	TopicID int    `json:"topicId"`
-	UserID  int    `json:"userId"`
 	Title   string `json:"title"`
 	Content string `json:"content"`
 }
 
 // UpdatePostRequest represents the JSON body for updating a post.
+// ID is only read by the deprecated PUT /posts.
 type UpdatePostRequest struct {
 	ID      int    `json:"id"`
-	UserID  int    `json:"userId"`
 	Title   string `json:"title"`
 	Content string `json:"content"`
 }
 
-// DeletePostRequest represents the JSON body for deleting a post.
-type DeletePostRequest struct {
-	ID     int `json:"id"`
-	UserID int `json:"userId"`
-}
-
-// PinPostRequest represents the JSON body for pinning/unpinning a post.
+// PinPostRequest represents the JSON body of the deprecated POST /posts/pin.
 type PinPostRequest struct {
 	ID     int  `json:"id"`
-	UserID int  `json:"userId"`
 	Pinned bool `json:"pinned"`
 }
 
 // CreateCommentRequest represents the JSON body for creating a comment.
+// ParentID is optional and makes the comment a reply. PostID is only
+// read by the deprecated POST /comments.
 type CreateCommentRequest struct {
-	PostID  int    `json:"postId"`
-	UserID  int    `json:"userId"`
-	Content string `json:"content"`
+	PostID   int    `json:"postId"`
+	ParentID int    `json:"parentId"`
+	Content  string `json:"content"`
 }
 
 // UpdateCommentRequest represents the JSON body for updating a comment.
+// ID is only read by the deprecated PUT /comments.
 type UpdateCommentRequest struct {
 	ID      int    `json:"id"`
-	UserID  int    `json:"userId"`
 	Content string `json:"content"`
 }
 
-// DeleteCommentRequest represents the JSON body for deleting a comment.
-type DeleteCommentRequest struct {
-	ID     int `json:"id"`
-	UserID int `json:"userId"`
-}
-
-// PinCommentRequest represents the JSON body for pinning/unpinning a comment.
+// PinCommentRequest represents the JSON body of the deprecated POST /comments/pin.
 type PinCommentRequest struct {
 	ID     int  `json:"id"`
-	UserID int  `json:"userId"`
 	Pinned bool `json:"pinned"`
 }
 
-// LoginRequest represents the JSON body for login.
-type LoginRequest struct {
-	Username string `json:"username"`
+// statusError is returned from inside a transaction to roll back and
+// answer the request with a specific status, e.g. 403 or 404.
+type statusError struct {
+	status  int
+	message string
 }
 
-// Global DB handle
-var db *sql.DB
-
-// withCORS is a small wrapper that adds CORS headers to responses.
-func withCORS(handler http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Allow all origins for local dev
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		// Handle preflight OPTIONS requests
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
+func (e *statusError) Error() string {
+	return e.message
+}
 
-		// Normal request
-		handler(w, r)
+// writeTxError answers a request whose transaction failed. A statusError
+// or fieldErrors is passed on as is, errNotFound and a foreign key
+// violation mean something the request refers to does not exist (any
+// more), and anything else is a 500 with the given message.
+func writeTxError(w http.ResponseWriter, err error, message string) {
+	var se *statusError
+	if errors.As(err, &se) {
+		writeError(w, se.message, se.status)
+		return
+	}
+	if errors.Is(err, errNotFound) {
+		writeError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	var fe fieldErrors
+	if errors.As(err, &fe) {
+		writeFieldErrors(w, fe)
+		return
+	}
+	if isForeignKeyError(err) {
+		writeError(w, "Referenced topic, post or user does not exist", http.StatusConflict)
+		return
 	}
+	slog.Error(message, "err", err)
+	writeError(w, message, http.StatusInternalServerError)
 }
 
-// boolToInt converts a bool to 0/1 for SQLite.
+// boolToInt converts a bool to 0/1 for the INTEGER flag columns.
 func boolToInt(b bool) int {
 	if b {
 		return 1
@@ -136,198 +194,54 @@ func boolToInt(b bool) int {
 	return 0
 }
 
-// initDB opens the SQLite database, creates tables if needed,
-// and inserts some default data if tables are empty.
-func initDB() error {
-	var err error
-	db, err = sql.Open("sqlite3", "./forum.db")
-	if err != nil {
-		return err
-	}
-
-	// Create users table with moderator flag
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT NOT NULL UNIQUE,
-			is_moderator INTEGER NOT NULL DEFAULT 0
-		);
-	`)
-	if err != nil {
-		return err
-	}
-
-	// Create topics table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS topics (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			title TEXT NOT NULL,
-			description TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-	`)
-	if err != nil {
-		return err
-	}
-
-	// Create posts table with user_id and is_pinned
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS posts (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			topic_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL,
-			title TEXT NOT NULL,
-			content TEXT NOT NULL,
-			is_pinned INTEGER NOT NULL DEFAULT 0,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (topic_id) REFERENCES topics(id),
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		);
-	`)
-	if err != nil {
-		return err
-	}
-
-	// Create comments table with user_id and is_pinned
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS comments (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			post_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL,
-			content TEXT NOT NULL,
-			is_pinned INTEGER NOT NULL DEFAULT 0,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (post_id) REFERENCES posts(id),
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		);
-	`)
-	if err != nil {
-		return err
-	}
-
-	// Seed users if empty
-	var userCount int
-	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount); err != nil {
-		return err
-	}
-	if userCount == 0 {
-		// alice is moderator, bob is normal user
-		_, err = db.Exec(`
-			INSERT INTO users (username, is_moderator) VALUES
-				("alice", 1),
-				("bob", 0);
-		`)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Seed topics if empty
-	var topicCount int
-	if err := db.QueryRow("SELECT COUNT(*) FROM topics").Scan(&topicCount); err != nil {
-		return err
-	}
-	if topicCount == 0 {
-		_, err = db.Exec(`
-			INSERT INTO topics (title, description) VALUES
-				("General", "General discussion"),
-				("Homework", "Ask about assignments");
-		`)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Seed posts if empty
-	var postCount int
-	if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&postCount); err != nil {
-		return err
-	}
-	if postCount == 0 {
-		_, err = db.Exec(`
-			INSERT INTO posts (topic_id, user_id, title, content, is_pinned) VALUES
-				(1, 1, "Welcome to the forum", "Introduce yourself and say hi!", 1),
-				(1, 2, "General chat", "Talk about anything not related to homework.", 0),
-				(2, 1, "Math homework question", "I am stuck on question 3 of the worksheet.", 0),
-				(2, 2, "Project deadline reminder", "Don't forget the assignment is due next week.", 0);
-		`)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Seed comments if empty
-	var commentCount int
-	if err := db.QueryRow("SELECT COUNT(*) FROM comments").Scan(&commentCount); err != nil {
-		return err
-	}
-	if commentCount == 0 {
-		_, err = db.Exec(`
-			INSERT INTO comments (post_id, user_id, content, is_pinned) VALUES
-				(1, 1, "Hello everyone!", 1),
-				(1, 2, "Nice to meet you all.", 0),
-				(2, 2, "I love random chats.", 0),
-				(3, 1, "Same, I'm also stuck on that question.", 0),
-				(4, 2, "Thanks for the reminder!", 0);
-		`)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
 // ---- helpers for auth ----
 
-func isUserModerator(userID int) (bool, error) {
-	var flag int
-	err := db.QueryRow("SELECT is_moderator FROM users WHERE id = ?", userID).Scan(&flag)
-	if err == sql.ErrNoRows {
+// canModifyPost reports whether the user may edit or delete a post:
+// its author can, and so can anyone who moderates its topic.
+func canModifyPost(st Store, userID, postID int) (bool, error) {
+	ownerID, topicID, err := st.PostOwner(postID)
+	if err == errNotFound {
 		return false, nil
 	}
 	if err != nil {
 		return false, err
 	}
-	return flag == 1, nil
+	return canModify(st, userID, ownerID, topicID)
 }
 
-func canModifyPost(userID, postID int) (bool, error) {
-	var ownerID int
-	err := db.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
+// canModifyComment reports whether the user may edit or delete a
+// comment, following the same rules as canModifyPost.
+func canModifyComment(st Store, userID, commentID int) (bool, error) {
+	ownerID, _, topicID, err := st.CommentOwner(commentID)
+	if err == errNotFound {
 		return false, nil
 	}
 	if err != nil {
 		return false, err
 	}
+	return canModify(st, userID, ownerID, topicID)
+}
+
+// canModify checks ownership-based access to something owned by ownerID
+// in the given topic.
+func canModify(st UserStore, userID, ownerID, topicID int) (bool, error) {
 	if ownerID == userID {
-		return true, nil
-	}
-	isMod, err := isUserModerator(userID)
-	if err != nil {
-		return false, err
+		allowed, err := st.Authorize(userID, permEditOwn, topicID)
+		if err != nil || allowed {
+			return allowed, err
+		}
 	}
-	return isMod, nil
+	return st.Authorize(userID, permModerateContent, topicID)
 }
 
-func canModifyComment(userID, commentID int) (bool, error) {
-	var ownerID int
-	err := db.QueryRow("SELECT user_id FROM comments WHERE id = ?", commentID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
+// isTopicArchived reports whether a topic is archived.
+// Unknown topics are reported as not archived.
+func isTopicArchived(st TopicStore, topicID int) (bool, error) {
+	topic, err := st.Topic(topicID)
+	if err == errNotFound {
 		return false, nil
 	}
-	if err != nil {
-		return false, err
-	}
-	if ownerID == userID {
-		return true, nil
-	}
-	isMod, err := isUserModerator(userID)
-	if err != nil {
-		return false, err
-	}
-	return isMod, nil
+	return topic.IsArchived, err
 }
 
 // ---- handlers ----
@@ -337,637 +251,1323 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "OK")
 }
 
-// loginHandler handles POST /login
-// It takes { "username": "alice" }, trims it, creates user if needed,
-// and returns { id, username, isModerator }.
-func loginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
-		return
-	}
-
-	username := strings.TrimSpace(req.Username)
-	if username == "" {
-		http.Error(w, "Username cannot be empty", http.StatusBadRequest)
-		return
-	}
-
-	// Try to find existing user
-	var user User
-	var isModInt int
-	err := db.QueryRow("SELECT id, username, is_moderator FROM users WHERE username = ?", username).
-		Scan(&user.ID, &user.Username, &isModInt)
-	if err == sql.ErrNoRows {
-		// Create new user (non-moderator by default)
-		result, err := db.Exec("INSERT INTO users (username, is_moderator) VALUES (?, 0)", username)
-		if err != nil {
-			http.Error(w, "Failed to create user", http.StatusInternalServerError)
-			return
-		}
-		newID, err := result.LastInsertId()
-		if err != nil {
-			http.Error(w, "Failed to get new user ID", http.StatusInternalServerError)
-			return
-		}
-		user.ID = int(newID)
-		user.Username = username
-		user.IsModerator = false
-	} else if err != nil {
-		http.Error(w, "Failed to query user", http.StatusInternalServerError)
-		return
-	} else {
-		user.IsModerator = (isModInt == 1)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		http.Error(w, "Failed to encode user", http.StatusInternalServerError)
-	}
-}
-
-// topicsHandler handles GET /topics and returns a list of topics from the DB.
-func topicsHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT id, title, description FROM topics ORDER BY id")
-	if err != nil {
-		http.Error(w, "Failed to query topics", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var topics []Topic
-	for rows.Next() {
-		var t Topic
-		if err := rows.Scan(&t.ID, &t.Title, &t.Description); err != nil {
-			http.Error(w, "Failed to scan topic", http.StatusInternalServerError)
-			return
-		}
-		topics = append(topics, t)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(topics); err != nil {
-		http.Error(w, "Failed to encode topics", http.StatusInternalServerError)
-	}
-}
-
-// postsHandler handles:
-//   - GET    /posts?topicId=1 → list posts for a topic
-//   - POST   /posts           → create a new post
-//   - PUT    /posts           → update a post
-//   - DELETE /posts           → delete a post
-func postsHandler(w http.ResponseWriter, r *http.Request) {
+// topicsHandler handles:
+//   - GET    /topics → list topics
+//   - POST   /topics → create a new topic (moderator only)
+//   - PUT    /topics → rename a topic (moderator only)
+//   - DELETE /topics → delete a topic with its posts and comments (moderator only)
+func (s *server) topicsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		handleListPosts(w, r)
+		s.handleListTopics(w, r)
 	case http.MethodPost:
-		handleCreatePost(w, r)
+		s.handleCreateTopic(w, r)
 	case http.MethodPut:
-		handleUpdatePost(w, r)
+		s.handleUpdateTopic(w, r)
 	case http.MethodDelete:
-		handleDeletePost(w, r)
+		s.handleDeleteTopic(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// handleListPosts handles GET /posts?topicId=1
-func handleListPosts(w http.ResponseWriter, r *http.Request) {
-	topicIDStr := r.URL.Query().Get("topicId")
-	if topicIDStr == "" {
-		http.Error(w, "Missing topicId parameter", http.StatusBadRequest)
-		return
-	}
-
-	topicID, err := strconv.Atoi(topicIDStr)
-	if err != nil {
-		http.Error(w, "Invalid topicId parameter", http.StatusBadRequest)
-		return
-	}
-
-	rows, err := db.Query(`
-		SELECT posts.id, posts.topic_id, posts.title, posts.content, users.username, posts.is_pinned
-		FROM posts
-		JOIN users ON posts.user_id = users.id
-		WHERE posts.topic_id = ?
-		ORDER BY posts.is_pinned DESC, posts.id
-	`, topicID)
+// handleListTopics handles GET /topics and returns a list of topics.
+func (s *server) handleListTopics(w http.ResponseWriter, r *http.Request) {
+	topics, err := s.store.Topics()
 	if err != nil {
-		http.Error(w, "Failed to query posts", http.StatusInternalServerError)
+		writeError(w, "Failed to query topics", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var posts []Post
-	for rows.Next() {
-		var p Post
-		if err := rows.Scan(&p.ID, &p.TopicID, &p.Title, &p.Content, &p.Author, &p.IsPinned); err != nil {
-			http.Error(w, "Failed to scan post", http.StatusInternalServerError)
-			return
-		}
-		posts = append(posts, p)
-	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(posts); err != nil {
-		http.Error(w, "Failed to encode posts", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(topics); err != nil {
+		writeError(w, "Failed to encode topics", http.StatusInternalServerError)
 	}
 }
 
-// handleCreatePost handles POST /posts
-func handleCreatePost(w http.ResponseWriter, r *http.Request) {
-	var req CreatePostRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
-		return
-	}
-	if req.TopicID == 0 || req.UserID == 0 || req.Title == "" || req.Content == "" {
-		http.Error(w, "Missing topicId, userId, title, or content", http.StatusBadRequest)
+// handleCreateTopic handles POST /topics
+func (s *server) handleCreateTopic(w http.ResponseWriter, r *http.Request) {
+	moderator, ok := s.requirePermission(w, r, permManageTopics, 0, "Only moderators can create topics")
+	if !ok {
 		return
 	}
 
-	result, err := db.Exec(
-		"INSERT INTO posts (topic_id, user_id, title, content, is_pinned) VALUES (?, ?, ?, ?, 0)",
-		req.TopicID, req.UserID, req.Title, req.Content,
-	)
-	if err != nil {
-		http.Error(w, "Failed to insert post", http.StatusInternalServerError)
+	var req CreateTopicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
-
-	newID, err := result.LastInsertId()
-	if err != nil {
-		http.Error(w, "Failed to get new post ID", http.StatusInternalServerError)
+	var v validator
+	req.Title = v.text("title", "Title", req.Title, 1, maxTopicTitleLength)
+	req.Description = v.text("description", "Description", req.Description, 0, maxTopicDescriptionLength)
+	if !v.valid(w) {
 		return
 	}
 
-	var author string
-	if err := db.QueryRow("SELECT username FROM users WHERE id = ?", req.UserID).Scan(&author); err != nil {
-		http.Error(w, "Failed to get author username", http.StatusInternalServerError)
+	var created Topic
+	err := s.store.Tx(func(tx Store) error {
+		var err error
+		if created, err = tx.CreateTopic(req.Title, req.Description); err != nil {
+			return err
+		}
+		return tx.RecordAudit(moderator.ID, "topic.create", "topic", created.ID, nil, created)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to create topic")
 		return
 	}
 
-	created := Post{
-		ID:       int(newID),
-		TopicID:  req.TopicID,
-		Title:    req.Title,
-		Content:  req.Content,
-		Author:   author,
-		IsPinned: false,
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(created); err != nil {
-		http.Error(w, "Failed to encode created post", http.StatusInternalServerError)
+		writeError(w, "Failed to encode created topic", http.StatusInternalServerError)
 	}
 }
 
-// handleUpdatePost handles PUT /posts
-func handleUpdatePost(w http.ResponseWriter, r *http.Request) {
-	var req UpdatePostRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
-		return
-	}
-	if req.ID == 0 || req.UserID == 0 || req.Title == "" || req.Content == "" {
-		http.Error(w, "Missing id, userId, title, or content", http.StatusBadRequest)
+// handleUpdateTopic handles PUT /topics
+func (s *server) handleUpdateTopic(w http.ResponseWriter, r *http.Request) {
+	moderator, ok := s.requirePermission(w, r, permManageTopics, 0, "Only moderators can edit topics")
+	if !ok {
 		return
 	}
 
-	allowed, err := canModifyPost(req.UserID, req.ID)
-	if err != nil {
-		http.Error(w, "Authorization check failed", http.StatusInternalServerError)
+	var req UpdateTopicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
-	if !allowed {
-		http.Error(w, "Not allowed to edit this post", http.StatusForbidden)
+	var v validator
+	v.check(req.ID != 0, "id", "Missing id")
+	req.Title = v.text("title", "Title", req.Title, 1, maxTopicTitleLength)
+	req.Description = v.text("description", "Description", req.Description, 0, maxTopicDescriptionLength)
+	if !v.valid(w) {
 		return
 	}
 
-	_, err = db.Exec(
-		"UPDATE posts SET title = ?, content = ? WHERE id = ?",
-		req.Title, req.Content, req.ID,
-	)
-	if err != nil {
-		http.Error(w, "Failed to update post", http.StatusInternalServerError)
-		return
-	}
+	var updated Topic
+	err := s.store.Tx(func(tx Store) error {
+		before, err := tx.Topic(req.ID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Topic not found"}
+		}
+		if err != nil {
+			return err
+		}
 
-	var topicID int
-	var author string
-	var isPinned bool
-	if err := db.QueryRow(`
-		SELECT posts.topic_id, users.username, posts.is_pinned
-		FROM posts
-		JOIN users ON posts.user_id = users.id
-		WHERE posts.id = ?
-	`, req.ID).Scan(&topicID, &author, &isPinned); err != nil {
-		http.Error(w, "Failed to reload updated post", http.StatusInternalServerError)
+		if updated, err = tx.UpdateTopic(req.ID, req.Title, req.Description); err != nil {
+			return err
+		}
+		return tx.RecordAudit(moderator.ID, "topic.edit", "topic", req.ID, before, updated)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to update topic")
 		return
 	}
 
-	updated := Post{
-		ID:       req.ID,
-		TopicID:  topicID,
-		Title:    req.Title,
-		Content:  req.Content,
-		Author:   author,
-		IsPinned: isPinned,
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(updated); err != nil {
-		http.Error(w, "Failed to encode updated post", http.StatusInternalServerError)
+		writeError(w, "Failed to encode updated topic", http.StatusInternalServerError)
 	}
 }
 
-// handleDeletePost handles DELETE /posts
-func handleDeletePost(w http.ResponseWriter, r *http.Request) {
-	var req DeletePostRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
-		return
-	}
-	if req.ID == 0 || req.UserID == 0 {
-		http.Error(w, "Missing id or userId", http.StatusBadRequest)
+// handleDeleteTopic handles DELETE /topics
+// Deleting a topic also deletes every post in it and every comment
+// under those posts. Either all of it is deleted or none of it.
+func (s *server) handleDeleteTopic(w http.ResponseWriter, r *http.Request) {
+	moderator, ok := s.requirePermission(w, r, permManageTopics, 0, "Only moderators can delete topics")
+	if !ok {
 		return
 	}
 
-	allowed, err := canModifyPost(req.UserID, req.ID)
-	if err != nil {
-		http.Error(w, "Authorization check failed", http.StatusInternalServerError)
+	var req DeleteTopicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
-	if !allowed {
-		http.Error(w, "Not allowed to delete this post", http.StatusForbidden)
+	if req.ID == 0 {
+		writeFieldError(w, "id", "Missing id")
 		return
 	}
 
-	// Delete comments under the post first
-	if _, err := db.Exec("DELETE FROM comments WHERE post_id = ?", req.ID); err != nil {
-		http.Error(w, "Failed to delete comments for post", http.StatusInternalServerError)
-		return
-	}
+	err := s.store.Tx(func(tx Store) error {
+		before, err := tx.Topic(req.ID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Topic not found"}
+		}
+		if err != nil {
+			return err
+		}
 
-	// Delete the post
-	if _, err := db.Exec("DELETE FROM posts WHERE id = ?", req.ID); err != nil {
-		http.Error(w, "Failed to delete post", http.StatusInternalServerError)
+		if err := tx.DeleteTopic(req.ID); err != nil {
+			return err
+		}
+		return tx.RecordAudit(moderator.ID, "topic.delete", "topic", req.ID, before, nil)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to delete topic")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// pinPostHandler handles POST /posts/pin
-// Only moderators can pin/unpin posts.
-func pinPostHandler(w http.ResponseWriter, r *http.Request) {
+// archiveTopicHandler handles POST /topics/archive
+// Only moderators can archive/unarchive topics. Archived topics are
+// read-only: no new posts or comments and no edits.
+func (s *server) archiveTopicHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req PinPostRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
-		return
-	}
-	if req.ID == 0 || req.UserID == 0 {
-		http.Error(w, "Missing id or userId", http.StatusBadRequest)
+	moderator, ok := s.requirePermission(w, r, permManageTopics, 0, "Only moderators can archive topics")
+	if !ok {
 		return
 	}
 
-	isMod, err := isUserModerator(req.UserID)
-	if err != nil {
-		http.Error(w, "Authorization check failed", http.StatusInternalServerError)
+	var req ArchiveTopicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
-	if !isMod {
-		http.Error(w, "Only moderators can pin posts", http.StatusForbidden)
+	if req.ID == 0 {
+		writeFieldError(w, "id", "Missing id")
 		return
 	}
 
-	if _, err := db.Exec(
-		"UPDATE posts SET is_pinned = ? WHERE id = ?",
-		boolToInt(req.Pinned), req.ID,
-	); err != nil {
-		http.Error(w, "Failed to update pin status", http.StatusInternalServerError)
-		return
-	}
+	var updated Topic
+	err := s.store.Tx(func(tx Store) error {
+		before, err := tx.Topic(req.ID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Topic not found"}
+		}
+		if err != nil {
+			return err
+		}
 
-	var topicID int
-	var title, content, author string
-	var isPinned bool
-	if err := db.QueryRow(`
-		SELECT posts.topic_id, posts.title, posts.content, users.username, posts.is_pinned
-		FROM posts
-		JOIN users ON posts.user_id = users.id
-		WHERE posts.id = ?
-	`, req.ID).Scan(&topicID, &title, &content, &author, &isPinned); err != nil {
-		http.Error(w, "Failed to reload pinned post", http.StatusInternalServerError)
+		if updated, err = tx.ArchiveTopic(req.ID, req.Archived); err != nil {
+			return err
+		}
+		action := "topic.archive"
+		if !req.Archived {
+			action = "topic.unarchive"
+		}
+		return tx.RecordAudit(moderator.ID, action, "topic", req.ID, before, updated)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to update archive status")
 		return
 	}
 
-	updated := Post{
-		ID:       req.ID,
-		TopicID:  topicID,
-		Title:    title,
-		Content:  content,
-		Author:   author,
-		IsPinned: isPinned,
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(updated); err != nil {
-		http.Error(w, "Failed to encode pinned post", http.StatusInternalServerError)
+		writeError(w, "Failed to encode archived topic", http.StatusInternalServerError)
 	}
 }
 
-// commentsHandler handles:
-//   - GET    /comments?postId=1 → list comments for a post
-//   - POST   /comments          → create a new comment
-//   - PUT    /comments          → update a comment
-//   - DELETE /comments          → delete a comment
-func commentsHandler(w http.ResponseWriter, r *http.Request) {
+// topicPostsHandler handles:
+//   - GET  /topics/{id}/posts → list the posts in a topic, one page at a time
+//   - POST /topics/{id}/posts → create a post in the topic
+func (s *server) topicPostsHandler(w http.ResponseWriter, r *http.Request) {
+	topicID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		handleListComments(w, r)
+		s.handleListPosts(w, r, topicID)
 	case http.MethodPost:
-		handleCreateComment(w, r)
-	case http.MethodPut:
-		handleUpdateComment(w, r)
-	case http.MethodDelete:
-		handleDeleteComment(w, r)
+		s.handleCreatePost(w, r, topicID)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// handleListComments handles GET /comments?postId=1
-func handleListComments(w http.ResponseWriter, r *http.Request) {
-	postIDStr := r.URL.Query().Get("postId")
-	if postIDStr == "" {
-		http.Error(w, "Missing postId parameter", http.StatusBadRequest)
+// postHandler handles:
+//   - GET    /posts/{id} → get a post
+//   - PUT    /posts/{id} → update a post
+//   - DELETE /posts/{id} → delete a post
+func (s *server) postHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
 		return
 	}
 
-	postID, err := strconv.Atoi(postIDStr)
-	if err != nil {
-		http.Error(w, "Invalid postId parameter", http.StatusBadRequest)
-		return
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetPost(w, r, id)
+	case http.MethodPut:
+		s.handleUpdatePost(w, r, id)
+	case http.MethodDelete:
+		s.handleDeletePost(w, r, id)
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
+}
 
-	rows, err := db.Query(`
-		SELECT comments.id, comments.post_id, comments.content, users.username, comments.is_pinned
-		FROM comments
-		JOIN users ON comments.user_id = users.id
-		WHERE comments.post_id = ?
-		ORDER BY comments.is_pinned DESC, comments.id
-	`, postID)
-	if err != nil {
-		http.Error(w, "Failed to query comments", http.StatusInternalServerError)
-		return
+// postsHandler returns the handler of the deprecated /posts routes, which
+// take ids in the query string or the JSON body. It passes them on to the
+// handlers of the new routes, topicPosts and post:
+//   - GET    /posts?topicId=1 → GET    /topics/{id}/posts
+//   - POST   /posts           → POST   /topics/{id}/posts, topicId in the body
+//   - PUT    /posts           → PUT    /posts/{id}, id in the body
+//   - DELETE /posts           → DELETE /posts/{id}, id in the body
+func postsHandler(topicPosts, post http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if idFromQuery(w, r, "topicId") {
+				topicPosts(w, r)
+			}
+		case http.MethodPost:
+			if idFromBody(w, r, "topicId") {
+				topicPosts(w, r)
+			}
+		case http.MethodPut, http.MethodDelete:
+			if idFromBody(w, r, "id") {
+				post(w, r)
+			}
+		default:
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
 	}
-	defer rows.Close()
+}
 
-	var comments []Comment
-	for rows.Next() {
-		var cmt Comment
-		if err := rows.Scan(&cmt.ID, &cmt.PostID, &cmt.Content, &cmt.Author, &cmt.IsPinned); err != nil {
-			http.Error(w, "Failed to scan comment", http.StatusInternalServerError)
+// handleListPosts handles GET /topics/{id}/posts?limit=20&sort=newest&cursor=...
+// and returns { items, nextCursor }. With unresolved=true only posts
+// without an accepted answer are listed.
+func (s *server) handleListPosts(w http.ResponseWriter, r *http.Request, topicID int) {
+	var err error
+	unresolved := false
+	if param := r.URL.Query().Get("unresolved"); param != "" {
+		if unresolved, err = strconv.ParseBool(param); err != nil {
+			writeError(w, "Invalid unresolved parameter", http.StatusBadRequest)
 			return
 		}
-		comments = append(comments, cmt)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(comments); err != nil {
-		http.Error(w, "Failed to encode comments", http.StatusInternalServerError)
 	}
-}
 
-// handleCreateComment handles POST /comments
-func handleCreateComment(w http.ResponseWriter, r *http.Request) {
-	var req CreateCommentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
-		return
-	}
-	if req.PostID == 0 || req.UserID == 0 || req.Content == "" {
-		http.Error(w, "Missing postId, userId, or content", http.StatusBadRequest)
+	page, err := parsePageRequest(r, postOrders)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	result, err := db.Exec(
-		"INSERT INTO comments (post_id, user_id, content, is_pinned) VALUES (?, ?, ?, 0)",
-		req.PostID, req.UserID, req.Content,
-	)
-	if err != nil {
-		http.Error(w, "Failed to insert comment", http.StatusInternalServerError)
+	if _, err := s.store.Topic(topicID); err == errNotFound {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, "Failed to query topic", http.StatusInternalServerError)
 		return
 	}
 
-	newID, err := result.LastInsertId()
+	result, err := s.store.Posts(topicID, unresolved, page)
 	if err != nil {
-		http.Error(w, "Failed to get new comment ID", http.StatusInternalServerError)
+		writeError(w, "Failed to query posts", http.StatusInternalServerError)
 		return
 	}
-
-	var author string
-	if err := db.QueryRow("SELECT username FROM users WHERE id = ?", req.UserID).Scan(&author); err != nil {
-		http.Error(w, "Failed to get author username", http.StatusInternalServerError)
+	if err := setMyPostVotes(s.store, viewerID(r), result.Items); err != nil {
+		writeError(w, "Failed to query votes", http.StatusInternalServerError)
 		return
 	}
 
-	created := Comment{
-		ID:       int(newID),
-		PostID:   req.PostID,
-		Content:  req.Content,
-		Author:   author,
-		IsPinned: false,
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(created); err != nil {
-		http.Error(w, "Failed to encode created comment", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		writeError(w, "Failed to encode posts", http.StatusInternalServerError)
 	}
 }
 
-// handleUpdateComment handles PUT /comments
-func handleUpdateComment(w http.ResponseWriter, r *http.Request) {
-	var req UpdateCommentRequest
+// handleCreatePost handles POST /topics/{id}/posts
+// It takes { "title": "...", "content": "..." }.
+func (s *server) handleCreatePost(w http.ResponseWriter, r *http.Request, topicID int) {
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreatePostRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
-	if req.ID == 0 || req.UserID == 0 || req.Content == "" {
-		http.Error(w, "Missing id, userId, or content", http.StatusBadRequest)
+	req.TopicID = topicID
+	var v validator
+	req.Title = v.text("title", "Title", req.Title, 1, maxPostTitleLength)
+	req.Content = v.text("content", "Content", req.Content, 1, maxPostContentLength)
+	if !v.valid(w) {
 		return
 	}
 
-	allowed, err := canModifyComment(req.UserID, req.ID)
+	var created Post
+	err := s.store.Tx(func(tx Store) error {
+		topic, err := tx.Topic(req.TopicID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Topic not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if topic.IsArchived {
+			return &statusError{http.StatusForbidden, "This topic is archived and read-only"}
+		}
+
+		allowed, err := tx.Authorize(user.ID, permCreateContent, req.TopicID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Your account cannot create posts"}
+		}
+
+		duplicate, err := s.isDuplicatePost(tx, user.ID, req.Title, req.Content)
+		if err != nil {
+			return err
+		}
+		if duplicate {
+			return &statusError{http.StatusConflict, "You just posted this"}
+		}
+
+		if created, err = tx.CreatePost(req.TopicID, user.ID, req.Title, req.Content); err != nil {
+			return err
+		}
+		return notifyMentions(tx, user.ID, req.Content, "", created.ID, nil)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to create post")
+		return
+	}
+	s.events.publish(Event{"post.created", created.TopicID, created.ID, created})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(created); err != nil {
+		writeError(w, "Failed to encode created post", http.StatusInternalServerError)
+	}
+}
+
+// handleGetPost handles GET /posts/{id}
+func (s *server) handleGetPost(w http.ResponseWriter, r *http.Request, id int) {
+	post, err := s.store.Post(id)
+	if err == errNotFound {
+		writeError(w, "Post not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Authorization check failed", http.StatusInternalServerError)
+		writeError(w, "Failed to query post", http.StatusInternalServerError)
+		return
+	}
+	posts := []Post{post}
+	if err := setMyPostVotes(s.store, viewerID(r), posts); err != nil {
+		writeError(w, "Failed to query votes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(posts[0]); err != nil {
+		writeError(w, "Failed to encode post", http.StatusInternalServerError)
+	}
+}
+
+// handleUpdatePost handles PUT /posts/{id}
+// It takes { "title": "...", "content": "..." }.
+func (s *server) handleUpdatePost(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdatePostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
-	if !allowed {
-		http.Error(w, "Not allowed to edit this comment", http.StatusForbidden)
+	req.ID = id
+	var v validator
+	req.Title = v.text("title", "Title", req.Title, 1, maxPostTitleLength)
+	req.Content = v.text("content", "Content", req.Content, 1, maxPostContentLength)
+	if !v.valid(w) {
 		return
 	}
 
-	_, err = db.Exec(
-		"UPDATE comments SET content = ? WHERE id = ?",
-		req.Content, req.ID,
-	)
+	var updated Post
+	err := s.store.Tx(func(tx Store) error {
+		before, err := tx.Post(req.ID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Post not found"}
+		}
+		if err != nil {
+			return err
+		}
+		allowed, err := canModifyPost(tx, user.ID, req.ID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Not allowed to edit this post"}
+		}
+
+		archived, err := isTopicArchived(tx, before.TopicID)
+		if err != nil {
+			return err
+		}
+		if archived {
+			return &statusError{http.StatusForbidden, "This topic is archived and read-only"}
+		}
+
+		if updated, err = tx.UpdatePost(req.ID, user.ID, req.Title, req.Content); err != nil {
+			return err
+		}
+		// Edits of other users' posts are moderator actions
+		if before.Author != user.Username {
+			if err := tx.RecordAudit(user.ID, "post.edit", "post", req.ID, before, updated); err != nil {
+				return err
+			}
+			ownerID, _, err := tx.PostOwner(req.ID)
+			if err != nil {
+				return err
+			}
+			if err := notify(tx, ownerID, user.ID, notifyEdit, req.ID, nil); err != nil {
+				return err
+			}
+		}
+		if err := notifyMentions(tx, user.ID, req.Content, before.Content, req.ID, nil); err != nil {
+			return err
+		}
+		votes, err := tx.PostVotes(user.ID, []int{req.ID})
+		updated.MyVote = votes[req.ID]
+		return err
+	})
 	if err != nil {
-		http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+		writeTxError(w, err, "Failed to update post")
 		return
 	}
+	// MyVote is the editor's own; other viewers get theirs by refetching
+	published := updated
+	published.MyVote = 0
+	s.events.publish(Event{"post.updated", updated.TopicID, updated.ID, published})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		writeError(w, "Failed to encode updated post", http.StatusInternalServerError)
+	}
+}
 
-	var postID int
-	var author string
-	var isPinned bool
-	if err := db.QueryRow(`
-		SELECT comments.post_id, users.username, comments.is_pinned
-		FROM comments
-		JOIN users ON comments.user_id = users.id
-		WHERE comments.id = ?
-	`, req.ID).Scan(&postID, &author, &isPinned); err != nil {
-		http.Error(w, "Failed to reload updated comment", http.StatusInternalServerError)
+// handleDeletePost handles DELETE /posts/{id}
+// The post goes to the trash, where moderators can restore it.
+func (s *server) handleDeletePost(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := requireActiveUser(w, r)
+	if !ok {
 		return
 	}
 
-	updated := Comment{
-		ID:       req.ID,
-		PostID:   postID,
-		Content:  req.Content,
-		Author:   author,
-		IsPinned: isPinned,
+	var topicID int
+	err := s.store.Tx(func(tx Store) error {
+		before, err := tx.Post(id)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Post not found"}
+		}
+		if err != nil {
+			return err
+		}
+		allowed, err := canModifyPost(tx, user.ID, id)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Not allowed to delete this post"}
+		}
+		topicID = before.TopicID
+
+		archived, err := isTopicArchived(tx, topicID)
+		if err != nil {
+			return err
+		}
+		if archived {
+			return &statusError{http.StatusForbidden, "This topic is archived and read-only"}
+		}
+
+		// Move the post to the trash; its comments are hidden with it
+		if err := tx.TrashPost(id, user.ID); err != nil {
+			return err
+		}
+		if before.Author != user.Username {
+			return tx.RecordAudit(user.ID, "post.delete", "post", id, before, nil)
+		}
+		return nil
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to delete post")
+		return
 	}
+	s.events.publish(Event{"post.deleted", topicID, id, deletedRef{id}})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pinPostHandler handles:
+//   - PUT    /posts/{id}/pin → pin the post
+//   - DELETE /posts/{id}/pin → unpin it
+//
+// Only moderators of the post's topic can pin/unpin posts.
+func (s *server) pinPostHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	pinned, ok := readPin(w, r)
+	if !ok {
+		return
+	}
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+	req := PinPostRequest{ID: id, Pinned: pinned}
+
+	var updated Post
+	err := s.store.Tx(func(tx Store) error {
+		ownerID, topicID, err := tx.PostOwner(req.ID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Post not found"}
+		}
+		if err != nil {
+			return err
+		}
+
+		allowed, err := tx.Authorize(user.ID, permModerateContent, topicID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Only moderators can pin posts"}
+		}
+		before, err := tx.Post(req.ID)
+		if err != nil {
+			return err
+		}
+
+		if updated, err = tx.PinPost(req.ID, req.Pinned); err != nil {
+			return err
+		}
+		action := "post.pin"
+		if !req.Pinned {
+			action = "post.unpin"
+		}
+		if err := tx.RecordAudit(user.ID, action, "post", req.ID, before, updated); err != nil {
+			return err
+		}
+		if !req.Pinned || before.IsPinned {
+			return nil
+		}
+		return notify(tx, ownerID, user.ID, notifyPin, req.ID, nil)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to update pin status")
+		return
+	}
+	eventType := "post.pinned"
+	if !updated.IsPinned {
+		eventType = "post.unpinned"
+	}
+	s.events.publish(Event{eventType, updated.TopicID, updated.ID, updated})
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(updated); err != nil {
-		http.Error(w, "Failed to encode updated comment", http.StatusInternalServerError)
+		writeError(w, "Failed to encode pinned post", http.StatusInternalServerError)
 	}
 }
 
-// handleDeleteComment handles DELETE /comments
-func handleDeleteComment(w http.ResponseWriter, r *http.Request) {
-	var req DeleteCommentRequest
+// legacyPinPostHandler handles the deprecated POST /posts/pin, which takes
+// { "id": 1, "pinned": true }, as PUT or DELETE /posts/{id}/pin.
+func (s *server) legacyPinPostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !idFromBody(w, r, "id") {
+		return
+	}
+	var req PinPostRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
-	if req.ID == 0 || req.UserID == 0 {
-		http.Error(w, "Missing id or userId", http.StatusBadRequest)
+	r.Method = pinMethod(req.Pinned)
+	s.pinPostHandler(w, r)
+}
+
+// postCommentsHandler handles:
+//   - GET  /posts/{id}/comments → list the comments on a post, one page at a time
+//   - POST /posts/{id}/comments → comment on the post
+func (s *server) postCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	postID, ok := pathID(w, r)
+	if !ok {
 		return
 	}
 
-	allowed, err := canModifyComment(req.UserID, req.ID)
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListComments(w, r, postID)
+	case http.MethodPost:
+		s.handleCreateComment(w, r, postID)
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// commentHandler handles:
+//   - GET    /comments/{id} → get a comment
+//   - PUT    /comments/{id} → update a comment
+//   - DELETE /comments/{id} → delete a comment
+func (s *server) commentHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetComment(w, r, id)
+	case http.MethodPut:
+		s.handleUpdateComment(w, r, id)
+	case http.MethodDelete:
+		s.handleDeleteComment(w, r, id)
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// commentsHandler returns the handler of the deprecated /comments routes,
+// which take ids in the query string or the JSON body. It passes them on
+// to the handlers of the new routes, postComments and comment:
+//   - GET    /comments?postId=1 → GET    /posts/{id}/comments
+//   - POST   /comments          → POST   /posts/{id}/comments, postId in the body
+//   - PUT    /comments          → PUT    /comments/{id}, id in the body
+//   - DELETE /comments          → DELETE /comments/{id}, id in the body
+func commentsHandler(postComments, comment http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if idFromQuery(w, r, "postId") {
+				postComments(w, r)
+			}
+		case http.MethodPost:
+			if idFromBody(w, r, "postId") {
+				postComments(w, r)
+			}
+		case http.MethodPut, http.MethodDelete:
+			if idFromBody(w, r, "id") {
+				comment(w, r)
+			}
+		default:
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleListComments handles GET /posts/{id}/comments?limit=20&sort=newest&cursor=...
+// and returns { items, nextCursor }. Pages are made of top-level comments,
+// each with its replies nested under it (oldest first).
+func (s *server) handleListComments(w http.ResponseWriter, r *http.Request, postID int) {
+	page, err := parsePageRequest(r, commentOrders)
 	if err != nil {
-		http.Error(w, "Authorization check failed", http.StatusInternalServerError)
+		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if !allowed {
-		http.Error(w, "Not allowed to delete this comment", http.StatusForbidden)
+
+	// Comments of trashed posts are hidden with the post
+	if _, err := s.store.Post(postID); err == errNotFound {
+		writeError(w, "Post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, "Failed to query post", http.StatusInternalServerError)
 		return
 	}
 
-	if _, err := db.Exec("DELETE FROM comments WHERE id = ?", req.ID); err != nil {
-		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+	result, err := s.store.Comments(postID, page)
+	if err != nil {
+		writeError(w, "Failed to query comments", http.StatusInternalServerError)
+		return
+	}
+	if err := setMyCommentVotes(s.store, viewerID(r), result.Items); err != nil {
+		writeError(w, "Failed to query votes", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		writeError(w, "Failed to encode comments", http.StatusInternalServerError)
+	}
 }
 
-// pinCommentHandler handles POST /comments/pin
-// Only moderators can pin/unpin comments.
-func pinCommentHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleCreateComment handles POST /posts/{id}/comments
+// It takes { "parentId": 1, "content": "..." }, where parentId is optional.
+func (s *server) handleCreateComment(w http.ResponseWriter, r *http.Request, postID int) {
+	user, ok := requireActiveUser(w, r)
+	if !ok {
 		return
 	}
 
-	var req PinCommentRequest
+	var req CreateCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.PostID = postID
+	var v validator
+	req.Content = v.text("content", "Content", req.Content, 1, maxCommentContentLength)
+	if !v.valid(w) {
+		return
+	}
+
+	var created Comment
+	var topicID int
+	err := s.store.Tx(func(tx Store) error {
+		post, err := tx.Post(req.PostID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Post not found"}
+		}
+		if err != nil {
+			return err
+		}
+		topicID = post.TopicID
+
+		// A reply goes one level below its parent, which must be a
+		// visible comment on the same post
+		var parentID *int
+		depth := 0
+		if req.ParentID != 0 {
+			parent, err := tx.Comment(req.ParentID)
+			if err == errNotFound {
+				return &statusError{http.StatusNotFound, "Parent comment not found"}
+			}
+			if err != nil {
+				return err
+			}
+			if parent.PostID != req.PostID {
+				return &statusError{http.StatusBadRequest, "Parent comment belongs to a different post"}
+			}
+			if parent.Depth >= maxCommentDepth {
+				return &statusError{http.StatusBadRequest, fmt.Sprintf("Replies cannot be nested more than %d levels deep", maxCommentDepth)}
+			}
+			parentID = &req.ParentID
+			depth = parent.Depth + 1
+		}
+
+		archived, err := isTopicArchived(tx, post.TopicID)
+		if err != nil {
+			return err
+		}
+		if archived {
+			return &statusError{http.StatusForbidden, "This topic is archived and read-only"}
+		}
+
+		allowed, err := tx.Authorize(user.ID, permCreateContent, post.TopicID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Your account cannot create comments"}
+		}
+
+		duplicate, err := s.isDuplicateComment(tx, user.ID, req.PostID, req.Content)
+		if err != nil {
+			return err
+		}
+		if duplicate {
+			return &statusError{http.StatusConflict, "You just wrote this comment"}
+		}
+
+		if created, err = tx.CreateComment(req.PostID, parentID, depth, user.ID, req.Content); err != nil {
+			return err
+		}
+
+		// Tell the author of the comment replied to and the author of
+		// the post (once each), then anyone else mentioned
+		commentID := created.ID
+		var notified []int
+		if parentID != nil {
+			parentOwner, _, _, err := tx.CommentOwner(*parentID)
+			if err != nil {
+				return err
+			}
+			if err := notify(tx, parentOwner, user.ID, notifyReply, req.PostID, &commentID); err != nil {
+				return err
+			}
+			notified = append(notified, parentOwner)
+		}
+		postOwner, _, err := tx.PostOwner(req.PostID)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(notified, postOwner) {
+			if err := notify(tx, postOwner, user.ID, notifyComment, req.PostID, &commentID); err != nil {
+				return err
+			}
+			notified = append(notified, postOwner)
+		}
+		return notifyMentions(tx, user.ID, req.Content, "", req.PostID, &commentID, notified...)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to create comment")
+		return
+	}
+	s.events.publish(Event{"comment.created", topicID, created.PostID, created})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(created); err != nil {
+		writeError(w, "Failed to encode created comment", http.StatusInternalServerError)
+	}
+}
+
+// handleGetComment handles GET /comments/{id}
+// The comment comes without its replies.
+func (s *server) handleGetComment(w http.ResponseWriter, r *http.Request, id int) {
+	comment, err := s.store.Comment(id)
+	if err == errNotFound {
+		writeError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, "Failed to query comment", http.StatusInternalServerError)
+		return
+	}
+	comments := []Comment{comment}
+	if err := setMyCommentVotes(s.store, viewerID(r), comments); err != nil {
+		writeError(w, "Failed to query votes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(comments[0]); err != nil {
+		writeError(w, "Failed to encode comment", http.StatusInternalServerError)
+	}
+}
+
+// handleUpdateComment handles PUT /comments/{id}
+// It takes { "content": "..." }.
+func (s *server) handleUpdateComment(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateCommentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
-	if req.ID == 0 || req.UserID == 0 {
-		http.Error(w, "Missing id or userId", http.StatusBadRequest)
+	req.ID = id
+	var v validator
+	req.Content = v.text("content", "Content", req.Content, 1, maxCommentContentLength)
+	if !v.valid(w) {
 		return
 	}
 
-	isMod, err := isUserModerator(req.UserID)
+	var updated Comment
+	var topicID int
+	err := s.store.Tx(func(tx Store) error {
+		before, err := tx.Comment(req.ID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Comment not found"}
+		}
+		if err != nil {
+			return err
+		}
+		allowed, err := canModifyComment(tx, user.ID, req.ID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Not allowed to edit this comment"}
+		}
+		var ownerID int
+		if ownerID, _, topicID, err = tx.CommentOwner(req.ID); err != nil {
+			return err
+		}
+
+		archived, err := isTopicArchived(tx, topicID)
+		if err != nil {
+			return err
+		}
+		if archived {
+			return &statusError{http.StatusForbidden, "This topic is archived and read-only"}
+		}
+
+		if updated, err = tx.UpdateComment(req.ID, user.ID, req.Content); err != nil {
+			return err
+		}
+		// Edits of other users' comments are moderator actions
+		if before.Author != user.Username {
+			if err := tx.RecordAudit(user.ID, "comment.edit", "comment", req.ID, before, updated); err != nil {
+				return err
+			}
+			if err := notify(tx, ownerID, user.ID, notifyEdit, before.PostID, &req.ID); err != nil {
+				return err
+			}
+		}
+		if err := notifyMentions(tx, user.ID, req.Content, before.Content, before.PostID, &req.ID); err != nil {
+			return err
+		}
+		votes, err := tx.CommentVotes(user.ID, []int{req.ID})
+		updated.MyVote = votes[req.ID]
+		return err
+	})
 	if err != nil {
-		http.Error(w, "Authorization check failed", http.StatusInternalServerError)
+		writeTxError(w, err, "Failed to update comment")
 		return
 	}
-	if !isMod {
-		http.Error(w, "Only moderators can pin comments", http.StatusForbidden)
+	published := updated
+	published.MyVote = 0
+	s.events.publish(Event{"comment.updated", topicID, updated.PostID, published})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		writeError(w, "Failed to encode updated comment", http.StatusInternalServerError)
+	}
+}
+
+// handleDeleteComment handles DELETE /comments/{id}
+// The comment goes to the trash, where moderators can restore it.
+func (s *server) handleDeleteComment(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := requireActiveUser(w, r)
+	if !ok {
 		return
 	}
 
-	if _, err := db.Exec(
-		"UPDATE comments SET is_pinned = ? WHERE id = ?",
-		boolToInt(req.Pinned), req.ID,
-	); err != nil {
-		http.Error(w, "Failed to update pin status", http.StatusInternalServerError)
+	var before Comment
+	var topicID int
+	err := s.store.Tx(func(tx Store) error {
+		var err error
+		before, err = tx.Comment(id)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Comment not found"}
+		}
+		if err != nil {
+			return err
+		}
+		allowed, err := canModifyComment(tx, user.ID, id)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Not allowed to delete this comment"}
+		}
+		if _, _, topicID, err = tx.CommentOwner(id); err != nil {
+			return err
+		}
+
+		archived, err := isTopicArchived(tx, topicID)
+		if err != nil {
+			return err
+		}
+		if archived {
+			return &statusError{http.StatusForbidden, "This topic is archived and read-only"}
+		}
+
+		// Move the comment to the trash
+		if err := tx.TrashComment(id, user.ID); err != nil {
+			return err
+		}
+		if before.Author != user.Username {
+			return tx.RecordAudit(user.ID, "comment.delete", "comment", id, before, nil)
+		}
+		return nil
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to delete comment")
 		return
 	}
+	s.events.publish(Event{"comment.deleted", topicID, before.PostID, deletedRef{id}})
 
-	var postID int
-	var content, author string
-	var isPinned bool
-	if err := db.QueryRow(`
-		SELECT comments.post_id, comments.content, users.username, comments.is_pinned
-		FROM comments
-		JOIN users ON comments.user_id = users.id
-		WHERE comments.id = ?
-	`, req.ID).Scan(&postID, &content, &author, &isPinned); err != nil {
-		http.Error(w, "Failed to reload pinned comment", http.StatusInternalServerError)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pinCommentHandler handles:
+//   - PUT    /comments/{id}/pin → pin the comment
+//   - DELETE /comments/{id}/pin → unpin it
+//
+// Only moderators of the comment's topic can pin/unpin comments.
+func (s *server) pinCommentHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
 		return
 	}
+	pinned, ok := readPin(w, r)
+	if !ok {
+		return
+	}
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+	req := PinCommentRequest{ID: id, Pinned: pinned}
+
+	var updated Comment
+	var topicID int
+	err := s.store.Tx(func(tx Store) error {
+		var ownerID int
+		var err error
+		ownerID, _, topicID, err = tx.CommentOwner(req.ID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Comment not found"}
+		}
+		if err != nil {
+			return err
+		}
+
+		allowed, err := tx.Authorize(user.ID, permModerateContent, topicID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Only moderators can pin comments"}
+		}
+		before, err := tx.Comment(req.ID)
+		if err != nil {
+			return err
+		}
 
-	updated := Comment{
-		ID:       req.ID,
-		PostID:   postID,
-		Content:  content,
-		Author:   author,
-		IsPinned: isPinned,
+		if updated, err = tx.PinComment(req.ID, req.Pinned); err != nil {
+			return err
+		}
+		action := "comment.pin"
+		if !req.Pinned {
+			action = "comment.unpin"
+		}
+		if err := tx.RecordAudit(user.ID, action, "comment", req.ID, before, updated); err != nil {
+			return err
+		}
+		if !req.Pinned || before.IsPinned {
+			return nil
+		}
+		return notify(tx, ownerID, user.ID, notifyPin, updated.PostID, &req.ID)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to update pin status")
+		return
+	}
+	eventType := "comment.pinned"
+	if !updated.IsPinned {
+		eventType = "comment.unpinned"
 	}
+	s.events.publish(Event{eventType, topicID, updated.PostID, updated})
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(updated); err != nil {
-		http.Error(w, "Failed to encode pinned comment", http.StatusInternalServerError)
+		writeError(w, "Failed to encode pinned comment", http.StatusInternalServerError)
+	}
+}
+
+// legacyPinCommentHandler handles the deprecated POST /comments/pin, which
+// takes { "id": 1, "pinned": true }, as PUT or DELETE /comments/{id}/pin.
+func (s *server) legacyPinCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !idFromBody(w, r, "id") {
+		return
+	}
+	var req PinCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	r.Method = pinMethod(req.Pinned)
+	s.pinCommentHandler(w, r)
+}
+
+// readPin reports whether a pin request pins (PUT) or unpins (DELETE),
+// writing 405 for any other method.
+func readPin(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	switch r.Method {
+	case http.MethodPut:
+		return true, true
+	case http.MethodDelete:
+		return false, true
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false, false
+	}
+}
+
+// pinMethod is the method of the pin request that sets pinned.
+func pinMethod(pinned bool) string {
+	if pinned {
+		return http.MethodPut
+	}
+	return http.MethodDelete
+}
+
+// usage is printed when the command line cannot be understood.
+var usage = `Usage:
+  go run . [options]                    start the server (applies pending migrations)
+  go run . [options] serve              same as above
+  go run . [options] migrate up [N]     apply pending migrations, up to version N
+  go run . [options] migrate down [N]   roll back the last N migrations (default 1)
+  go run . [options] migrate status     list migrations and whether they are applied
+  go run . [options] seed               insert sample data into empty tables
+  go run . [options] role USER ROLE     give a user a role (admin, moderator, member, readonly)
+  go run . [options] password USER      set a user's password, read from standard input
+
+The commands work on the Postgres database with store postgres, and on the
+SQLite database otherwise.
+` + configUsage()
+
+// runMigrateCommand handles "migrate up|down|status [N]".
+func runMigrateCommand(db *database, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate action")
+	}
+
+	// Without N, up goes to the latest version and down one step back
+	n := 0
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			return fmt.Errorf("invalid number %q, want 1 or more", args[1])
+		}
+	}
+
+	switch args[0] {
+	case "up":
+		if n == 0 {
+			n = latestMigration(db)
+		}
+		return migrateUp(db, n, os.Stdout)
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		return migrateDown(db, n, os.Stdout)
+	case "status":
+		return migrationStatus(db, os.Stdout)
+	default:
+		return fmt.Errorf("unknown migrate action %q", args[0])
 	}
 }
 
+// runCommand handles the non-server subcommands. It returns false if
+// the arguments ask for the server to be started.
+func runCommand(cfg Config, args []string) bool {
+	if len(args) == 0 || args[0] == "serve" {
+		return false
+	}
+
+	switch args[0] {
+	case "migrate":
+		db, err := openDatabase(cfg)
+		if err != nil {
+			fatal("Failed to open database", err)
+		}
+		defer db.Close()
+		if err := runMigrateCommand(db, args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+	case "seed":
+		db, err := initDatabase(cfg)
+		if err != nil {
+			fatal("Failed to initialise database", err)
+		}
+		defer db.Close()
+		if err := newSQLStore(db).Seed(); err != nil {
+			fatal("Failed to seed database", err)
+		}
+		fmt.Println("Seeded sample data")
+	case "role":
+		db, err := initDatabase(cfg)
+		if err != nil {
+			fatal("Failed to initialise database", err)
+		}
+		defer db.Close()
+		if err := runRoleCommand(newSQLStore(db), args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "role:", err)
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		fmt.Printf("%s is now %s\n", args[1], args[2])
+	case "password":
+		db, err := initDatabase(cfg)
+		if err != nil {
+			fatal("Failed to initialise database", err)
+		}
+		defer db.Close()
+		if err := runPasswordCommand(newSQLStore(db), args[1:], os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, "password:", err)
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		fmt.Printf("Set the password of %s\n", args[1])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	return true
+}
+
+// fatal logs err and exits. Unlike log.Fatal, it is never hidden by
+// the log level.
+func fatal(message string, err error) {
+	slog.Error(message, "err", err)
+	os.Exit(1)
+}
+
 func main() {
-	// Initialise database
-	if err := initDB(); err != nil {
-		log.Fatal("Failed to initialise database:", err)
-	}
-	defer db.Close()
-
-	// Register routes with CORS wrapper
-	http.HandleFunc("/health", withCORS(healthHandler))
-	http.HandleFunc("/login", withCORS(loginHandler))
-	http.HandleFunc("/topics", withCORS(topicsHandler))
-	http.HandleFunc("/posts", withCORS(postsHandler))
-	http.HandleFunc("/posts/pin", withCORS(pinPostHandler))
-	http.HandleFunc("/comments", withCORS(commentsHandler))
-	http.HandleFunc("/comments/pin", withCORS(pinCommentHandler))
-
-	fmt.Println("Server listening on http://localhost:8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	// Read flags, environment and config file
+	cfg, args, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Print(usage)
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		fmt.Fprintln(os.Stderr, "Run with -h to list the options")
+		os.Exit(2)
+	}
+	initLogging(cfg.LogLevel)
+
+	// Handle migrate/seed/role subcommands
+	if runCommand(cfg, args) {
+		return
+	}
+	printConfig(os.Stdout, cfg)
+
+	// Open the store, applying pending migrations to a SQL database
+	store, db, err := openStore(cfg)
+	if err != nil {
+		fatal("Failed to initialise database", err)
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	if cfg.SeedOnStart {
+		if err := store.Seed(); err != nil {
+			fatal("Failed to seed database", err)
+		}
+		fmt.Println("Seeded sample data")
+	}
+
+	// Load the key used to sign session tokens
+	secret, err := loadSessionSecret()
+	if err != nil {
+		fatal("Failed to initialise session secret", err)
+	}
+
+	// Permanently delete old trash in the background
+	startTrashPurger(store, cfg.TrashRetention)
+
+	srv := newServer(cfg, store, secret)
+	host, port, _ := net.SplitHostPort(cfg.Addr)
+	if host == "" {
+		host = "localhost"
+	}
+	fmt.Printf("Server listening on http://%s\n", net.JoinHostPort(host, port))
+	if err := http.ListenAndServe(cfg.Addr, srv.routes()); err != nil {
 		fmt.Println("Error starting server:", err)
 	}
 }
@@ -0,0 +1,786 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// auditCount counts the audit log entries for action, on the target
+// with the given id unless it is 0.
+func (ts *testServer) auditCount(action string, targetID int) int {
+	ts.t.Helper()
+	log, err := ts.store.AuditLog(AuditFilter{Action: action}, pageRequest{limit: maxPageLimit, order: auditOrders[0]})
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	n := 0
+	for _, e := range log.Items {
+		if targetID == 0 || e.TargetID == targetID {
+			n++
+		}
+	}
+	return n
+}
+
+// notificationCount counts a fixture user's notifications of the given
+// kind, or of every kind for "".
+func (ts *testServer) notificationCount(username, kind string) int {
+	ts.t.Helper()
+	page := pageRequest{limit: maxPageLimit, order: notificationOrders[0]}
+	notifications, err := ts.store.Notifications(ts.users[username].ID, false, page)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	n := 0
+	for _, notification := range notifications.Items {
+		if kind == "" || notification.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// revisionCount counts the earlier versions of a post or comment.
+func (ts *testServer) revisionCount(targetType string, id int) int {
+	ts.t.Helper()
+	var n int
+	var err error
+	if targetType == "post" {
+		var revisions []PostRevision
+		revisions, err = ts.store.PostRevisions(id)
+		n = len(revisions)
+	} else {
+		var revisions []CommentRevision
+		revisions, err = ts.store.CommentRevisions(id)
+		n = len(revisions)
+	}
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return n
+}
+
+func TestCanModifyPost(t *testing.T) {
+	ts := newTestServer(t)
+	for _, tt := range []struct {
+		username string
+		post     Post
+		want     bool
+	}{
+		{"bob", ts.post, true},           // author
+		{"carol", ts.post, false},        // another member
+		{"tom", ts.post, true},           // moderator of the topic
+		{"tom", ts.homeworkPost, false},  // moderator of another topic
+		{"mona", ts.homeworkPost, true},  // global moderator
+		{"alice", ts.homeworkPost, true}, // admin
+		{"rita", ts.post, false},         // read-only
+		{"bob", Post{ID: 9999}, false},   // unknown post
+	} {
+		t.Run(fmt.Sprintf("%s/%d", tt.username, tt.post.ID), func(t *testing.T) {
+			got, err := canModifyPost(ts.store, ts.users[tt.username].ID, tt.post.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("canModifyPost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("read-only author", func(t *testing.T) {
+		if err := ts.store.SetRole(ts.users["bob"].ID, roleReadOnly); err != nil {
+			t.Fatal(err)
+		}
+		got, err := canModifyPost(ts.store, ts.users["bob"].ID, ts.post.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got {
+			t.Error("a read-only user can still modify their own post")
+		}
+	})
+}
+
+func TestCanModifyComment(t *testing.T) {
+	ts := newTestServer(t)
+	for _, tt := range []struct {
+		username  string
+		commentID int
+		want      bool
+	}{
+		{"carol", ts.comment.ID, true}, // author
+		{"bob", ts.comment.ID, false},  // author of the post, not the comment
+		{"tom", ts.comment.ID, true},   // moderator of the topic
+		{"mona", ts.comment.ID, true},  // global moderator
+		{"alice", ts.comment.ID, true}, // admin
+		{"rita", ts.comment.ID, false}, // read-only
+		{"carol", 9999, false},         // unknown comment
+	} {
+		t.Run(fmt.Sprintf("%s/%d", tt.username, tt.commentID), func(t *testing.T) {
+			got, err := canModifyComment(ts.store, ts.users[tt.username].ID, tt.commentID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("canModifyComment = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("moderator of another topic", func(t *testing.T) {
+		comment, err := ts.store.CreateComment(ts.homeworkPost.ID, nil, 0, ts.users["bob"].ID, "Try induction")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := canModifyComment(ts.store, ts.users["tom"].ID, comment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got {
+			t.Error("tom can modify a comment outside the topic he moderates")
+		}
+	})
+}
+
+func TestTopics(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodGet, "/topics", "", nil)
+	wantStatus(t, w, http.StatusOK)
+	if topics := decode[[]Topic](t, w); len(topics) != 3 {
+		t.Errorf("got %d topics, want 3", len(topics))
+	}
+
+	runRequests(t, []request{
+		{"create as moderator", http.MethodPost, "/topics", "mona", CreateTopicRequest{"New", "d"}, http.StatusCreated},
+		{"create as topic moderator", http.MethodPost, "/topics", "tom", CreateTopicRequest{"New", "d"}, http.StatusForbidden},
+		{"create as member", http.MethodPost, "/topics", "bob", CreateTopicRequest{"New", "d"}, http.StatusForbidden},
+		{"create anonymously", http.MethodPost, "/topics", "", CreateTopicRequest{"New", "d"}, http.StatusUnauthorized},
+		{"wrong method", http.MethodPatch, "/topics", "alice", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestUpdateTopic(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodPut, "/topics", "mona", UpdateTopicRequest{ID: ts.general.ID, Title: "Lounge", Description: "Anything goes"})
+	wantStatus(t, w, http.StatusOK)
+	if topic := decode[Topic](t, w); topic.Title != "Lounge" || topic.Description != "Anything goes" {
+		t.Errorf("topic = %+v, want it renamed", topic)
+	}
+	if n := ts.auditCount("topic.edit", ts.general.ID); n != 1 {
+		t.Errorf("%d topic.edit audit entries, want 1", n)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		body  UpdateTopicRequest
+		field string
+	}{
+		{"missing id", UpdateTopicRequest{Title: "Lounge"}, "id"},
+		{"missing title", UpdateTopicRequest{ID: 1}, "title"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			wantError(t, ts.do(http.MethodPut, "/topics", "mona", tt.body), http.StatusBadRequest, tt.field)
+		})
+	}
+
+	rename := UpdateTopicRequest{ID: 1, Title: "Lounge"}
+	runRequests(t, []request{
+		{"anonymous", http.MethodPut, "/topics", "", rename, http.StatusUnauthorized},
+		{"member", http.MethodPut, "/topics", "bob", rename, http.StatusForbidden},
+		{"topic moderator", http.MethodPut, "/topics", "tom", rename, http.StatusForbidden},
+		{"unknown topic", http.MethodPut, "/topics", "mona", UpdateTopicRequest{ID: 9999, Title: "Lounge"}, http.StatusNotFound},
+		{"invalid JSON", http.MethodPut, "/topics", "mona", "{", http.StatusBadRequest},
+	})
+}
+
+func TestDeleteTopic(t *testing.T) {
+	ts := newTestServer(t)
+	wantStatus(t, ts.do(http.MethodDelete, "/topics", "mona", DeleteTopicRequest{ID: ts.general.ID}), http.StatusNoContent)
+	if n := ts.auditCount("topic.delete", ts.general.ID); n != 1 {
+		t.Errorf("%d topic.delete audit entries, want 1", n)
+	}
+	// The topic's posts go with it
+	wantError(t, ts.do(http.MethodGet, "/posts/1", "", nil), http.StatusNotFound, "")
+	wantError(t, ts.do(http.MethodDelete, "/topics", "mona", DeleteTopicRequest{}), http.StatusBadRequest, "id")
+
+	remove := DeleteTopicRequest{ID: 1}
+	runRequests(t, []request{
+		{"anonymous", http.MethodDelete, "/topics", "", remove, http.StatusUnauthorized},
+		{"member", http.MethodDelete, "/topics", "bob", remove, http.StatusForbidden},
+		{"topic moderator", http.MethodDelete, "/topics", "tom", remove, http.StatusForbidden},
+		{"unknown topic", http.MethodDelete, "/topics", "mona", DeleteTopicRequest{ID: 9999}, http.StatusNotFound},
+		{"invalid JSON", http.MethodDelete, "/topics", "mona", "{", http.StatusBadRequest},
+	})
+}
+
+func TestArchiveTopic(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodPost, "/topics/archive", "mona", ArchiveTopicRequest{ID: ts.general.ID, Archived: true})
+	wantStatus(t, w, http.StatusOK)
+	if topic := decode[Topic](t, w); !topic.IsArchived {
+		t.Errorf("topic = %+v, want it archived", topic)
+	}
+	// Archived topics take no new posts
+	w = ts.do(http.MethodPost, "/topics/1/posts", "bob", CreatePostRequest{Title: "Late", Content: "Too late"})
+	wantError(t, w, http.StatusForbidden, "")
+
+	w = ts.do(http.MethodPost, "/topics/archive", "mona", ArchiveTopicRequest{ID: ts.general.ID})
+	wantStatus(t, w, http.StatusOK)
+	if topic := decode[Topic](t, w); topic.IsArchived {
+		t.Errorf("topic = %+v, want it unarchived", topic)
+	}
+	if ts.auditCount("topic.archive", ts.general.ID) != 1 || ts.auditCount("topic.unarchive", ts.general.ID) != 1 {
+		t.Error("want one topic.archive and one topic.unarchive audit entry")
+	}
+	wantError(t, ts.do(http.MethodPost, "/topics/archive", "mona", ArchiveTopicRequest{Archived: true}), http.StatusBadRequest, "id")
+
+	archive := ArchiveTopicRequest{ID: 1, Archived: true}
+	runRequests(t, []request{
+		{"anonymous", http.MethodPost, "/topics/archive", "", archive, http.StatusUnauthorized},
+		{"member", http.MethodPost, "/topics/archive", "bob", archive, http.StatusForbidden},
+		{"topic moderator", http.MethodPost, "/topics/archive", "tom", archive, http.StatusForbidden},
+		{"unknown topic", http.MethodPost, "/topics/archive", "mona", ArchiveTopicRequest{ID: 9999}, http.StatusNotFound},
+		{"invalid JSON", http.MethodPost, "/topics/archive", "mona", "{", http.StatusBadRequest},
+		{"wrong method", http.MethodPut, "/topics/archive", "mona", archive, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestListPosts(t *testing.T) {
+	ts := newTestServer(t)
+	pinned, err := ts.store.CreatePost(ts.general.ID, ts.users["carol"].ID, "Rules", "Be nice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.store.PinPost(pinned.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	w := ts.do(http.MethodGet, fmt.Sprintf("/topics/%d/posts", ts.general.ID), "", nil)
+	wantStatus(t, w, http.StatusOK)
+	page := decode[Page[Post]](t, w)
+	if len(page.Items) != 2 || page.Items[0].ID != pinned.ID || page.Items[1].ID != ts.post.ID {
+		t.Errorf("posts = %+v, want the pinned post first", page.Items)
+	}
+	if page.NextCursor != nil {
+		t.Error("a single page has a next cursor")
+	}
+
+	// Paging one post at a time gives the same posts
+	w = ts.do(http.MethodGet, fmt.Sprintf("/topics/%d/posts?limit=1", ts.general.ID), "", nil)
+	wantStatus(t, w, http.StatusOK)
+	first := decode[Page[Post]](t, w)
+	if len(first.Items) != 1 || first.NextCursor == nil {
+		t.Fatalf("first page = %+v, want one post and a cursor", first)
+	}
+	w = ts.do(http.MethodGet, fmt.Sprintf("/topics/%d/posts?limit=1&cursor=%s", ts.general.ID, *first.NextCursor), "", nil)
+	wantStatus(t, w, http.StatusOK)
+	if second := decode[Page[Post]](t, w); len(second.Items) != 1 || second.Items[0].ID != ts.post.ID {
+		t.Errorf("second page = %+v, want bob's post", second.Items)
+	}
+
+	runRequests(t, []request{
+		{"unknown topic", http.MethodGet, "/topics/9999/posts", "", nil, http.StatusNotFound},
+		{"invalid topic id", http.MethodGet, "/topics/x/posts", "", nil, http.StatusBadRequest},
+		{"invalid sort", http.MethodGet, "/topics/1/posts?sort=random", "", nil, http.StatusBadRequest},
+		{"invalid cursor", http.MethodGet, "/topics/1/posts?cursor=x", "", nil, http.StatusBadRequest},
+		{"invalid unresolved", http.MethodGet, "/topics/1/posts?unresolved=maybe", "", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodPatch, "/topics/1/posts", "bob", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestCreatePost(t *testing.T) {
+	ts := newTestServer(t)
+	path := fmt.Sprintf("/topics/%d/posts", ts.general.ID)
+	w := ts.do(http.MethodPost, path, "bob", CreatePostRequest{Title: "  Hi  ", Content: "**there**"})
+	wantStatus(t, w, http.StatusCreated)
+	post := decode[Post](t, w)
+	if post.TopicID != ts.general.ID || post.Title != "Hi" || post.Author != "bob" || !strings.Contains(post.ContentHTML, "<strong>there</strong>") {
+		t.Errorf("created post = %+v", post)
+	}
+
+	// The same post again is rejected
+	w = ts.do(http.MethodPost, path, "bob", CreatePostRequest{Title: "Hi", Content: "**there**"})
+	wantError(t, w, http.StatusConflict, "")
+
+	valid := CreatePostRequest{Title: "Hi", Content: "there"}
+	for _, tt := range []struct {
+		name     string
+		username string
+		topicID  int
+		body     any
+		status   int
+		field    string
+	}{
+		{"anonymous", "", ts.general.ID, valid, http.StatusUnauthorized, ""},
+		{"read-only", "rita", ts.general.ID, valid, http.StatusForbidden, ""},
+		{"banned", "ben", ts.general.ID, valid, http.StatusForbidden, ""},
+		{"unknown topic", "bob", 9999, valid, http.StatusNotFound, ""},
+		{"archived topic", "bob", ts.archived.ID, valid, http.StatusForbidden, ""},
+		{"blank title", "bob", ts.general.ID, CreatePostRequest{Title: "   ", Content: "there"}, http.StatusBadRequest, "title"},
+		{"missing content", "bob", ts.general.ID, CreatePostRequest{Title: "Hi"}, http.StatusBadRequest, "content"},
+		{"long title", "bob", ts.general.ID, CreatePostRequest{Title: strings.Repeat("x", maxPostTitleLength+1), Content: "there"}, http.StatusBadRequest, "title"},
+		{"invalid JSON", "bob", ts.general.ID, "{", http.StatusBadRequest, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			w := ts.do(http.MethodPost, fmt.Sprintf("/topics/%d/posts", tt.topicID), tt.username, tt.body)
+			wantError(t, w, tt.status, tt.field)
+		})
+	}
+}
+
+func TestGetPost(t *testing.T) {
+	ts := newTestServer(t)
+	if err := ts.store.VotePost(ts.users["carol"].ID, ts.post.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	w := ts.do(http.MethodGet, fmt.Sprintf("/posts/%d", ts.post.ID), "carol", nil)
+	wantStatus(t, w, http.StatusOK)
+	if post := decode[Post](t, w); post.ID != ts.post.ID || post.Score != 1 || post.MyVote != 1 {
+		t.Errorf("post = %+v, want bob's post with carol's vote", post)
+	}
+
+	if err := ts.store.TrashPost(ts.post.ID, ts.users["bob"].ID); err != nil {
+		t.Fatal(err)
+	}
+	wantError(t, ts.do(http.MethodGet, fmt.Sprintf("/posts/%d", ts.post.ID), "", nil), http.StatusNotFound, "")
+
+	runRequests(t, []request{
+		{"unknown post", http.MethodGet, "/posts/9999", "", nil, http.StatusNotFound},
+		{"invalid id", http.MethodGet, "/posts/x", "", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodPatch, "/posts/1", "bob", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestUpdatePost(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		username string
+		post     func(ts *testServer) int
+		status   int
+		audited  bool
+	}{
+		{"author", "bob", func(ts *testServer) int { return ts.post.ID }, http.StatusOK, false},
+		{"another member", "carol", func(ts *testServer) int { return ts.post.ID }, http.StatusForbidden, false},
+		{"topic moderator", "tom", func(ts *testServer) int { return ts.post.ID }, http.StatusOK, true},
+		{"moderator of another topic", "tom", func(ts *testServer) int { return ts.homeworkPost.ID }, http.StatusForbidden, false},
+		{"global moderator", "mona", func(ts *testServer) int { return ts.homeworkPost.ID }, http.StatusOK, true},
+		{"read-only", "rita", func(ts *testServer) int { return ts.post.ID }, http.StatusForbidden, false},
+		{"banned", "ben", func(ts *testServer) int { return ts.post.ID }, http.StatusForbidden, false},
+		{"anonymous", "", func(ts *testServer) int { return ts.post.ID }, http.StatusUnauthorized, false},
+		{"archived topic", "bob", func(ts *testServer) int { return ts.archivedPost.ID }, http.StatusForbidden, false},
+		{"unknown post", "alice", func(ts *testServer) int { return 9999 }, http.StatusNotFound, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			id := tt.post(ts)
+			w := ts.do(http.MethodPut, fmt.Sprintf("/posts/%d", id), tt.username, UpdatePostRequest{Title: "Edited", Content: "New content"})
+			if tt.status != http.StatusOK {
+				wantError(t, w, tt.status, "")
+				return
+			}
+			wantStatus(t, w, http.StatusOK)
+			post := decode[Post](t, w)
+			if post.Title != "Edited" || post.UpdatedAt == nil {
+				t.Errorf("updated post = %+v", post)
+			}
+			if n := ts.revisionCount("post", id); n != 1 {
+				t.Errorf("%d revisions, want 1", n)
+			}
+			audited := ts.auditCount("post.edit", id) == 1
+			if audited != tt.audited {
+				t.Errorf("audited = %v, want %v", audited, tt.audited)
+			}
+		})
+	}
+
+	ts := newTestServer(t)
+	for _, tt := range []struct {
+		name  string
+		body  any
+		field string
+	}{
+		{"blank title", UpdatePostRequest{Content: "New content"}, "title"},
+		{"blank content", UpdatePostRequest{Title: "Edited", Content: " "}, "content"},
+		{"invalid JSON", "{", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ts.do(http.MethodPut, fmt.Sprintf("/posts/%d", ts.post.ID), "bob", tt.body)
+			wantError(t, w, http.StatusBadRequest, tt.field)
+		})
+	}
+}
+
+func TestDeletePost(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		username string
+		post     func(ts *testServer) int
+		status   int
+		audited  bool
+	}{
+		{"author", "bob", func(ts *testServer) int { return ts.post.ID }, http.StatusNoContent, false},
+		{"another member", "carol", func(ts *testServer) int { return ts.post.ID }, http.StatusForbidden, false},
+		{"topic moderator", "tom", func(ts *testServer) int { return ts.post.ID }, http.StatusNoContent, true},
+		{"moderator of another topic", "tom", func(ts *testServer) int { return ts.homeworkPost.ID }, http.StatusForbidden, false},
+		{"global moderator", "mona", func(ts *testServer) int { return ts.homeworkPost.ID }, http.StatusNoContent, true},
+		{"read-only", "rita", func(ts *testServer) int { return ts.post.ID }, http.StatusForbidden, false},
+		{"banned", "ben", func(ts *testServer) int { return ts.post.ID }, http.StatusForbidden, false},
+		{"anonymous", "", func(ts *testServer) int { return ts.post.ID }, http.StatusUnauthorized, false},
+		{"unknown post", "alice", func(ts *testServer) int { return 9999 }, http.StatusNotFound, false},
+		{"archived topic", "bob", func(ts *testServer) int { return ts.archivedPost.ID }, http.StatusForbidden, false},
+		{"moderator in archived topic", "mona", func(ts *testServer) int { return ts.archivedPost.ID }, http.StatusForbidden, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			id := tt.post(ts)
+			w := ts.do(http.MethodDelete, fmt.Sprintf("/posts/%d", id), tt.username, nil)
+			if tt.status != http.StatusNoContent {
+				wantError(t, w, tt.status, "")
+				return
+			}
+			wantStatus(t, w, http.StatusNoContent)
+
+			// The post and its comments are gone from the forum
+			if _, err := ts.store.Post(id); err != errNotFound {
+				t.Errorf("Post after delete: err = %v, want errNotFound", err)
+			}
+			w = ts.do(http.MethodGet, fmt.Sprintf("/posts/%d/comments", id), "", nil)
+			wantError(t, w, http.StatusNotFound, "")
+			audited := ts.auditCount("post.delete", id) == 1
+			if audited != tt.audited {
+				t.Errorf("audited = %v, want %v", audited, tt.audited)
+			}
+		})
+	}
+}
+
+func TestPinPost(t *testing.T) {
+	runRequests(t, []request{
+		{"member", http.MethodPut, "/posts/1/pin", "bob", nil, http.StatusForbidden},
+		{"read-only", http.MethodPut, "/posts/1/pin", "rita", nil, http.StatusForbidden},
+		{"anonymous", http.MethodPut, "/posts/1/pin", "", nil, http.StatusUnauthorized},
+		{"unknown post", http.MethodPut, "/posts/9999/pin", "alice", nil, http.StatusNotFound},
+		{"invalid id", http.MethodPut, "/posts/x/pin", "alice", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/posts/1/pin", "alice", nil, http.StatusMethodNotAllowed},
+	})
+
+	t.Run("moderators", func(t *testing.T) {
+		ts := newTestServer(t)
+		for _, tt := range []struct {
+			username string
+			post     Post
+			status   int
+		}{
+			{"tom", ts.post, http.StatusOK},
+			{"tom", ts.homeworkPost, http.StatusForbidden},
+			{"mona", ts.homeworkPost, http.StatusOK},
+			{"ben", ts.post, http.StatusForbidden},
+		} {
+			w := ts.do(http.MethodPut, fmt.Sprintf("/posts/%d/pin", tt.post.ID), tt.username, nil)
+			if tt.status != http.StatusOK {
+				wantError(t, w, tt.status, "")
+				continue
+			}
+			wantStatus(t, w, http.StatusOK)
+			if post := decode[Post](t, w); !post.IsPinned {
+				t.Errorf("%s pinned post %d but it is not pinned", tt.username, tt.post.ID)
+			}
+		}
+	})
+
+	t.Run("pin and unpin", func(t *testing.T) {
+		ts := newTestServer(t)
+		for _, pinned := range []bool{true, true, false} {
+			w := ts.do(pinMethod(pinned), fmt.Sprintf("/posts/%d/pin", ts.post.ID), "tom", nil)
+			wantStatus(t, w, http.StatusOK)
+			if post := decode[Post](t, w); post.IsPinned != pinned {
+				t.Errorf("IsPinned = %v, want %v", post.IsPinned, pinned)
+			}
+		}
+		// Pinning an already pinned post does not notify the author again
+		if n := ts.notificationCount("bob", notifyPin); n != 1 {
+			t.Errorf("%d pin notifications, want 1", n)
+		}
+		if n := ts.auditCount("post.pin", 0) + ts.auditCount("post.unpin", 0); n != 3 {
+			t.Errorf("%d audit entries, want 3", n)
+		}
+	})
+}
+
+func TestListComments(t *testing.T) {
+	ts := newTestServer(t)
+	reply, err := ts.store.CreateComment(ts.post.ID, &ts.comment.ID, 1, ts.users["bob"].ID, "Hi carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := ts.do(http.MethodGet, fmt.Sprintf("/posts/%d/comments", ts.post.ID), "", nil)
+	wantStatus(t, w, http.StatusOK)
+	page := decode[Page[Comment]](t, w)
+	if len(page.Items) != 1 || len(page.Items[0].Replies) != 1 || page.Items[0].Replies[0].ID != reply.ID {
+		t.Errorf("comments = %+v, want carol's comment with bob's reply under it", page.Items)
+	}
+
+	if err := ts.store.TrashPost(ts.post.ID, ts.users["bob"].ID); err != nil {
+		t.Fatal(err)
+	}
+	w = ts.do(http.MethodGet, fmt.Sprintf("/posts/%d/comments", ts.post.ID), "", nil)
+	wantError(t, w, http.StatusNotFound, "")
+
+	runRequests(t, []request{
+		{"unknown post", http.MethodGet, "/posts/9999/comments", "", nil, http.StatusNotFound},
+		{"invalid post id", http.MethodGet, "/posts/x/comments", "", nil, http.StatusBadRequest},
+		{"invalid sort", http.MethodGet, "/posts/1/comments?sort=random", "", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodPatch, "/posts/1/comments", "bob", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestCreateComment(t *testing.T) {
+	ts := newTestServer(t)
+	path := fmt.Sprintf("/posts/%d/comments", ts.post.ID)
+	w := ts.do(http.MethodPost, path, "bob", CreateCommentRequest{ParentID: ts.comment.ID, Content: "Thanks @carol"})
+	wantStatus(t, w, http.StatusCreated)
+	reply := decode[Comment](t, w)
+	if reply.ParentID == nil || *reply.ParentID != ts.comment.ID || reply.Depth != 1 {
+		t.Errorf("reply = %+v, want it one level under carol's comment", reply)
+	}
+	// carol hears about the reply once, even though she is also mentioned
+	if n := ts.notificationCount("carol", ""); n != 1 {
+		t.Errorf("carol has %d notifications, want 1", n)
+	}
+
+	w = ts.do(http.MethodPost, path, "bob", CreateCommentRequest{ParentID: ts.comment.ID, Content: "Thanks @carol"})
+	wantError(t, w, http.StatusConflict, "")
+
+	// Replies can only go so deep
+	parentID := reply.ID
+	for depth := 2; depth <= maxCommentDepth; depth++ {
+		w := ts.do(http.MethodPost, path, "carol", CreateCommentRequest{ParentID: parentID, Content: fmt.Sprint("Level ", depth)})
+		wantStatus(t, w, http.StatusCreated)
+		parentID = decode[Comment](t, w).ID
+	}
+	w = ts.do(http.MethodPost, path, "carol", CreateCommentRequest{ParentID: parentID, Content: "Too deep"})
+	wantError(t, w, http.StatusBadRequest, "")
+
+	valid := CreateCommentRequest{Content: "Hi"}
+	for _, tt := range []struct {
+		name     string
+		username string
+		postID   int
+		body     any
+		status   int
+		field    string
+	}{
+		{"anonymous", "", ts.post.ID, valid, http.StatusUnauthorized, ""},
+		{"read-only", "rita", ts.post.ID, valid, http.StatusForbidden, ""},
+		{"banned", "ben", ts.post.ID, valid, http.StatusForbidden, ""},
+		{"unknown post", "bob", 9999, valid, http.StatusNotFound, ""},
+		{"unknown parent", "bob", ts.post.ID, CreateCommentRequest{ParentID: 9999, Content: "Hi"}, http.StatusNotFound, ""},
+		{"parent on another post", "bob", ts.homeworkPost.ID, CreateCommentRequest{ParentID: ts.comment.ID, Content: "Hi"}, http.StatusBadRequest, ""},
+		{"archived topic", "bob", ts.archivedPost.ID, valid, http.StatusForbidden, ""},
+		{"blank content", "bob", ts.post.ID, CreateCommentRequest{Content: "\t"}, http.StatusBadRequest, "content"},
+		{"long content", "bob", ts.post.ID, CreateCommentRequest{Content: strings.Repeat("x", maxCommentContentLength+1)}, http.StatusBadRequest, "content"},
+		{"invalid JSON", "bob", ts.post.ID, "{", http.StatusBadRequest, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			w := ts.do(http.MethodPost, fmt.Sprintf("/posts/%d/comments", tt.postID), tt.username, tt.body)
+			wantError(t, w, tt.status, tt.field)
+		})
+	}
+}
+
+func TestGetComment(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodGet, fmt.Sprintf("/comments/%d", ts.comment.ID), "", nil)
+	wantStatus(t, w, http.StatusOK)
+	if comment := decode[Comment](t, w); comment.ID != ts.comment.ID || comment.Author != "carol" {
+		t.Errorf("comment = %+v, want carol's comment", comment)
+	}
+
+	runRequests(t, []request{
+		{"unknown comment", http.MethodGet, "/comments/9999", "", nil, http.StatusNotFound},
+		{"invalid id", http.MethodGet, "/comments/x", "", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodPatch, "/comments/1", "carol", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestUpdateComment(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		username string
+		id       func(ts *testServer) int
+		status   int
+		audited  bool
+	}{
+		{"author", "carol", func(ts *testServer) int { return ts.comment.ID }, http.StatusOK, false},
+		{"author of the post", "bob", func(ts *testServer) int { return ts.comment.ID }, http.StatusForbidden, false},
+		{"topic moderator", "tom", func(ts *testServer) int { return ts.comment.ID }, http.StatusOK, true},
+		{"global moderator", "mona", func(ts *testServer) int { return ts.comment.ID }, http.StatusOK, true},
+		{"read-only", "rita", func(ts *testServer) int { return ts.comment.ID }, http.StatusForbidden, false},
+		{"anonymous", "", func(ts *testServer) int { return ts.comment.ID }, http.StatusUnauthorized, false},
+		{"unknown comment", "alice", func(ts *testServer) int { return 9999 }, http.StatusNotFound, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			id := tt.id(ts)
+			w := ts.do(http.MethodPut, fmt.Sprintf("/comments/%d", id), tt.username, UpdateCommentRequest{Content: "Edited"})
+			if tt.status != http.StatusOK {
+				wantError(t, w, tt.status, "")
+				return
+			}
+			wantStatus(t, w, http.StatusOK)
+			if comment := decode[Comment](t, w); comment.Content != "Edited" || comment.UpdatedAt == nil {
+				t.Errorf("updated comment = %+v", comment)
+			}
+			if n := ts.revisionCount("comment", id); n != 1 {
+				t.Errorf("%d revisions, want 1", n)
+			}
+			audited := ts.auditCount("comment.edit", id) == 1
+			if audited != tt.audited {
+				t.Errorf("audited = %v, want %v", audited, tt.audited)
+			}
+		})
+	}
+
+	t.Run("archived topic", func(t *testing.T) {
+		ts := newTestServer(t)
+		if _, err := ts.store.ArchiveTopic(ts.general.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		w := ts.do(http.MethodPut, fmt.Sprintf("/comments/%d", ts.comment.ID), "carol", UpdateCommentRequest{Content: "Edited"})
+		wantError(t, w, http.StatusForbidden, "")
+	})
+
+	ts := newTestServer(t)
+	path := fmt.Sprintf("/comments/%d", ts.comment.ID)
+	wantError(t, ts.do(http.MethodPut, path, "carol", UpdateCommentRequest{}), http.StatusBadRequest, "content")
+	wantError(t, ts.do(http.MethodPut, path, "carol", "{"), http.StatusBadRequest, "")
+}
+
+func TestDeleteComment(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		username string
+		id       func(ts *testServer) int
+		status   int
+		audited  bool
+	}{
+		{"author", "carol", func(ts *testServer) int { return ts.comment.ID }, http.StatusNoContent, false},
+		{"author of the post", "bob", func(ts *testServer) int { return ts.comment.ID }, http.StatusForbidden, false},
+		{"topic moderator", "tom", func(ts *testServer) int { return ts.comment.ID }, http.StatusNoContent, true},
+		{"global moderator", "mona", func(ts *testServer) int { return ts.comment.ID }, http.StatusNoContent, true},
+		{"read-only", "rita", func(ts *testServer) int { return ts.comment.ID }, http.StatusForbidden, false},
+		{"banned", "ben", func(ts *testServer) int { return ts.comment.ID }, http.StatusForbidden, false},
+		{"anonymous", "", func(ts *testServer) int { return ts.comment.ID }, http.StatusUnauthorized, false},
+		{"unknown comment", "alice", func(ts *testServer) int { return 9999 }, http.StatusNotFound, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			id := tt.id(ts)
+			w := ts.do(http.MethodDelete, fmt.Sprintf("/comments/%d", id), tt.username, nil)
+			if tt.status != http.StatusNoContent {
+				wantError(t, w, tt.status, "")
+				return
+			}
+			wantStatus(t, w, http.StatusNoContent)
+			if _, err := ts.store.Comment(id); err != errNotFound {
+				t.Errorf("Comment after delete: err = %v, want errNotFound", err)
+			}
+			audited := ts.auditCount("comment.delete", id) == 1
+			if audited != tt.audited {
+				t.Errorf("audited = %v, want %v", audited, tt.audited)
+			}
+		})
+	}
+
+	t.Run("archived topic", func(t *testing.T) {
+		ts := newTestServer(t)
+		if _, err := ts.store.ArchiveTopic(ts.general.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		path := fmt.Sprintf("/comments/%d", ts.comment.ID)
+		for _, username := range []string{"carol", "mona"} {
+			wantError(t, ts.do(http.MethodDelete, path, username, nil), http.StatusForbidden, "")
+		}
+		if _, err := ts.store.Comment(ts.comment.ID); err != nil {
+			t.Errorf("Comment after refused delete: %v", err)
+		}
+	})
+}
+
+func TestPinComment(t *testing.T) {
+	runRequests(t, []request{
+		{"topic moderator", http.MethodPut, "/comments/1/pin", "tom", nil, http.StatusOK},
+		{"global moderator", http.MethodPut, "/comments/1/pin", "mona", nil, http.StatusOK},
+		{"author", http.MethodPut, "/comments/1/pin", "carol", nil, http.StatusForbidden},
+		{"author of the post", http.MethodPut, "/comments/1/pin", "bob", nil, http.StatusForbidden},
+		{"anonymous", http.MethodPut, "/comments/1/pin", "", nil, http.StatusUnauthorized},
+		{"unknown comment", http.MethodPut, "/comments/9999/pin", "alice", nil, http.StatusNotFound},
+		{"invalid id", http.MethodPut, "/comments/x/pin", "alice", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/comments/1/pin", "alice", nil, http.StatusMethodNotAllowed},
+	})
+
+	t.Run("moderator of another topic", func(t *testing.T) {
+		ts := newTestServer(t)
+		comment, err := ts.store.CreateComment(ts.homeworkPost.ID, nil, 0, ts.users["bob"].ID, "Try induction")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := ts.do(http.MethodPut, fmt.Sprintf("/comments/%d/pin", comment.ID), "tom", nil)
+		wantError(t, w, http.StatusForbidden, "")
+	})
+
+	t.Run("pin and unpin", func(t *testing.T) {
+		ts := newTestServer(t)
+		for _, pinned := range []bool{true, false} {
+			w := ts.do(pinMethod(pinned), fmt.Sprintf("/comments/%d/pin", ts.comment.ID), "tom", nil)
+			wantStatus(t, w, http.StatusOK)
+			if comment := decode[Comment](t, w); comment.IsPinned != pinned {
+				t.Errorf("IsPinned = %v, want %v", comment.IsPinned, pinned)
+			}
+		}
+	})
+}
+
+// A comment goes into the trash with its post, so everything that takes
+// a comment id answers 404 for it until the post is restored.
+func TestCommentOnTrashedPost(t *testing.T) {
+	path := "/comments/1"
+	for _, tt := range []struct {
+		name, method, path, username string
+		body                         any
+	}{
+		{"get", http.MethodGet, path, "", nil},
+		{"update", http.MethodPut, path, "carol", UpdateCommentRequest{Content: "Edited"}},
+		{"delete", http.MethodDelete, path, "carol", nil},
+		{"vote", http.MethodPut, path + "/vote", "bob", VoteRequest{Value: 1}},
+		{"retract vote", http.MethodDelete, path + "/vote", "bob", nil},
+		{"pin", http.MethodPut, path + "/pin", "tom", nil},
+		{"revisions", http.MethodGet, path + "/revisions", "", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			wantStatus(t, ts.do(http.MethodDelete, fmt.Sprintf("/posts/%d", ts.post.ID), "bob", nil), http.StatusNoContent)
+			wantError(t, ts.do(tt.method, tt.path, tt.username, tt.body), http.StatusNotFound, "")
+		})
+	}
+
+	t.Run("restore", func(t *testing.T) {
+		ts := newTestServer(t)
+		restore := RestoreRequest{Type: "comment", ID: ts.comment.ID}
+		wantStatus(t, ts.do(http.MethodDelete, fmt.Sprintf("/comments/%d", ts.comment.ID), "carol", nil), http.StatusNoContent)
+		wantStatus(t, ts.do(http.MethodDelete, fmt.Sprintf("/posts/%d", ts.post.ID), "bob", nil), http.StatusNoContent)
+
+		// Not while the post is in the trash
+		wantError(t, ts.do(http.MethodPost, "/trash/restore", "mona", restore), http.StatusConflict, "")
+
+		wantStatus(t, ts.do(http.MethodPost, "/trash/restore", "mona", RestoreRequest{Type: "post", ID: ts.post.ID}), http.StatusOK)
+		wantStatus(t, ts.do(http.MethodPost, "/trash/restore", "mona", restore), http.StatusOK)
+		wantStatus(t, ts.do(http.MethodGet, fmt.Sprintf("/comments/%d", ts.comment.ID), "", nil), http.StatusOK)
+	})
+}
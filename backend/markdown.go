@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdown renders CommonMark with the GitHub extensions: tables,
+// strikethrough, task lists and bare URLs as links. Fenced code blocks
+// are part of CommonMark itself.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// sanitizer strips everything from rendered HTML that user content
+// should not contain: scripts, event handlers, styles, iframes and
+// javascript: links. Links get rel="nofollow". The language class of
+// a fenced code block is kept for syntax highlighting.
+var sanitizer = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+	return p
+}()
+
+// renderMarkdown turns the Markdown source of a post or comment into
+// HTML that is safe to insert into the page.
+func renderMarkdown(source string) string {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		// goldmark only fails if writing to buf fails, which it cannot
+		slog.Error("Failed to render Markdown", "err", err)
+		return sanitizer.Sanitize(source)
+	}
+	return sanitizer.Sanitize(buf.String())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"emphasis", "Say **hello**", "<p>Say <strong>hello</strong></p>\n"},
+		{"table", "| a | b |\n|---|---|\n| 1 | 2 |",
+			"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n"},
+		{"fenced code", "```go\nfmt.Println(\"<hi>\")\n```",
+			"<pre><code class=\"language-go\">fmt.Println(&#34;&lt;hi&gt;&#34;)\n</code></pre>\n"},
+		{"fenced code with extra info", "```c++ onclick=alert(1)\nx\n```", "<pre><code class=\"language-c++\">x\n</code></pre>\n"},
+		{"autolink", "see https://example.com now",
+			"<p>see <a href=\"https://example.com\" rel=\"nofollow\">https://example.com</a> now</p>\n"},
+		{"link", "[ok](https://example.com)", "<p><a href=\"https://example.com\" rel=\"nofollow\">ok</a></p>\n"},
+		{"strikethrough", "~~gone~~", "<p><del>gone</del></p>\n"},
+		{"javascript link", "[x](javascript:alert(1))", "<p>x</p>\n"},
+		{"javascript link in capitals", "[x](JaVaScRiPt:alert(1))", "<p>x</p>\n"},
+		{"script", "<script>alert(1)</script>", "\n"},
+		{"inline script", "hi <script>alert(1)</script>", "<p>hi alert(1)</p>\n"},
+		{"event handler", "<p onclick=\"alert(1)\">x</p>", "\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMarkdown(tt.source); got != tt.want {
+				t.Errorf("renderMarkdown(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+// The sanitizer gets HTML straight away here, as it would if Markdown
+// rendering ever let raw HTML through.
+func TestSanitizer(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		html string
+		want string
+	}{
+		{"script", `<p>hi</p><script>alert(1)</script>`, `<p>hi</p>`},
+		{"javascript link", `<a href="javascript:alert(1)">x</a>`, `x`},
+		{"javascript link with entities", `<a href="&#106;avascript:alert(1)">x</a>`, `x`},
+		{"onclick", `<p onclick="alert(1)">x</p>`, `<p>x</p>`},
+		{"onerror", `<img src="x.png" onerror="alert(1)">`, `<img src="x.png">`},
+		{"onmouseover", `<a href="https://example.com" onmouseover="alert(1)">x</a>`, `<a href="https://example.com" rel="nofollow">x</a>`},
+		{"style", `<span style="color:red">x</span>`, `<span>x</span>`},
+		{"iframe", `<iframe src="https://example.com"></iframe>`, ``},
+		{"language class", `<code class="language-go">x</code>`, `<code class="language-go">x</code>`},
+		{"other class", `<code class="evil">x</code>`, `<code>x</code>`},
+		{"language class elsewhere", `<p class="language-go">x</p>`, `<p>x</p>`},
+		{"table", `<table><tr><td>1</td></tr></table>`, `<table><tr><td>1</td></tr></table>`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizer.Sanitize(tt.html)
+			if got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+			if strings.Contains(strings.ToLower(got), "javascript:") || strings.Contains(got, " on") {
+				t.Errorf("Sanitize(%q) = %q still has a script", tt.html, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,1513 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryRolePermissions mirrors the grants the migrations put in the
+// role_permissions table. A migration that changes the grants must
+// change them here too; TestMemoryRolePermissions compares the two.
+var memoryRolePermissions = map[string][]permission{
+	roleAdmin: {
+		permCreateContent, permVote, permEditOwn, permModerateContent, permManageTrash,
+		permManageTopics, permManageRoles, permSanctionUsers, permReviewReports, permViewAudit,
+	},
+	roleModerator: {
+		permCreateContent, permVote, permEditOwn, permModerateContent, permManageTrash,
+		permManageTopics, permSanctionUsers, permReviewReports, permViewAudit,
+	},
+	topicModeratorRole: {permModerateContent, permReviewReports},
+	roleMember:         {permCreateContent, permVote, permEditOwn},
+}
+
+// memoryStore is a Store that keeps everything in memory and loses it
+// on exit, for trying the forum out. Every method holds the lock; Tx
+// holds it for the whole transaction and works on a copy of the data,
+// which replaces the original only if fn succeeds.
+type memoryStore struct {
+	mu   *sync.Mutex
+	data *memoryData
+	inTx bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{mu: &sync.Mutex{}, data: newMemoryData()}
+}
+
+// lock takes the lock, unless a transaction already holds it, and
+// returns the matching unlock.
+func (s *memoryStore) lock() func() {
+	if s.inTx {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *memoryStore) Tx(fn func(tx Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryStore{mu: s.mu, data: s.data.clone(), inTx: true}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.data = tx.data
+	return nil
+}
+
+// Seed inserts the same sample data as the SQLite store, into whatever
+// is still empty.
+func (s *memoryStore) Seed() error {
+	return s.Tx(func(st Store) error {
+		tx := st.(*memoryStore)
+		d := tx.data
+
+		if len(d.users) == 0 {
+			hash, err := hashPassword(seedPassword)
+			if err != nil {
+				return err
+			}
+			for _, name := range []string{"alice", "bob"} {
+				role := roleMember
+				if name == "alice" {
+					role = roleAdmin
+				}
+				if _, err := tx.CreateUser(name, hash, role); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(d.topics) == 0 {
+			if _, err := tx.CreateTopic("General", "General discussion"); err != nil {
+				return err
+			}
+			if _, err := tx.CreateTopic("Homework", "Ask about assignments"); err != nil {
+				return err
+			}
+		}
+
+		// Sample content goes to the sample users and topics by name, and
+		// is left out if they have been removed or renamed
+		users := make(map[string]int)
+		for _, u := range d.users {
+			users[u.username] = u.id
+		}
+
+		if len(d.posts) == 0 {
+			topics := make(map[string]int)
+			for _, t := range d.topics {
+				if id, ok := topics[t.Title]; !ok || t.ID < id {
+					topics[t.Title] = t.ID
+				}
+			}
+			found := true
+			for _, p := range seedPosts {
+				found = found && topics[p.topic] != 0 && users[p.author] != 0
+			}
+			if found {
+				if err := tx.seedPosts(topics, users); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(d.comments) == 0 {
+			posts := make(map[string]int)
+			for _, p := range d.posts {
+				if id, ok := posts[p.title]; !ok || p.id < id {
+					posts[p.title] = p.id
+				}
+			}
+			found := true
+			for _, c := range seedComments {
+				found = found && posts[c.post] != 0 && users[c.author] != 0
+			}
+			if found {
+				if err := tx.seedComments(posts, users); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// seedPosts creates the sample posts, given the ids of their topics and
+// authors by name.
+func (s *memoryStore) seedPosts(topics, users map[string]int) error {
+	for _, p := range seedPosts {
+		post, err := s.CreatePost(topics[p.topic], users[p.author], p.title, p.content)
+		if err != nil {
+			return err
+		}
+		if p.pinned {
+			if _, err := s.PinPost(post.ID, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// seedComments creates the sample comments, given the ids of their
+// posts by title and of their authors by name.
+func (s *memoryStore) seedComments(posts, users map[string]int) error {
+	ids := make([]int, len(seedComments))
+	for i, c := range seedComments {
+		var parentID *int
+		depth := 0
+		if c.parent >= 0 {
+			parentID, depth = &ids[c.parent], 1
+		}
+		comment, err := s.CreateComment(posts[c.post], parentID, depth, users[c.author], c.content)
+		if err != nil {
+			return err
+		}
+		ids[i] = comment.ID
+		if c.pinned {
+			if _, err := s.PinComment(comment.ID, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ---- records ----
+
+type memoryUser struct {
+	id           int
+	username     string
+	passwordHash string
+	role         string
+}
+
+type memorySession struct {
+	userID  int
+	expires time.Time
+}
+
+type memorySanction struct {
+	id        int
+	userID    int
+	issuedBy  int
+	reason    string
+	createdAt time.Time
+	expiresAt *time.Time
+	liftedAt  *time.Time
+	liftedBy  int
+}
+
+type memoryPost struct {
+	id         int
+	topicID    int
+	userID     int
+	title      string
+	content    string
+	pinned     bool
+	acceptedID *int
+	createdAt  time.Time
+	updatedAt  *time.Time
+	updatedBy  int
+	deletedAt  *time.Time
+	deletedBy  int
+}
+
+type memoryComment struct {
+	id        int
+	postID    int
+	parentID  *int
+	depth     int
+	userID    int
+	content   string
+	pinned    bool
+	createdAt time.Time
+	updatedAt *time.Time
+	updatedBy int
+	deletedAt *time.Time
+	deletedBy int
+}
+
+type memoryModerator struct {
+	topicID, userID int
+}
+
+type memoryVote struct {
+	userID     int
+	targetType string
+	targetID   int
+}
+
+type memoryPostRevision struct {
+	id        int
+	postID    int
+	title     string
+	content   string
+	editorID  int
+	createdAt time.Time
+}
+
+type memoryCommentRevision struct {
+	id        int
+	commentID int
+	content   string
+	editorID  int
+	createdAt time.Time
+}
+
+type memoryNotification struct {
+	id        int
+	userID    int
+	actorID   int
+	kind      string
+	postID    int
+	commentID *int
+	createdAt time.Time
+	readAt    *time.Time
+}
+
+type memoryReport struct {
+	id         int
+	reporterID int
+	targetType string
+	targetID   int
+	topicID    int
+	reason     string
+	createdAt  time.Time
+	resolution *string
+	resolvedBy int
+	resolvedAt *time.Time
+	note       string
+}
+
+// memoryData holds the records, keyed by id, and the last id handed
+// out for each kind of record. Records are values and their pointer
+// fields are never written through, so a shallow copy of the maps is
+// enough to snapshot everything.
+type memoryData struct {
+	users            map[int]memoryUser
+	sessions         map[string]memorySession
+	sanctions        map[int]memorySanction
+	topics           map[int]Topic
+	posts            map[int]memoryPost
+	comments         map[int]memoryComment
+	moderators       map[memoryModerator]bool
+	votes            map[memoryVote]int
+	postRevisions    map[int]memoryPostRevision
+	commentRevisions map[int]memoryCommentRevision
+	audit            map[int]AuditEntry
+	notifications    map[int]memoryNotification
+	reports          map[int]memoryReport
+	lastIDs          map[string]int
+}
+
+func newMemoryData() *memoryData {
+	return &memoryData{
+		users:            make(map[int]memoryUser),
+		sessions:         make(map[string]memorySession),
+		sanctions:        make(map[int]memorySanction),
+		topics:           make(map[int]Topic),
+		posts:            make(map[int]memoryPost),
+		comments:         make(map[int]memoryComment),
+		moderators:       make(map[memoryModerator]bool),
+		votes:            make(map[memoryVote]int),
+		postRevisions:    make(map[int]memoryPostRevision),
+		commentRevisions: make(map[int]memoryCommentRevision),
+		audit:            make(map[int]AuditEntry),
+		notifications:    make(map[int]memoryNotification),
+		reports:          make(map[int]memoryReport),
+		lastIDs:          make(map[string]int),
+	}
+}
+
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		users:            maps.Clone(d.users),
+		sessions:         maps.Clone(d.sessions),
+		sanctions:        maps.Clone(d.sanctions),
+		topics:           maps.Clone(d.topics),
+		posts:            maps.Clone(d.posts),
+		comments:         maps.Clone(d.comments),
+		moderators:       maps.Clone(d.moderators),
+		votes:            maps.Clone(d.votes),
+		postRevisions:    maps.Clone(d.postRevisions),
+		commentRevisions: maps.Clone(d.commentRevisions),
+		audit:            maps.Clone(d.audit),
+		notifications:    maps.Clone(d.notifications),
+		reports:          maps.Clone(d.reports),
+		lastIDs:          maps.Clone(d.lastIDs),
+	}
+}
+
+// nextID hands out ids like AUTOINCREMENT: never reused, starting at 1.
+func (d *memoryData) nextID(kind string) int {
+	d.lastIDs[kind]++
+	return d.lastIDs[kind]
+}
+
+// sortedIDs returns the keys of m, lowest first.
+func sortedIDs[V any](m map[int]V) []int {
+	return slices.Sorted(maps.Keys(m))
+}
+
+func (d *memoryData) score(targetType string, id int) int {
+	score := 0
+	for vote, value := range d.votes {
+		if vote.targetType == targetType && vote.targetID == id {
+			score += value
+		}
+	}
+	return score
+}
+
+func (d *memoryData) post(p memoryPost) Post {
+	post := Post{
+		ID:          p.id,
+		TopicID:     p.topicID,
+		Title:       p.title,
+		Content:     p.content,
+		ContentHTML: renderMarkdown(p.content),
+		Author:      d.users[p.userID].username,
+		IsPinned:    p.pinned,
+		Score:       d.score("post", p.id),
+		CreatedAt:   p.createdAt,
+		UpdatedAt:   p.updatedAt,
+	}
+	// Like postAcceptedComment, an answer in the trash does not count
+	if p.acceptedID != nil {
+		if c, ok := d.comments[*p.acceptedID]; ok && c.deletedAt == nil {
+			post.AcceptedCommentID = p.acceptedID
+			post.IsResolved = true
+		}
+	}
+	return post
+}
+
+func (d *memoryData) comment(c memoryComment) Comment {
+	return Comment{
+		ID:          c.id,
+		PostID:      c.postID,
+		ParentID:    c.parentID,
+		Depth:       c.depth,
+		Content:     c.content,
+		ContentHTML: renderMarkdown(c.content),
+		Author:      d.users[c.userID].username,
+		IsPinned:    c.pinned,
+		Score:       d.score("comment", c.id),
+		IsDeleted:   c.deletedAt != nil,
+		CreatedAt:   c.createdAt,
+		UpdatedAt:   c.updatedAt,
+	}
+}
+
+func (d *memoryData) user(u memoryUser) User {
+	user := User{
+		ID:                u.id,
+		Username:          u.username,
+		Role:              u.role,
+		IsModerator:       isGlobalModerator(u.role),
+		ModeratedTopicIDs: []int{},
+	}
+	for m := range d.moderators {
+		if m.userID == u.id {
+			user.ModeratedTopicIDs = append(user.ModeratedTopicIDs, m.topicID)
+		}
+	}
+	slices.Sort(user.ModeratedTopicIDs)
+	return user
+}
+
+func (d *memoryData) sanction(s memorySanction) Sanction {
+	return Sanction{
+		ID:        s.id,
+		UserID:    s.userID,
+		Username:  d.users[s.userID].username,
+		Reason:    s.reason,
+		IssuedBy:  d.users[s.issuedBy].username,
+		CreatedAt: s.createdAt,
+		ExpiresAt: s.expiresAt,
+	}
+}
+
+// active reports whether a sanction is in force at now.
+func (s memorySanction) active(now time.Time) bool {
+	return s.liftedAt == nil && (s.expiresAt == nil || s.expiresAt.After(now))
+}
+
+func (d *memoryData) report(r memoryReport) Report {
+	rep := Report{
+		ID:         r.id,
+		TargetType: r.targetType,
+		TargetID:   r.targetID,
+		TopicID:    r.topicID,
+		Reason:     r.reason,
+		Reporter:   d.users[r.reporterID].username,
+		CreatedAt:  r.createdAt,
+		Resolution: r.resolution,
+		ResolvedAt: r.resolvedAt,
+		Note:       r.note,
+	}
+	if r.resolvedAt != nil {
+		resolver := d.users[r.resolvedBy].username
+		rep.ResolvedBy = &resolver
+	}
+	return rep
+}
+
+// notificationPost returns the post a notification is about, and false
+// if it or the comment the notification is about is in the trash.
+func (d *memoryData) notificationPost(n memoryNotification) (memoryPost, bool) {
+	p, ok := d.posts[n.postID]
+	if !ok || p.deletedAt != nil {
+		return memoryPost{}, false
+	}
+	if n.commentID != nil {
+		if c, ok := d.comments[*n.commentID]; !ok || c.deletedAt != nil {
+			return memoryPost{}, false
+		}
+	}
+	return p, true
+}
+
+// ---- topics ----
+
+func (s *memoryStore) Topics() ([]Topic, error) {
+	defer s.lock()()
+	var topics []Topic
+	for _, id := range sortedIDs(s.data.topics) {
+		topics = append(topics, s.data.topics[id])
+	}
+	return topics, nil
+}
+
+func (s *memoryStore) Topic(id int) (Topic, error) {
+	defer s.lock()()
+	t, ok := s.data.topics[id]
+	if !ok {
+		return t, errNotFound
+	}
+	return t, nil
+}
+
+func (s *memoryStore) CreateTopic(title, description string) (Topic, error) {
+	defer s.lock()()
+	t := Topic{ID: s.data.nextID("topics"), Title: title, Description: description}
+	s.data.topics[t.ID] = t
+	return t, nil
+}
+
+func (s *memoryStore) UpdateTopic(id int, title, description string) (Topic, error) {
+	defer s.lock()()
+	t, ok := s.data.topics[id]
+	if !ok {
+		return t, errNotFound
+	}
+	t.Title, t.Description = title, description
+	s.data.topics[id] = t
+	return t, nil
+}
+
+func (s *memoryStore) ArchiveTopic(id int, archived bool) (Topic, error) {
+	defer s.lock()()
+	t, ok := s.data.topics[id]
+	if !ok {
+		return t, errNotFound
+	}
+	t.IsArchived = archived
+	s.data.topics[id] = t
+	return t, nil
+}
+
+func (s *memoryStore) DeleteTopic(id int) error {
+	defer s.lock()()
+	d := s.data
+	for postID, p := range d.posts {
+		if p.topicID == id {
+			d.deletePost(postID)
+		}
+	}
+	for reportID, rep := range d.reports {
+		if rep.topicID == id {
+			delete(d.reports, reportID)
+		}
+	}
+	for m := range d.moderators {
+		if m.topicID == id {
+			delete(d.moderators, m)
+		}
+	}
+	delete(d.topics, id)
+	return nil
+}
+
+// deletePost deletes a post for good, with its comments and everything
+// attached to either.
+func (d *memoryData) deletePost(id int) {
+	for commentID, c := range d.comments {
+		if c.postID == id {
+			d.deleteComment(commentID)
+		}
+	}
+	d.deleteAttached("post", id)
+	for revID, rev := range d.postRevisions {
+		if rev.postID == id {
+			delete(d.postRevisions, revID)
+		}
+	}
+	for nID, n := range d.notifications {
+		if n.postID == id {
+			delete(d.notifications, nID)
+		}
+	}
+	delete(d.posts, id)
+}
+
+// deleteComment deletes a comment for good, with everything attached
+// to it but not its replies.
+func (d *memoryData) deleteComment(id int) {
+	d.deleteAttached("comment", id)
+	for revID, rev := range d.commentRevisions {
+		if rev.commentID == id {
+			delete(d.commentRevisions, revID)
+		}
+	}
+	for nID, n := range d.notifications {
+		if n.commentID != nil && *n.commentID == id {
+			delete(d.notifications, nID)
+		}
+	}
+	delete(d.comments, id)
+}
+
+// deleteAttached deletes the votes on and reports about a post or comment.
+func (d *memoryData) deleteAttached(targetType string, id int) {
+	for vote := range d.votes {
+		if vote.targetType == targetType && vote.targetID == id {
+			delete(d.votes, vote)
+		}
+	}
+	for reportID, rep := range d.reports {
+		if rep.targetType == targetType && rep.targetID == id {
+			delete(d.reports, reportID)
+		}
+	}
+}
+
+// ---- posts ----
+
+func (s *memoryStore) Posts(topicID int, unresolved bool, page pageRequest) (Page[Post], error) {
+	defer s.lock()()
+	d := s.data
+
+	commentCounts := make(map[int]int64)
+	for _, c := range d.comments {
+		if c.deletedAt == nil {
+			commentCounts[c.postID]++
+		}
+	}
+
+	var posts []Post
+	for _, p := range d.posts {
+		if p.topicID != topicID || p.deletedAt != nil {
+			continue
+		}
+		post := d.post(p)
+		if unresolved && post.IsResolved {
+			continue
+		}
+		posts = append(posts, post)
+	}
+
+	// One entry per postOrders key
+	return paginate(page, posts, func(p Post) []int64 {
+		id := int64(p.ID)
+		switch page.order.name {
+		case "pinned-first":
+			return []int64{int64(boolToInt(p.IsPinned)), id}
+		case "most-commented":
+			return []int64{commentCounts[p.ID], id}
+		case "top":
+			return []int64{int64(p.Score), id}
+		}
+		return []int64{id}
+	}), nil
+}
+
+func (s *memoryStore) Post(id int) (Post, error) {
+	defer s.lock()()
+	p, ok := s.data.posts[id]
+	if !ok || p.deletedAt != nil {
+		return Post{}, errNotFound
+	}
+	return s.data.post(p), nil
+}
+
+func (s *memoryStore) PostOwner(id int) (userID, topicID int, err error) {
+	defer s.lock()()
+	p, ok := s.data.posts[id]
+	if !ok || p.deletedAt != nil {
+		return 0, 0, errNotFound
+	}
+	return p.userID, p.topicID, nil
+}
+
+func (s *memoryStore) CreatePost(topicID, userID int, title, content string) (Post, error) {
+	defer s.lock()()
+	p := memoryPost{
+		id:        s.data.nextID("posts"),
+		topicID:   topicID,
+		userID:    userID,
+		title:     title,
+		content:   content,
+		createdAt: time.Now().UTC(),
+	}
+	s.data.posts[p.id] = p
+	return s.data.post(p), nil
+}
+
+func (s *memoryStore) UpdatePost(id, editorID int, title, content string) (Post, error) {
+	defer s.lock()()
+	p, ok := s.data.posts[id]
+	if !ok {
+		return Post{}, errNotFound
+	}
+
+	// Keep the version being replaced, credited to whoever wrote it
+	rev := memoryPostRevision{
+		id:        s.data.nextID("post_revisions"),
+		postID:    id,
+		title:     p.title,
+		content:   p.content,
+		editorID:  p.userID,
+		createdAt: p.createdAt,
+	}
+	if p.updatedAt != nil {
+		rev.editorID, rev.createdAt = p.updatedBy, *p.updatedAt
+	}
+	s.data.postRevisions[rev.id] = rev
+
+	now := time.Now().UTC()
+	p.title, p.content, p.updatedAt, p.updatedBy = title, content, &now, editorID
+	s.data.posts[id] = p
+	return s.data.post(p), nil
+}
+
+func (s *memoryStore) PinPost(id int, pinned bool) (Post, error) {
+	defer s.lock()()
+	p, ok := s.data.posts[id]
+	if !ok {
+		return Post{}, errNotFound
+	}
+	p.pinned = pinned
+	s.data.posts[id] = p
+	return s.data.post(p), nil
+}
+
+func (s *memoryStore) AcceptAnswer(id int, commentID *int) (Post, error) {
+	defer s.lock()()
+	p, ok := s.data.posts[id]
+	if !ok {
+		return Post{}, errNotFound
+	}
+	p.acceptedID = commentID
+	s.data.posts[id] = p
+	return s.data.post(p), nil
+}
+
+func (s *memoryStore) TrashPost(id, userID int) error {
+	defer s.lock()()
+	p, ok := s.data.posts[id]
+	if !ok {
+		return errNotFound
+	}
+	now := time.Now().UTC()
+	p.deletedAt, p.deletedBy = &now, userID
+	s.data.posts[id] = p
+	return nil
+}
+
+func (s *memoryStore) VotePost(userID, id, value int) error {
+	defer s.lock()()
+	s.data.vote(memoryVote{userID, "post", id}, value)
+	return nil
+}
+
+func (s *memoryStore) PostVotes(userID int, ids []int) (map[int]int, error) {
+	defer s.lock()()
+	return s.data.myVotes(userID, "post", ids), nil
+}
+
+func (s *memoryStore) PostRevisions(id int) ([]PostRevision, error) {
+	defer s.lock()()
+	revisions := []PostRevision{}
+	ids := sortedIDs(s.data.postRevisions)
+	slices.Reverse(ids)
+	for _, revID := range ids {
+		rev := s.data.postRevisions[revID]
+		if rev.postID != id {
+			continue
+		}
+		revisions = append(revisions, PostRevision{
+			ID:        rev.id,
+			PostID:    rev.postID,
+			Title:     rev.title,
+			Content:   rev.content,
+			Editor:    s.data.users[rev.editorID].username,
+			CreatedAt: rev.createdAt,
+		})
+	}
+	return revisions, nil
+}
+
+func (s *memoryStore) HasRecentPost(userID int, title, content string, since time.Time) (bool, error) {
+	defer s.lock()()
+	for _, p := range s.data.posts {
+		if p.userID == userID && p.title == title && p.content == content && p.createdAt.After(since) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// vote stores or, for value 0, retracts a vote.
+func (d *memoryData) vote(key memoryVote, value int) {
+	if value == 0 {
+		delete(d.votes, key)
+		return
+	}
+	d.votes[key] = value
+}
+
+func (d *memoryData) myVotes(userID int, targetType string, ids []int) map[int]int {
+	votes := make(map[int]int)
+	for _, id := range ids {
+		if value, ok := d.votes[memoryVote{userID, targetType, id}]; ok {
+			votes[id] = value
+		}
+	}
+	return votes
+}
+
+// ---- comments ----
+
+func (s *memoryStore) Comments(postID int, page pageRequest) (Page[Comment], error) {
+	defer s.lock()()
+	d := s.data
+
+	var acceptedID int
+	if p, ok := d.posts[postID]; ok && p.acceptedID != nil {
+		acceptedID = *p.acceptedID
+	}
+
+	// Deleted comments are kept as placeholders while they have replies
+	hasReplies := make(map[int]bool)
+	replies := make(map[int][]Comment)
+	for _, id := range sortedIDs(d.comments) {
+		c := d.comments[id]
+		if c.postID == postID && c.parentID != nil {
+			hasReplies[*c.parentID] = true
+			replies[*c.parentID] = append(replies[*c.parentID], d.comment(c))
+		}
+	}
+
+	var roots []Comment
+	for _, c := range d.comments {
+		if c.postID != postID || c.parentID != nil {
+			continue
+		}
+		if c.deletedAt == nil || hasReplies[c.id] {
+			roots = append(roots, d.comment(c))
+		}
+	}
+
+	// One entry per commentOrders key, starting with acceptedFirst
+	result := paginate(page, roots, func(c Comment) []int64 {
+		accepted := int64(boolToInt(!c.IsDeleted && c.ID == acceptedID))
+		id := int64(c.ID)
+		switch page.order.name {
+		case "pinned-first":
+			return []int64{accepted, int64(boolToInt(c.IsPinned)), id}
+		case "top":
+			return []int64{accepted, int64(c.Score), id}
+		}
+		return []int64{accepted, id}
+	})
+	result.Items = nestReplies(result.Items, replies)
+	return result, nil
+}
+
+func (s *memoryStore) Comment(id int) (Comment, error) {
+	defer s.lock()()
+	c, ok := s.data.comments[id]
+	if !ok || c.deletedAt != nil || s.data.posts[c.postID].deletedAt != nil {
+		return Comment{}, errNotFound
+	}
+	return s.data.comment(c), nil
+}
+
+func (s *memoryStore) CommentOwner(id int) (userID, postID, topicID int, err error) {
+	defer s.lock()()
+	c, ok := s.data.comments[id]
+	if !ok || c.deletedAt != nil {
+		return 0, 0, 0, errNotFound
+	}
+	p, ok := s.data.posts[c.postID]
+	if !ok || p.deletedAt != nil {
+		return 0, 0, 0, errNotFound
+	}
+	return c.userID, c.postID, p.topicID, nil
+}
+
+func (s *memoryStore) CreateComment(postID int, parentID *int, depth, userID int, content string) (Comment, error) {
+	defer s.lock()()
+	c := memoryComment{
+		id:        s.data.nextID("comments"),
+		postID:    postID,
+		parentID:  parentID,
+		depth:     depth,
+		userID:    userID,
+		content:   content,
+		createdAt: time.Now().UTC(),
+	}
+	s.data.comments[c.id] = c
+	return s.data.comment(c), nil
+}
+
+func (s *memoryStore) UpdateComment(id, editorID int, content string) (Comment, error) {
+	defer s.lock()()
+	c, ok := s.data.comments[id]
+	if !ok {
+		return Comment{}, errNotFound
+	}
+
+	// Keep the version being replaced, credited to whoever wrote it
+	rev := memoryCommentRevision{
+		id:        s.data.nextID("comment_revisions"),
+		commentID: id,
+		content:   c.content,
+		editorID:  c.userID,
+		createdAt: c.createdAt,
+	}
+	if c.updatedAt != nil {
+		rev.editorID, rev.createdAt = c.updatedBy, *c.updatedAt
+	}
+	s.data.commentRevisions[rev.id] = rev
+
+	now := time.Now().UTC()
+	c.content, c.updatedAt, c.updatedBy = content, &now, editorID
+	s.data.comments[id] = c
+	return s.data.comment(c), nil
+}
+
+func (s *memoryStore) PinComment(id int, pinned bool) (Comment, error) {
+	defer s.lock()()
+	c, ok := s.data.comments[id]
+	if !ok {
+		return Comment{}, errNotFound
+	}
+	c.pinned = pinned
+	s.data.comments[id] = c
+	return s.data.comment(c), nil
+}
+
+func (s *memoryStore) TrashComment(id, userID int) error {
+	defer s.lock()()
+	c, ok := s.data.comments[id]
+	if !ok {
+		return errNotFound
+	}
+	now := time.Now().UTC()
+	c.deletedAt, c.deletedBy = &now, userID
+	s.data.comments[id] = c
+	return nil
+}
+
+func (s *memoryStore) VoteComment(userID, id, value int) error {
+	defer s.lock()()
+	s.data.vote(memoryVote{userID, "comment", id}, value)
+	return nil
+}
+
+func (s *memoryStore) CommentVotes(userID int, ids []int) (map[int]int, error) {
+	defer s.lock()()
+	return s.data.myVotes(userID, "comment", ids), nil
+}
+
+func (s *memoryStore) CommentRevisions(id int) ([]CommentRevision, error) {
+	defer s.lock()()
+	revisions := []CommentRevision{}
+	ids := sortedIDs(s.data.commentRevisions)
+	slices.Reverse(ids)
+	for _, revID := range ids {
+		rev := s.data.commentRevisions[revID]
+		if rev.commentID != id {
+			continue
+		}
+		revisions = append(revisions, CommentRevision{
+			ID:        rev.id,
+			CommentID: rev.commentID,
+			Content:   rev.content,
+			Editor:    s.data.users[rev.editorID].username,
+			CreatedAt: rev.createdAt,
+		})
+	}
+	return revisions, nil
+}
+
+func (s *memoryStore) HasRecentComment(userID, postID int, content string, since time.Time) (bool, error) {
+	defer s.lock()()
+	for _, c := range s.data.comments {
+		if c.userID == userID && c.postID == postID && c.content == content && c.createdAt.After(since) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ---- users ----
+
+func (s *memoryStore) Users() ([]User, error) {
+	defer s.lock()()
+	users := []User{}
+	for _, id := range sortedIDs(s.data.users) {
+		users = append(users, s.data.user(s.data.users[id]))
+	}
+	return users, nil
+}
+
+func (s *memoryStore) User(id int) (User, error) {
+	defer s.lock()()
+	u, ok := s.data.users[id]
+	if !ok {
+		return User{}, errNotFound
+	}
+	return s.data.user(u), nil
+}
+
+func (s *memoryStore) UserByName(username string) (User, string, error) {
+	defer s.lock()()
+	for _, u := range s.data.users {
+		if strings.EqualFold(u.username, username) {
+			return s.data.user(u), u.passwordHash, nil
+		}
+	}
+	return User{}, "", errNotFound
+}
+
+func (s *memoryStore) CreateUser(username, passwordHash, role string) (User, error) {
+	defer s.lock()()
+	for _, u := range s.data.users {
+		if strings.EqualFold(u.username, username) {
+			return User{}, errUsernameTaken
+		}
+	}
+	u := memoryUser{
+		id:           s.data.nextID("users"),
+		username:     username,
+		passwordHash: passwordHash,
+		role:         role,
+	}
+	s.data.users[u.id] = u
+	return s.data.user(u), nil
+}
+
+func (s *memoryStore) SetPassword(id int, passwordHash string) error {
+	defer s.lock()()
+	u, ok := s.data.users[id]
+	if !ok {
+		return errNotFound
+	}
+	u.passwordHash = passwordHash
+	s.data.users[id] = u
+	for sessionID, session := range s.data.sessions {
+		if session.userID == id {
+			delete(s.data.sessions, sessionID)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) SetRole(id int, role string) error {
+	defer s.lock()()
+	u, ok := s.data.users[id]
+	if !ok {
+		return errNotFound
+	}
+	u.role = role
+	s.data.users[id] = u
+	return nil
+}
+
+func (s *memoryStore) SetTopicModerator(topicID, userID int, moderator bool) error {
+	defer s.lock()()
+	key := memoryModerator{topicID: topicID, userID: userID}
+	if moderator {
+		s.data.moderators[key] = true
+	} else {
+		delete(s.data.moderators, key)
+	}
+	return nil
+}
+
+func (s *memoryStore) Authorize(userID int, perm permission, topicID int) (bool, error) {
+	defer s.lock()()
+	u, ok := s.data.users[userID]
+	if !ok {
+		return false, nil
+	}
+	if slices.Contains(memoryRolePermissions[u.role], perm) {
+		return true, nil
+	}
+	moderator := s.data.moderators[memoryModerator{topicID: topicID, userID: userID}]
+	return moderator && u.role != roleReadOnly &&
+		slices.Contains(memoryRolePermissions[topicModeratorRole], perm), nil
+}
+
+func (s *memoryStore) CreateSession(id string, userID int, expires time.Time) error {
+	defer s.lock()()
+	s.data.sessions[id] = memorySession{userID: userID, expires: expires}
+	return nil
+}
+
+func (s *memoryStore) SessionUser(id string) (User, error) {
+	defer s.lock()()
+	session, ok := s.data.sessions[id]
+	if !ok || !session.expires.After(time.Now()) {
+		return User{}, errNotFound
+	}
+	u := s.data.users[session.userID]
+	return User{ID: u.id, Username: u.username, Role: u.role, IsModerator: isGlobalModerator(u.role)}, nil
+}
+
+func (s *memoryStore) DeleteSession(id string) error {
+	defer s.lock()()
+	delete(s.data.sessions, id)
+	return nil
+}
+
+func (s *memoryStore) CreateSanction(issuerID, userID int, reason string, expiresAt *time.Time) (Sanction, error) {
+	defer s.lock()()
+	sanction := memorySanction{
+		id:        s.data.nextID("sanctions"),
+		userID:    userID,
+		issuedBy:  issuerID,
+		reason:    reason,
+		createdAt: time.Now().UTC(),
+	}
+	if expiresAt != nil {
+		expires := expiresAt.UTC()
+		sanction.expiresAt = &expires
+	}
+	s.data.sanctions[sanction.id] = sanction
+	return s.data.sanction(sanction), nil
+}
+
+func (s *memoryStore) Sanctions() ([]Sanction, error) {
+	defer s.lock()()
+	now := time.Now()
+	sanctions := []Sanction{}
+	for _, sanction := range s.data.sanctions {
+		if sanction.active(now) {
+			sanctions = append(sanctions, s.data.sanction(sanction))
+		}
+	}
+	slices.SortFunc(sanctions, func(a, b Sanction) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return sanctions, nil
+}
+
+func (s *memoryStore) ActiveSanction(userID int) (*Sanction, error) {
+	defer s.lock()()
+	now := time.Now()
+	var found *memorySanction
+	for _, sanction := range s.data.sanctions {
+		if sanction.userID != userID || !sanction.active(now) {
+			continue
+		}
+		// A ban outlasts any suspension, and a later expiry an earlier one
+		if found == nil || found.expiresAt != nil &&
+			(sanction.expiresAt == nil || sanction.expiresAt.After(*found.expiresAt)) {
+			found = &sanction
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	sanction := s.data.sanction(*found)
+	return &sanction, nil
+}
+
+func (s *memoryStore) LiftSanction(id, moderatorID int) (Sanction, error) {
+	defer s.lock()()
+	now := time.Now().UTC()
+	sanction, ok := s.data.sanctions[id]
+	if !ok || !sanction.active(now) {
+		return Sanction{}, errNotFound
+	}
+	lifted := s.data.sanction(sanction)
+
+	sanction.liftedAt, sanction.liftedBy = &now, moderatorID
+	s.data.sanctions[id] = sanction
+	return lifted, nil
+}
+
+// ---- audit log and notifications ----
+
+func (s *memoryStore) RecordAudit(actorID int, action, targetType string, targetID int, before, after any) error {
+	defer s.lock()()
+	entry := AuditEntry{
+		ID:         s.data.nextID("audit_log"),
+		Actor:      s.data.users[actorID].username,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if before != nil {
+		raw, err := json.Marshal(before)
+		if err != nil {
+			return err
+		}
+		entry.Before = raw
+	}
+	if after != nil {
+		raw, err := json.Marshal(after)
+		if err != nil {
+			return err
+		}
+		entry.After = raw
+	}
+	s.data.audit[entry.ID] = entry
+	return nil
+}
+
+func (s *memoryStore) AuditLog(filter AuditFilter, page pageRequest) (Page[AuditEntry], error) {
+	defer s.lock()()
+	var entries []AuditEntry
+	for _, e := range s.data.audit {
+		if filter.Actor != "" && !strings.EqualFold(e.Actor, filter.Actor) ||
+			filter.Action != "" && e.Action != filter.Action ||
+			filter.TargetType != "" && e.TargetType != filter.TargetType ||
+			!filter.From.IsZero() && e.CreatedAt.Before(filter.From) ||
+			!filter.To.IsZero() && e.CreatedAt.After(filter.To) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return paginate(page, entries, func(e AuditEntry) []int64 { return []int64{int64(e.ID)} }), nil
+}
+
+func (s *memoryStore) AddNotification(userID, actorID int, kind string, postID int, commentID *int) error {
+	defer s.lock()()
+	n := memoryNotification{
+		id:        s.data.nextID("notifications"),
+		userID:    userID,
+		actorID:   actorID,
+		kind:      kind,
+		postID:    postID,
+		commentID: commentID,
+		createdAt: time.Now().UTC(),
+	}
+	s.data.notifications[n.id] = n
+	return nil
+}
+
+func (s *memoryStore) Notifications(userID int, unread bool, page pageRequest) (Page[Notification], error) {
+	defer s.lock()()
+	d := s.data
+	var notifications []Notification
+	for _, n := range d.notifications {
+		if n.userID != userID || unread && n.readAt != nil {
+			continue
+		}
+		p, ok := d.notificationPost(n)
+		if !ok {
+			continue
+		}
+		notifications = append(notifications, Notification{
+			ID:        n.id,
+			Kind:      n.kind,
+			Actor:     d.users[n.actorID].username,
+			PostID:    n.postID,
+			PostTitle: p.title,
+			CommentID: n.commentID,
+			IsRead:    n.readAt != nil,
+			CreatedAt: n.createdAt,
+		})
+	}
+	return paginate(page, notifications, func(n Notification) []int64 { return []int64{int64(n.ID)} }), nil
+}
+
+func (s *memoryStore) UnreadCount(userID int) (int, error) {
+	defer s.lock()()
+	count := 0
+	for _, n := range s.data.notifications {
+		if _, ok := s.data.notificationPost(n); n.userID == userID && n.readAt == nil && ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) MarkRead(userID int, ids []int) error {
+	defer s.lock()()
+	now := time.Now().UTC()
+	for id, n := range s.data.notifications {
+		if n.userID != userID || n.readAt != nil || ids != nil && !slices.Contains(ids, id) {
+			continue
+		}
+		n.readAt = &now
+		s.data.notifications[id] = n
+	}
+	return nil
+}
+
+// ---- reports ----
+
+func (s *memoryStore) Reports(resolved bool, topicIDs []int) ([]Report, error) {
+	defer s.lock()()
+	reports := []Report{}
+	for _, r := range s.data.reports {
+		if (r.resolvedAt != nil) != resolved {
+			continue
+		}
+		if topicIDs != nil && !slices.Contains(topicIDs, r.topicID) {
+			continue
+		}
+		reports = append(reports, s.data.report(r))
+	}
+	slices.SortFunc(reports, func(a, b Report) int {
+		if resolved {
+			return cmp.Or(b.ResolvedAt.Compare(*a.ResolvedAt), cmp.Compare(b.ID, a.ID))
+		}
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return reports, nil
+}
+
+func (s *memoryStore) Report(id int) (Report, error) {
+	defer s.lock()()
+	r, ok := s.data.reports[id]
+	if !ok {
+		return Report{}, errNotFound
+	}
+	return s.data.report(r), nil
+}
+
+func (s *memoryStore) HasOpenReport(reporterID int, targetType string, targetID int) (bool, error) {
+	defer s.lock()()
+	for _, r := range s.data.reports {
+		if r.reporterID == reporterID && r.targetType == targetType && r.targetID == targetID && r.resolvedAt == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryStore) CreateReport(reporterID int, targetType string, targetID, topicID int, reason string) (Report, error) {
+	defer s.lock()()
+	r := memoryReport{
+		id:         s.data.nextID("reports"),
+		reporterID: reporterID,
+		targetType: targetType,
+		targetID:   targetID,
+		topicID:    topicID,
+		reason:     reason,
+		createdAt:  time.Now().UTC(),
+	}
+	s.data.reports[r.id] = r
+	return s.data.report(r), nil
+}
+
+func (s *memoryStore) ResolveReports(targetType string, targetID int, resolution string, resolverID int, note string) error {
+	defer s.lock()()
+	now := time.Now().UTC()
+	for id, r := range s.data.reports {
+		if r.targetType != targetType || r.targetID != targetID || r.resolvedAt != nil {
+			continue
+		}
+		r.resolution, r.resolvedBy, r.resolvedAt, r.note = &resolution, resolverID, &now, note
+		s.data.reports[id] = r
+	}
+	return nil
+}
+
+func (s *memoryStore) ContentAuthor(targetType string, id int) (int, error) {
+	defer s.lock()()
+	if targetType == "post" {
+		p, ok := s.data.posts[id]
+		if !ok {
+			return 0, errNotFound
+		}
+		return p.userID, nil
+	}
+	c, ok := s.data.comments[id]
+	if !ok {
+		return 0, errNotFound
+	}
+	return c.userID, nil
+}
+
+// ---- trash ----
+
+func (s *memoryStore) TrashedPosts() ([]TrashedPost, error) {
+	defer s.lock()()
+	posts := []TrashedPost{}
+	for _, p := range s.data.posts {
+		if p.deletedAt != nil {
+			posts = append(posts, TrashedPost{s.data.post(p), *p.deletedAt, s.data.users[p.deletedBy].username})
+		}
+	}
+	slices.SortFunc(posts, func(a, b TrashedPost) int {
+		return cmp.Or(b.DeletedAt.Compare(a.DeletedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return posts, nil
+}
+
+func (s *memoryStore) TrashedComments() ([]TrashedComment, error) {
+	defer s.lock()()
+	comments := []TrashedComment{}
+	for _, c := range s.data.comments {
+		if c.deletedAt != nil {
+			comments = append(comments, TrashedComment{s.data.comment(c), *c.deletedAt, s.data.users[c.deletedBy].username})
+		}
+	}
+	slices.SortFunc(comments, func(a, b TrashedComment) int {
+		return cmp.Or(b.DeletedAt.Compare(a.DeletedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return comments, nil
+}
+
+func (s *memoryStore) RestorePost(id int) error {
+	defer s.lock()()
+	p, ok := s.data.posts[id]
+	if !ok || p.deletedAt == nil {
+		return errNotFound
+	}
+	p.deletedAt, p.deletedBy = nil, 0
+	s.data.posts[id] = p
+	return nil
+}
+
+func (s *memoryStore) RestoreComment(id int) error {
+	defer s.lock()()
+	c, ok := s.data.comments[id]
+	if !ok || c.deletedAt == nil {
+		return errNotFound
+	}
+	c.deletedAt, c.deletedBy = nil, 0
+	s.data.comments[id] = c
+	return nil
+}
+
+func (s *memoryStore) PurgeTrash(cutoff time.Time) (posts, comments int64, err error) {
+	err = s.Tx(func(st Store) error {
+		d := st.(*memoryStore).data
+		for id, p := range d.posts {
+			if p.deletedAt == nil || !p.deletedAt.Before(cutoff) {
+				continue
+			}
+			for _, c := range d.comments {
+				if c.postID == id {
+					comments++
+				}
+			}
+			d.deletePost(id)
+			posts++
+		}
+
+		// Removing a reply can leave its parent without replies, so
+		// this is repeated until nothing more is deleted.
+		for {
+			hasReplies := make(map[int]bool)
+			for _, c := range d.comments {
+				if c.parentID != nil {
+					hasReplies[*c.parentID] = true
+				}
+			}
+			var leaves []int
+			for id, c := range d.comments {
+				if c.deletedAt != nil && c.deletedAt.Before(cutoff) && !hasReplies[id] {
+					leaves = append(leaves, id)
+				}
+			}
+			if len(leaves) == 0 {
+				return nil
+			}
+			for _, id := range leaves {
+				d.deleteComment(id)
+			}
+			comments += int64(len(leaves))
+		}
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return posts, comments, nil
+}
+
+// ---- search ----
+
+// Search has no index to use, so it goes through everything with
+// searchDocuments.
+func (s *memoryStore) Search(q SearchQuery) ([]SearchResult, error) {
+	defer s.lock()()
+	d := s.data
+	var docs []searchDocument
+
+	if (q.Type == "" || q.Type == "topic") && q.Author == "" {
+		for _, id := range sortedIDs(d.topics) {
+			t := d.topics[id]
+			if q.TopicID != 0 && t.ID != q.TopicID {
+				continue
+			}
+			docs = append(docs, searchDocument{
+				result: SearchResult{Type: "topic", ID: t.ID, TopicID: t.ID, Title: t.Title},
+				fields: []searchField{{t.Title, 10}, {t.Description, 1}},
+			})
+		}
+	}
+
+	if q.Type == "" || q.Type == "post" {
+		for _, id := range sortedIDs(d.posts) {
+			p := d.posts[id]
+			author := d.users[p.userID].username
+			if p.deletedAt != nil || q.Author != "" && !strings.EqualFold(author, q.Author) || q.TopicID != 0 && p.topicID != q.TopicID {
+				continue
+			}
+			docs = append(docs, searchDocument{
+				result: SearchResult{Type: "post", ID: p.id, TopicID: p.topicID, PostID: p.id, Title: p.title, Author: author},
+				fields: []searchField{{p.title, 10}, {p.content, 1}},
+			})
+		}
+	}
+
+	if q.Type == "" || q.Type == "comment" {
+		for _, id := range sortedIDs(d.comments) {
+			c := d.comments[id]
+			p := d.posts[c.postID]
+			author := d.users[c.userID].username
+			if c.deletedAt != nil || p.deletedAt != nil ||
+				q.Author != "" && !strings.EqualFold(author, q.Author) || q.TopicID != 0 && p.topicID != q.TopicID {
+				continue
+			}
+			docs = append(docs, searchDocument{
+				result: SearchResult{Type: "comment", ID: c.id, TopicID: p.topicID, PostID: c.postID, Title: p.title, Author: author},
+				fields: []searchField{{c.content, 1}},
+			})
+		}
+	}
+	return searchDocuments(q.Terms, docs, q.Limit), nil
+}
@@ -0,0 +1,754 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migration is one numbered schema change with its rollback.
+// If requires is set and returns false, the migration is left pending
+// and retried on the next run. If before is set, it runs in the same
+// transaction just ahead of up, for data changes that need checking or
+// reporting; what it writes to out is shown to the operator.
+type migration struct {
+	version  int
+	name     string
+	up       string
+	down     string
+	requires func(db *database) bool
+	before   func(tx *transaction, out io.Writer) error
+}
+
+// migrations lists every schema change in order. Versions must be
+// increasing and a migration must never be edited once released;
+// add a new one instead.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		// IF NOT EXISTS lets databases created before migrations existed
+		// adopt this version without losing data.
+		up: `
+			CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL UNIQUE,
+				is_moderator INTEGER NOT NULL DEFAULT 0
+			);
+			CREATE TABLE IF NOT EXISTS topics (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				title TEXT NOT NULL,
+				description TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE TABLE IF NOT EXISTS posts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				topic_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				title TEXT NOT NULL,
+				content TEXT NOT NULL,
+				is_pinned INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (topic_id) REFERENCES topics(id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);
+			CREATE TABLE IF NOT EXISTS comments (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				post_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				content TEXT NOT NULL,
+				is_pinned INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (post_id) REFERENCES posts(id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);
+		`,
+		down: `
+			DROP TABLE comments;
+			DROP TABLE posts;
+			DROP TABLE topics;
+			DROP TABLE users;
+		`,
+	},
+	{
+		version: 2,
+		name:    "passwords and sessions",
+		up: `
+			ALTER TABLE users ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+			CREATE TABLE sessions (
+				id TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				expires_at DATETIME NOT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);
+		`,
+		down: `
+			DROP TABLE sessions;
+			ALTER TABLE users DROP COLUMN password_hash;
+		`,
+	},
+	{
+		version: 3,
+		name:    "topic archiving",
+		up: `
+			ALTER TABLE topics ADD COLUMN is_archived INTEGER NOT NULL DEFAULT 0;
+		`,
+		down: `
+			ALTER TABLE topics DROP COLUMN is_archived;
+		`,
+	},
+	{
+		version: 4,
+		name:    "full-text search",
+		// External-content FTS5 tables over topics, posts and comments,
+		// kept in sync by triggers and filled from existing rows.
+		up: `
+			CREATE VIRTUAL TABLE topics_fts USING fts5(
+				title, description,
+				content='topics', content_rowid='id', tokenize='porter unicode61'
+			);
+			CREATE VIRTUAL TABLE posts_fts USING fts5(
+				title, content,
+				content='posts', content_rowid='id', tokenize='porter unicode61'
+			);
+			CREATE VIRTUAL TABLE comments_fts USING fts5(
+				content,
+				content='comments', content_rowid='id', tokenize='porter unicode61'
+			);
+
+			CREATE TRIGGER topics_fts_insert AFTER INSERT ON topics BEGIN
+				INSERT INTO topics_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+			END;
+			CREATE TRIGGER topics_fts_delete AFTER DELETE ON topics BEGIN
+				INSERT INTO topics_fts (topics_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+			END;
+			CREATE TRIGGER topics_fts_update AFTER UPDATE OF title, description ON topics BEGIN
+				INSERT INTO topics_fts (topics_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+				INSERT INTO topics_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+			END;
+
+			CREATE TRIGGER posts_fts_insert AFTER INSERT ON posts BEGIN
+				INSERT INTO posts_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+			END;
+			CREATE TRIGGER posts_fts_delete AFTER DELETE ON posts BEGIN
+				INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+			END;
+			CREATE TRIGGER posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+				INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+				INSERT INTO posts_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+			END;
+
+			CREATE TRIGGER comments_fts_insert AFTER INSERT ON comments BEGIN
+				INSERT INTO comments_fts (rowid, content) VALUES (new.id, new.content);
+			END;
+			CREATE TRIGGER comments_fts_delete AFTER DELETE ON comments BEGIN
+				INSERT INTO comments_fts (comments_fts, rowid, content) VALUES ('delete', old.id, old.content);
+			END;
+			CREATE TRIGGER comments_fts_update AFTER UPDATE OF content ON comments BEGIN
+				INSERT INTO comments_fts (comments_fts, rowid, content) VALUES ('delete', old.id, old.content);
+				INSERT INTO comments_fts (rowid, content) VALUES (new.id, new.content);
+			END;
+
+			INSERT INTO topics_fts (topics_fts) VALUES ('rebuild');
+			INSERT INTO posts_fts (posts_fts) VALUES ('rebuild');
+			INSERT INTO comments_fts (comments_fts) VALUES ('rebuild');
+		`,
+		down: `
+			DROP TRIGGER comments_fts_update;
+			DROP TRIGGER comments_fts_delete;
+			DROP TRIGGER comments_fts_insert;
+			DROP TRIGGER posts_fts_update;
+			DROP TRIGGER posts_fts_delete;
+			DROP TRIGGER posts_fts_insert;
+			DROP TRIGGER topics_fts_update;
+			DROP TRIGGER topics_fts_delete;
+			DROP TRIGGER topics_fts_insert;
+			DROP TABLE comments_fts;
+			DROP TABLE posts_fts;
+			DROP TABLE topics_fts;
+		`,
+		requires: fts5Available,
+	},
+	{
+		version: 5,
+		name:    "edit timestamps and revisions",
+		// updated_by has no REFERENCES clause so the rollback can drop it.
+		up: `
+			ALTER TABLE posts ADD COLUMN updated_at DATETIME;
+			ALTER TABLE posts ADD COLUMN updated_by INTEGER;
+			ALTER TABLE comments ADD COLUMN updated_at DATETIME;
+			ALTER TABLE comments ADD COLUMN updated_by INTEGER;
+			CREATE TABLE post_revisions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				post_id INTEGER NOT NULL,
+				title TEXT NOT NULL,
+				content TEXT NOT NULL,
+				editor_id INTEGER NOT NULL,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY (post_id) REFERENCES posts(id),
+				FOREIGN KEY (editor_id) REFERENCES users(id)
+			);
+			CREATE INDEX post_revisions_post_id ON post_revisions (post_id);
+			CREATE TABLE comment_revisions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				comment_id INTEGER NOT NULL,
+				content TEXT NOT NULL,
+				editor_id INTEGER NOT NULL,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY (comment_id) REFERENCES comments(id),
+				FOREIGN KEY (editor_id) REFERENCES users(id)
+			);
+			CREATE INDEX comment_revisions_comment_id ON comment_revisions (comment_id);
+		`,
+		down: `
+			DROP TABLE comment_revisions;
+			DROP TABLE post_revisions;
+			ALTER TABLE comments DROP COLUMN updated_by;
+			ALTER TABLE comments DROP COLUMN updated_at;
+			ALTER TABLE posts DROP COLUMN updated_by;
+			ALTER TABLE posts DROP COLUMN updated_at;
+		`,
+	},
+	{
+		version: 6,
+		name:    "soft delete",
+		up: `
+			ALTER TABLE posts ADD COLUMN deleted_at DATETIME;
+			ALTER TABLE posts ADD COLUMN deleted_by INTEGER;
+			ALTER TABLE comments ADD COLUMN deleted_at DATETIME;
+			ALTER TABLE comments ADD COLUMN deleted_by INTEGER;
+		`,
+		down: `
+			ALTER TABLE comments DROP COLUMN deleted_by;
+			ALTER TABLE comments DROP COLUMN deleted_at;
+			ALTER TABLE posts DROP COLUMN deleted_by;
+			ALTER TABLE posts DROP COLUMN deleted_at;
+		`,
+	},
+	{
+		version: 7,
+		name:    "threaded comments",
+		// parent_id has no REFERENCES clause so the rollback can drop it;
+		// the handlers check that the parent exists.
+		up: `
+			ALTER TABLE comments ADD COLUMN parent_id INTEGER;
+			ALTER TABLE comments ADD COLUMN depth INTEGER NOT NULL DEFAULT 0;
+			CREATE INDEX comments_parent_id ON comments (parent_id);
+		`,
+		down: `
+			DROP INDEX comments_parent_id;
+			ALTER TABLE comments DROP COLUMN depth;
+			ALTER TABLE comments DROP COLUMN parent_id;
+		`,
+	},
+	{
+		version: 8,
+		name:    "votes",
+		// One row per user and post or comment; value is +1 or -1.
+		up: `
+			CREATE TABLE votes (
+				user_id INTEGER NOT NULL,
+				target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment')),
+				target_id INTEGER NOT NULL,
+				value INTEGER NOT NULL CHECK (value IN (-1, 1)),
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (user_id, target_type, target_id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);
+			CREATE INDEX votes_target ON votes (target_type, target_id);
+		`,
+		down: `
+			DROP TABLE votes;
+		`,
+	},
+	{
+		version: 9,
+		name:    "accepted answers",
+		// No REFERENCES clause so the rollback can drop the column.
+		up: `
+			ALTER TABLE posts ADD COLUMN accepted_comment_id INTEGER;
+		`,
+		down: `
+			ALTER TABLE posts DROP COLUMN accepted_comment_id;
+		`,
+	},
+	{
+		version: 10,
+		name:    "roles and permissions",
+		// Existing moderators keep their powers as global moderators; use
+		// the role command to appoint an admin. topic_moderator is not a
+		// user role, it lists what moderators of a single topic can do.
+		up: `
+			ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'member'
+				CHECK (role IN ('admin', 'moderator', 'member', 'readonly'));
+			UPDATE users SET role = 'moderator' WHERE is_moderator = 1;
+			ALTER TABLE users DROP COLUMN is_moderator;
+			CREATE TABLE role_permissions (
+				role TEXT NOT NULL,
+				permission TEXT NOT NULL,
+				PRIMARY KEY (role, permission)
+			);
+			INSERT INTO role_permissions (role, permission) VALUES
+				('admin', 'content.create'),
+				('admin', 'content.vote'),
+				('admin', 'content.edit_own'),
+				('admin', 'content.moderate'),
+				('admin', 'trash.manage'),
+				('admin', 'topics.manage'),
+				('admin', 'roles.manage'),
+				('moderator', 'content.create'),
+				('moderator', 'content.vote'),
+				('moderator', 'content.edit_own'),
+				('moderator', 'content.moderate'),
+				('moderator', 'trash.manage'),
+				('moderator', 'topics.manage'),
+				('topic_moderator', 'content.moderate'),
+				('member', 'content.create'),
+				('member', 'content.vote'),
+				('member', 'content.edit_own');
+			CREATE TABLE topic_moderators (
+				topic_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (topic_id, user_id),
+				FOREIGN KEY (topic_id) REFERENCES topics(id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);
+			CREATE INDEX topic_moderators_user_id ON topic_moderators (user_id);
+		`,
+		down: `
+			DROP TABLE topic_moderators;
+			DROP TABLE role_permissions;
+			ALTER TABLE users ADD COLUMN is_moderator INTEGER NOT NULL DEFAULT 0;
+			UPDATE users SET is_moderator = 1 WHERE role IN ('admin', 'moderator');
+			ALTER TABLE users DROP COLUMN role;
+		`,
+	},
+	{
+		version: 11,
+		name:    "sanctions",
+		// expires_at is null for bans; lifted_at is set when a moderator
+		// ends a sanction early.
+		up: `
+			CREATE TABLE sanctions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				issued_by INTEGER NOT NULL,
+				reason TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME,
+				lifted_at DATETIME,
+				lifted_by INTEGER,
+				FOREIGN KEY (user_id) REFERENCES users(id),
+				FOREIGN KEY (issued_by) REFERENCES users(id),
+				FOREIGN KEY (lifted_by) REFERENCES users(id)
+			);
+			CREATE INDEX sanctions_user_id ON sanctions (user_id);
+			INSERT INTO role_permissions (role, permission) VALUES
+				('admin', 'users.sanction'),
+				('moderator', 'users.sanction');
+		`,
+		down: `
+			DELETE FROM role_permissions WHERE permission = 'users.sanction';
+			DROP TABLE sanctions;
+		`,
+	},
+	{
+		version: 12,
+		name:    "reports",
+		// target_id has no REFERENCES clause because it points at either
+		// table; topic_id is copied from the content so queues can be
+		// limited to the topics a moderator looks after.
+		up: `
+			CREATE TABLE reports (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				reporter_id INTEGER NOT NULL,
+				target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment')),
+				target_id INTEGER NOT NULL,
+				topic_id INTEGER NOT NULL,
+				reason TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				resolution TEXT CHECK (resolution IN ('dismissed', 'deleted', 'banned')),
+				resolved_by INTEGER,
+				resolved_at DATETIME,
+				resolution_note TEXT NOT NULL DEFAULT '',
+				FOREIGN KEY (reporter_id) REFERENCES users(id),
+				FOREIGN KEY (topic_id) REFERENCES topics(id),
+				FOREIGN KEY (resolved_by) REFERENCES users(id)
+			);
+			CREATE INDEX reports_target ON reports (target_type, target_id);
+			CREATE INDEX reports_topic_id ON reports (topic_id);
+			INSERT INTO role_permissions (role, permission) VALUES
+				('admin', 'reports.review'),
+				('moderator', 'reports.review'),
+				('topic_moderator', 'reports.review');
+		`,
+		down: `
+			DELETE FROM role_permissions WHERE permission = 'reports.review';
+			DROP TABLE reports;
+		`,
+	},
+	{
+		version: 13,
+		name:    "audit log",
+		// The triggers make the log append-only for everything that goes
+		// through SQLite, not just for the API. target_id has no REFERENCES
+		// clause because entries outlive what they describe.
+		up: `
+			CREATE TABLE audit_log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				actor_id INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				target_type TEXT NOT NULL,
+				target_id INTEGER NOT NULL,
+				before TEXT,
+				after TEXT,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY (actor_id) REFERENCES users(id)
+			);
+			CREATE INDEX audit_log_actor_id ON audit_log (actor_id);
+			CREATE INDEX audit_log_action ON audit_log (action);
+			CREATE INDEX audit_log_created_at ON audit_log (created_at);
+			CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+			BEGIN
+				SELECT RAISE(ABORT, 'audit_log is append-only');
+			END;
+			CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+			BEGIN
+				SELECT RAISE(ABORT, 'audit_log is append-only');
+			END;
+			INSERT INTO role_permissions (role, permission) VALUES
+				('admin', 'audit.view'),
+				('moderator', 'audit.view');
+		`,
+		down: `
+			DELETE FROM role_permissions WHERE permission = 'audit.view';
+			DROP TRIGGER audit_log_no_delete;
+			DROP TRIGGER audit_log_no_update;
+			DROP TABLE audit_log;
+		`,
+	},
+	{
+		version: 14,
+		name:    "notifications",
+		// post_id is set for comment notifications too, so that purging
+		// a post can remove everything about it in one statement.
+		up: `
+			CREATE TABLE notifications (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				actor_id INTEGER NOT NULL,
+				kind TEXT NOT NULL CHECK (kind IN ('comment', 'reply', 'mention', 'edit', 'pin')),
+				post_id INTEGER NOT NULL,
+				comment_id INTEGER,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				read_at DATETIME,
+				FOREIGN KEY (user_id) REFERENCES users(id),
+				FOREIGN KEY (actor_id) REFERENCES users(id),
+				FOREIGN KEY (post_id) REFERENCES posts(id),
+				FOREIGN KEY (comment_id) REFERENCES comments(id)
+			);
+			CREATE INDEX notifications_user_id ON notifications (user_id, read_at);
+			CREATE INDEX notifications_post_id ON notifications (post_id);
+			CREATE INDEX notifications_comment_id ON notifications (comment_id);
+		`,
+		down: `
+			DROP TABLE notifications;
+		`,
+	},
+	{
+		version: 15,
+		name:    "case-insensitive usernames",
+		// renameCaseDuplicates makes the usernames distinct first
+		before: renameCaseDuplicates,
+		up: `
+			CREATE UNIQUE INDEX users_username_lower ON users (lower(username));
+		`,
+		down: `
+			DROP INDEX users_username_lower;
+		`,
+	},
+}
+
+// renameCaseDuplicates gives every user whose username only differs in
+// case from an older one a new name, with _ and their id appended, and
+// prints who was renamed so they can be told. They keep their posts and
+// sessions, and log in with the new name. If a new name is taken
+// already, nothing is renamed and the migration fails.
+func renameCaseDuplicates(tx *transaction, out io.Writer) error {
+	rows, err := tx.Query("SELECT id, username FROM users ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type rename struct {
+		id       int
+		old, new string
+	}
+	var renames []rename
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var id int
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			return err
+		}
+		if key := strings.ToLower(username); taken[key] {
+			renames = append(renames, rename{id, username, username + "_" + strconv.Itoa(id)})
+		} else {
+			taken[key] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, r := range renames {
+		key := strings.ToLower(r.new)
+		if taken[key] {
+			return fmt.Errorf("user %d (%s) would be renamed to %s, which is taken; rename one of them and migrate again", r.id, r.old, r.new)
+		}
+		taken[key] = true
+	}
+	for _, r := range renames {
+		if _, err := tx.Exec("UPDATE users SET username = ? WHERE id = ?", r.new, r.id); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Renamed user %d from %s to %s, as the name was taken in another case\n", r.id, r.old, r.new)
+	}
+	return nil
+}
+
+// migrations returns the schema changes for the database's dialect.
+func (db *database) migrations() []migration {
+	if db.dialect == postgresDialect {
+		return postgresMigrations
+	}
+	return migrations
+}
+
+// ensureMigrationsTable creates the table that records applied migrations.
+func ensureMigrationsTable(db *database) error {
+	timeType := "DATETIME"
+	if db.dialect == postgresDialect {
+		timeType = "TIMESTAMPTZ"
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at ` + timeType + ` NOT NULL
+		);
+	`)
+	return err
+}
+
+// appliedMigrations returns the applied versions with their timestamps.
+func appliedMigrations(db *database) (map[int]time.Time, error) {
+	order, at, err := appliedHistory(db)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time, len(order))
+	for _, version := range order {
+		applied[version] = at[version]
+	}
+	return applied, nil
+}
+
+// appliedHistory returns the applied versions in the order they were
+// applied, with their timestamps. That is not always version order: a
+// migration this build cannot run stays pending and is applied later.
+func appliedHistory(db *database) ([]int, map[int]time.Time, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations ORDER BY applied_at, version")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var order []int
+	at := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, nil, err
+		}
+		order = append(order, version)
+		at[version] = appliedAt
+	}
+	return order, at, rows.Err()
+}
+
+// checkRequirements fails if a migration that needs something this build
+// lacks has already been applied. Its triggers would make every write to
+// the tables it covers fail, so the database must not be used.
+func checkRequirements(db *database, applied map[int]time.Time) error {
+	for _, m := range db.migrations() {
+		if _, ok := applied[m.version]; ok && m.requires != nil && !m.requires(db) {
+			return fmt.Errorf("migration %d (%s) was applied by a build that supports it, but this one does not; build with make, which passes -tags sqlite_fts5", m.version, m.name)
+		}
+	}
+	return nil
+}
+
+// latestMigration returns the highest migration version known for db.
+func latestMigration(db *database) int {
+	list := db.migrations()
+	return list[len(list)-1].version
+}
+
+// runMigration executes one migration step and updates schema_migrations
+// in the same transaction.
+func runMigration(db *database, m migration, up bool, out io.Writer) error {
+	return withTx(db, func(tx *transaction) error {
+		if up {
+			if m.before != nil {
+				if err := m.before(tx, out); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.Exec(m.up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(
+				"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				m.version, m.name, time.Now().UTC(),
+			)
+			return err
+		}
+
+		if _, err := tx.Exec(m.down); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.version)
+		return err
+	})
+}
+
+// migrateUp applies every pending migration up to and including target.
+func migrateUp(db *database, target int, out io.Writer) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	if err := checkRequirements(db, applied); err != nil {
+		return err
+	}
+
+	for _, m := range db.migrations() {
+		if m.version > target {
+			break
+		}
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if m.requires != nil && !m.requires(db) {
+			fmt.Fprintf(out, "Skipped migration %d: %s (not supported by this build)\n", m.version, m.name)
+			continue
+		}
+		if err := runMigration(db, m, true, out); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		fmt.Fprintf(out, "Applied migration %d: %s\n", m.version, m.name)
+	}
+	return nil
+}
+
+// migrateDown rolls back the most recently applied migrations, one step
+// at a time, in the reverse of the order they were applied.
+func migrateDown(db *database, steps int, out io.Writer) error {
+	order, at, err := appliedHistory(db)
+	if err != nil {
+		return err
+	}
+	if err := checkRequirements(db, at); err != nil {
+		return err
+	}
+
+	byVersion := make(map[int]migration)
+	for _, m := range db.migrations() {
+		byVersion[m.version] = m
+	}
+	for i := len(order) - 1; i >= 0 && steps > 0; i-- {
+		m, ok := byVersion[order[i]]
+		if !ok {
+			return fmt.Errorf("rollback %d: unknown migration, applied by a newer version", order[i])
+		}
+		if err := runMigration(db, m, false, out); err != nil {
+			return fmt.Errorf("rollback %d (%s): %w", m.version, m.name, err)
+		}
+		fmt.Fprintf(out, "Rolled back migration %d: %s\n", m.version, m.name)
+		steps--
+	}
+	return nil
+}
+
+// migrationStatus prints every known migration and whether it is applied.
+func migrationStatus(db *database, out io.Writer) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range db.migrations() {
+		status := "pending"
+		if at, ok := applied[m.version]; ok {
+			status = "applied " + at.Format(time.RFC3339)
+		}
+		fmt.Fprintf(out, "%4d  %-30s %s\n", m.version, m.name, status)
+	}
+	return nil
+}
+
+// databaseDSN opens the database file at path with foreign keys enforced
+// on every connection. Transactions take the write lock when they begin,
+// and writers wait for each other instead of failing with "database is
+// locked".
+func databaseDSN(path string) string {
+	return "file:" + path + "?_foreign_keys=on&_busy_timeout=5000&_txlock=immediate"
+}
+
+// openDB opens the SQLite database at path without touching its schema.
+func openDB(path string) (*database, error) {
+	db, err := sql.Open("sqlite3", databaseDSN(path))
+	if err != nil {
+		return nil, err
+	}
+	return &database{DB: db, dialect: sqliteDialect}, nil
+}
+
+// openDatabase opens the SQL database the configuration names, without
+// touching its schema: Postgres with store postgres, otherwise the
+// SQLite file.
+func openDatabase(cfg Config) (*database, error) {
+	if cfg.Store == storePostgres {
+		return openPostgres(cfg.PostgresURL)
+	}
+	return openDB(cfg.DatabasePath)
+}
+
+// initDatabase opens the SQL database the configuration names and
+// applies any pending migrations.
+func initDatabase(cfg Config) (*database, error) {
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateUp(db, latestMigration(db), os.Stdout); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// withoutFTS5 makes the full-text search migration look unsupported, as
+// it is in a build without the sqlite_fts5 tag, until the test ends.
+func withoutFTS5(t *testing.T) {
+	t.Helper()
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	migrations = append([]migration(nil), saved...)
+	for i := range migrations {
+		if migrations[i].requires != nil {
+			migrations[i].requires = func(*database) bool { return false }
+		}
+	}
+}
+
+func TestMigrationsAppliedLater(t *testing.T) {
+	db := emptyTestDB(t)
+	if !fts5Available(db) {
+		t.Skip("SQLite is built without FTS5 (use -tags sqlite_fts5)")
+	}
+
+	t.Run("without FTS5", func(t *testing.T) {
+		withoutFTS5(t)
+		var out bytes.Buffer
+		if err := migrateUp(db, latestMigration(db), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "Skipped migration 4") {
+			t.Errorf("output = %q, want migration 4 skipped", out.String())
+		}
+	})
+
+	// A build with FTS5 applies it after the later ones
+	var out bytes.Buffer
+	if err := migrateUp(db, latestMigration(db), &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "Applied migration 4: full-text search\n" {
+		t.Errorf("output = %q, want only migration 4 applied", got)
+	}
+
+	t.Run("refused without FTS5", func(t *testing.T) {
+		withoutFTS5(t)
+		err := migrateUp(db, latestMigration(db), io.Discard)
+		if err == nil || !strings.Contains(err.Error(), "migration 4") {
+			t.Errorf("migrateUp = %v, want migration 4 refused", err)
+		}
+	})
+
+	// Rolling back undoes the last one applied first
+	out.Reset()
+	if err := migrateDown(db, 2, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "Rolled back migration 4: full-text search\nRolled back migration 15: case-insensitive usernames\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestCaseInsensitiveUsernames(t *testing.T) {
+	db := emptyTestDB(t)
+	if err := migrateUp(db, 14, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "Alice", "bob", "ALICE"} {
+		if _, err := db.Exec("INSERT INTO users (username) VALUES (?)", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := migrateUp(db, latestMigration(db), &out); err != nil {
+		t.Fatal(err)
+	}
+	// The operator is told who was renamed
+	for _, want := range []string{"Renamed user 2 from Alice to Alice_2", "Renamed user 4 from ALICE to ALICE_4"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output = %q, want %q", out.String(), want)
+		}
+	}
+
+	// The oldest keeps the name and the others get their id appended
+	rows, err := db.Query("SELECT username FROM users ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if want := []string{"alice", "Alice_2", "bob", "ALICE_4"}; !slices.Equal(names, want) {
+		t.Errorf("usernames = %v, want %v", names, want)
+	}
+
+	if _, err := newSQLStore(db).CreateUser("BOB", "", roleMember); err != errUsernameTaken {
+		t.Errorf("CreateUser(BOB) = %v, want errUsernameTaken", err)
+	}
+}
+
+func TestCaseInsensitiveUsernamesCollision(t *testing.T) {
+	db := emptyTestDB(t)
+	if err := migrateUp(db, 14, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	// Alice would become Alice_2, which another user has already
+	for _, name := range []string{"alice", "Alice", "alice_2"} {
+		if _, err := db.Exec("INSERT INTO users (username) VALUES (?)", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := migrateUp(db, latestMigration(db), io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "Alice_2, which is taken") {
+		t.Fatalf("migrateUp = %v, want the collision reported", err)
+	}
+
+	// Nothing was renamed and the migration is still pending
+	var name string
+	if err := db.QueryRow("SELECT username FROM users WHERE id = 2").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Alice" {
+		t.Errorf("user 2 = %q, want Alice unchanged", name)
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := applied[15]; ok {
+		t.Error("migration 15 applied despite the collision")
+	}
+}
+
+// schemaOf describes a SQLite schema: every table with its columns,
+// every index and trigger with its SQL, and the permissions granted.
+// Columns are compared rather than CREATE TABLE statements, which
+// SQLite rewrites when columns are added or dropped.
+func schemaOf(t *testing.T, db *database) []string {
+	t.Helper()
+	var schema []string
+	query := func(format, query string, args ...any) {
+		t.Helper()
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var a, b string
+			if err := rows.Scan(&a, &b); err != nil {
+				t.Fatal(err)
+			}
+			schema = append(schema, fmt.Sprintf(format, a, b))
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tables []string
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	for _, table := range tables {
+		query("column "+table+".%s %s", `
+			SELECT name, type || ' notnull=' || "notnull" || ' default=' || coalesce(dflt_value, 'none') || ' pk=' || pk
+			FROM pragma_table_info(?) ORDER BY name`, table)
+	}
+	query("%s %s", "SELECT type || ' ' || name, coalesce(sql, '') FROM sqlite_master WHERE type IN ('index', 'trigger') ORDER BY type, name")
+	if slices.Contains(tables, "role_permissions") {
+		query("grant %s %s", "SELECT role, permission FROM role_permissions ORDER BY role, permission")
+	}
+	return schema
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	db := emptyTestDB(t)
+	if err := ensureMigrationsTable(db); err != nil {
+		t.Fatal(err)
+	}
+	empty := schemaOf(t, db)
+
+	// Every step down undoes its step up, and the step up can be redone
+	for _, m := range migrations {
+		before := schemaOf(t, db)
+		if err := migrateUp(db, m.version, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		applied, err := appliedMigrations(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := applied[m.version]; !ok {
+			continue // needs FTS5
+		}
+		after := schemaOf(t, db)
+
+		if err := migrateDown(db, 1, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		if got := schemaOf(t, db); !slices.Equal(got, before) {
+			t.Errorf("rolling back migration %d (%s) leaves\n%s\nwant\n%s", m.version, m.name,
+				strings.Join(got, "\n"), strings.Join(before, "\n"))
+		}
+		if err := migrateUp(db, m.version, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		if got := schemaOf(t, db); !slices.Equal(got, after) {
+			t.Errorf("migration %d (%s) applied again gives\n%s\nwant\n%s", m.version, m.name,
+				strings.Join(got, "\n"), strings.Join(after, "\n"))
+		}
+	}
+
+	var status bytes.Buffer
+	if err := migrationStatus(db, &status); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(status.String()), "\n"); len(lines) != len(migrations) {
+		t.Errorf("status has %d lines, want one per migration:\n%s", len(lines), status.String())
+	}
+
+	// All the way down leaves nothing but schema_migrations
+	if err := migrateDown(db, len(migrations), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if got := schemaOf(t, db); !slices.Equal(got, empty) {
+		t.Errorf("after rolling back everything, schema is\n%s", strings.Join(got, "\n"))
+	}
+	status.Reset()
+	if err := migrationStatus(db, &status); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(status.String(), "applied") {
+		t.Errorf("status after rolling back everything:\n%s", status.String())
+	}
+	if err := migrateUp(db, latestMigration(db), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunMigrateCommand(t *testing.T) {
+	db := emptyTestDB(t)
+	for _, args := range [][]string{
+		{},
+		{"sideways"},
+		{"up", "0"},
+		{"up", "-1"},
+		{"up", "latest"},
+		{"down", "0"},
+		{"down", "-2"},
+	} {
+		if err := runMigrateCommand(db, args); err == nil {
+			t.Errorf("migrate %v succeeded, want an error", args)
+		}
+	}
+	// Nothing was applied on the way
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("applied = %v, want nothing", applied)
+	}
+}
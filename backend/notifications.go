@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification tells a user that someone did something that concerns
+// them. CommentID is set when it is about a comment rather than the post.
+type Notification struct {
+	ID        int       `json:"id"`
+	Kind      string    `json:"kind"`
+	Actor     string    `json:"actor"`
+	PostID    int       `json:"postId"`
+	PostTitle string    `json:"postTitle"`
+	CommentID *int      `json:"commentId"`
+	IsRead    bool      `json:"isRead"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// MarkReadRequest represents the JSON body for marking notifications
+// read. Leaving out ids marks all of them.
+type MarkReadRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// Notification kinds.
+const (
+	// notifyComment: someone commented on your post.
+	notifyComment = "comment"
+	// notifyReply: someone replied to your comment.
+	notifyReply = "reply"
+	// notifyMention: someone mentioned you as @username.
+	notifyMention = "mention"
+	// notifyEdit: a moderator edited your post or comment.
+	notifyEdit = "edit"
+	// notifyPin: a moderator pinned your post or comment.
+	notifyPin = "pin"
+)
+
+// mentionPattern finds @username in content. The @ must not follow a
+// word character, so email addresses are not mentions, and the name
+// must not end in punctuation, so "@bob." mentions bob.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@(\w+(?:[.-]\w+)*)`)
+
+// mentions returns the distinct usernames mentioned in content. Like
+// usernames themselves, they are told apart regardless of case.
+func mentions(content string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		if key := strings.ToLower(m[1]); !seen[key] {
+			seen[key] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// notify adds a notification for userID, unless they are the actor.
+func notify(st NotificationStore, userID, actorID int, kind string, postID int, commentID *int) error {
+	if userID == actorID {
+		return nil
+	}
+	return st.AddNotification(userID, actorID, kind, postID, commentID)
+}
+
+// notifyMentions notifies the users mentioned in content who were not
+// already mentioned in previous (empty for new content) and are not in
+// skip, which holds users who were notified about this change already.
+func notifyMentions(st Store, actorID int, content, previous string, postID int, commentID *int, skip ...int) error {
+	already := make(map[string]bool)
+	for _, name := range mentions(previous) {
+		already[strings.ToLower(name)] = true
+	}
+	skipped := make(map[int]bool)
+	for _, id := range skip {
+		skipped[id] = true
+	}
+
+	for _, name := range mentions(content) {
+		if already[strings.ToLower(name)] {
+			continue
+		}
+		user, _, err := st.UserByName(name)
+		if err == errNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if skipped[user.ID] {
+			continue
+		}
+		if err := notify(st, user.ID, actorID, notifyMention, postID, commentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notificationsHandler handles GET /notifications
+// It lists the logged-in user's notifications newest first, one page at
+// a time; ?unread=true leaves out those already read.
+func (s *server) notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	unread := false
+	if param := r.URL.Query().Get("unread"); param != "" {
+		var err error
+		if unread, err = strconv.ParseBool(param); err != nil {
+			writeError(w, "Invalid unread parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := parsePageRequest(r, notificationOrders)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Notifications about trashed posts and comments are hidden until
+	// they are restored
+	notifications, err := s.store.Notifications(user.ID, unread, page)
+	if err != nil {
+		writeError(w, "Failed to query notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(notifications); err != nil {
+		writeError(w, "Failed to encode notifications", http.StatusInternalServerError)
+	}
+}
+
+// unreadCountHandler handles GET /notifications/unread-count
+// It returns { "count": 3 } for the logged-in user.
+func (s *server) unreadCountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := s.store.UnreadCount(user.ID)
+	if err != nil {
+		writeError(w, "Failed to count notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{"count": count}); err != nil {
+		writeError(w, "Failed to encode count", http.StatusInternalServerError)
+	}
+}
+
+// markReadHandler handles POST /notifications/read
+// It takes { "ids": [1, 2] } and marks those notifications read, or all
+// of the user's notifications when ids is left out.
+func (s *server) markReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.MarkRead(user.ID, req.IDs); err != nil {
+		writeError(w, "Failed to mark notifications read", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
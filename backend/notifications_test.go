@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// unreadCount asks the server how many unread notifications a user has.
+func (ts *testServer) unreadCount(username string) int {
+	ts.t.Helper()
+	w := ts.do(http.MethodGet, "/notifications/unread-count", username, nil)
+	wantStatus(ts.t, w, http.StatusOK)
+	return decode[map[string]int](ts.t, w)["count"]
+}
+
+func TestNotifications(t *testing.T) {
+	ts := newTestServer(t)
+	// Comments on bob's post notify him, and a reply to carol's comment her
+	w := ts.do(http.MethodPost, "/posts/1/comments", "carol", CreateCommentRequest{Content: "Welcome!"})
+	wantStatus(t, w, http.StatusCreated)
+	w = ts.do(http.MethodPost, "/posts/1/comments", "tom", CreateCommentRequest{Content: "Agreed", ParentID: ts.comment.ID})
+	wantStatus(t, w, http.StatusCreated)
+
+	w = ts.do(http.MethodGet, "/notifications", "bob", nil)
+	wantStatus(t, w, http.StatusOK)
+	page := decode[Page[Notification]](t, w)
+	if len(page.Items) != 2 {
+		t.Fatalf("notifications = %+v, want two for bob", page.Items)
+	}
+	if n := page.Items[0]; n.Actor != "tom" || n.Kind != notifyComment || n.PostTitle != ts.post.Title || n.IsRead {
+		t.Errorf("newest notification = %+v, want tom's unread comment on %q", n, ts.post.Title)
+	}
+	if got := ts.unreadCount("bob"); got != 2 {
+		t.Errorf("bob has %d unread, want 2", got)
+	}
+	if got := ts.unreadCount("carol"); got != 1 {
+		t.Errorf("carol has %d unread, want 1", got)
+	}
+
+	// Nobody can mark someone else's notifications read
+	ids := []int{page.Items[0].ID}
+	wantStatus(t, ts.do(http.MethodPost, "/notifications/read", "carol", MarkReadRequest{IDs: ids}), http.StatusNoContent)
+	if got := ts.unreadCount("bob"); got != 2 {
+		t.Errorf("bob has %d unread after carol marked his, want 2", got)
+	}
+
+	wantStatus(t, ts.do(http.MethodPost, "/notifications/read", "bob", MarkReadRequest{IDs: ids}), http.StatusNoContent)
+	if got := ts.unreadCount("bob"); got != 1 {
+		t.Errorf("bob has %d unread, want 1", got)
+	}
+	w = ts.do(http.MethodGet, "/notifications?unread=true", "bob", nil)
+	wantStatus(t, w, http.StatusOK)
+	if unread := decode[Page[Notification]](t, w); len(unread.Items) != 1 || unread.Items[0].ID == ids[0] {
+		t.Errorf("unread = %+v, want only the other one", unread.Items)
+	}
+
+	// Without ids every notification is marked read
+	wantStatus(t, ts.do(http.MethodPost, "/notifications/read", "bob", MarkReadRequest{}), http.StatusNoContent)
+	if got := ts.unreadCount("bob"); got != 0 {
+		t.Errorf("bob has %d unread, want 0", got)
+	}
+	if got := ts.unreadCount("carol"); got != 1 {
+		t.Errorf("carol has %d unread, want 1", got)
+	}
+
+	runRequests(t, []request{
+		{"list anonymous", http.MethodGet, "/notifications", "", nil, http.StatusUnauthorized},
+		{"list invalid cursor", http.MethodGet, "/notifications?cursor=x", "bob", nil, http.StatusBadRequest},
+		{"list unread as a number", http.MethodGet, "/notifications?unread=1", "bob", nil, http.StatusOK},
+		{"list invalid unread", http.MethodGet, "/notifications?unread=maybe", "bob", nil, http.StatusBadRequest},
+		{"list wrong method", http.MethodPost, "/notifications", "bob", nil, http.StatusMethodNotAllowed},
+		{"count anonymous", http.MethodGet, "/notifications/unread-count", "", nil, http.StatusUnauthorized},
+		{"count wrong method", http.MethodPost, "/notifications/unread-count", "bob", nil, http.StatusMethodNotAllowed},
+		{"read anonymous", http.MethodPost, "/notifications/read", "", MarkReadRequest{}, http.StatusUnauthorized},
+		{"read invalid JSON", http.MethodPost, "/notifications/read", "bob", "{", http.StatusBadRequest},
+		{"read wrong method", http.MethodGet, "/notifications/read", "bob", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestMentionInAnotherCase(t *testing.T) {
+	ts := newTestServer(t)
+	// @Carol and @CAROL are both carol, who hears about it once
+	w := ts.do(http.MethodPost, "/posts/1/comments", "tom", CreateCommentRequest{Content: "Ask @Carol or @CAROL"})
+	wantStatus(t, w, http.StatusCreated)
+	if n := ts.notificationCount("carol", notifyMention); n != 1 {
+		t.Errorf("carol has %d mentions, want 1", n)
+	}
+}
+
+func TestNotificationsAboutTrashedContent(t *testing.T) {
+	ts := newTestServer(t)
+	// carol hears about tom's reply until a moderator trashes it
+	w := ts.do(http.MethodPost, "/posts/1/comments", "tom", CreateCommentRequest{Content: "Agreed", ParentID: ts.comment.ID})
+	wantStatus(t, w, http.StatusCreated)
+	reply := decode[Comment](t, w)
+	if got := ts.unreadCount("carol"); got != 1 {
+		t.Fatalf("carol has %d unread, want 1", got)
+	}
+
+	if err := ts.store.TrashComment(reply.ID, ts.users["mona"].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := ts.unreadCount("carol"); got != 0 {
+		t.Errorf("carol has %d unread after the reply was trashed, want 0", got)
+	}
+	if n := ts.notificationCount("carol", ""); n != 0 {
+		t.Errorf("carol has %d notifications after the reply was trashed, want 0", n)
+	}
+
+	// Restoring the reply brings the notification back
+	if err := ts.store.RestoreComment(reply.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := ts.unreadCount("carol"); got != 1 {
+		t.Errorf("carol has %d unread after the reply was restored, want 1", got)
+	}
+}
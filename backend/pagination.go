@@ -0,0 +1,223 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultPageLimit is used when a listing request has no limit parameter.
+const defaultPageLimit = 20
+
+// maxPageLimit caps the limit parameter so one request cannot load a whole table.
+const maxPageLimit = 100
+
+// Page is the response envelope for paginated listings.
+// NextCursor is null on the last page.
+type Page[T any] struct {
+	Items      []T     `json:"items"`
+	NextCursor *string `json:"nextCursor"`
+}
+
+// sortKey is one ORDER BY term. Every key must evaluate to an integer
+// so its value can be carried in a cursor.
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+// listOrder is a named sort option. Its last key must be unique
+// (the row id) so that keyset pagination never skips or repeats rows.
+type listOrder struct {
+	name string
+	keys []sortKey
+}
+
+// orderBy returns the ORDER BY clause body.
+func (o listOrder) orderBy() string {
+	terms := make([]string, len(o.keys))
+	for i, k := range o.keys {
+		terms[i] = k.expr
+		if k.desc {
+			terms[i] += " DESC"
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+// columns returns the sort key expressions as extra SELECT columns,
+// so the last row's key values can be turned into the next cursor.
+func (o listOrder) columns() string {
+	var b strings.Builder
+	for _, k := range o.keys {
+		b.WriteString(", ")
+		b.WriteString(k.expr)
+	}
+	return b.String()
+}
+
+// after returns a WHERE condition matching the rows that sort after
+// the row with the given key values.
+func (o listOrder) after(values []int64) (string, []any) {
+	var clauses []string
+	var args []any
+	for i, k := range o.keys {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, o.keys[j].expr+" = ?")
+			args = append(args, values[j])
+		}
+		op := " > ?"
+		if k.desc {
+			op = " < ?"
+		}
+		parts = append(parts, k.expr+op)
+		args = append(args, values[i])
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
+
+// pageRequest holds the parsed limit, sort and cursor parameters.
+type pageRequest struct {
+	limit int
+	order listOrder
+	after []int64
+}
+
+// cursorData is the decoded form of an opaque cursor.
+type cursorData struct {
+	Sort   string  `json:"s"`
+	Values []int64 `json:"v"`
+}
+
+// encodeCursor builds the opaque cursor for the row with the given key values.
+func encodeCursor(sort string, values []int64) string {
+	raw, _ := json.Marshal(cursorData{Sort: sort, Values: values})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// parsePageRequest reads limit, sort and cursor from the query string.
+func parsePageRequest(r *http.Request, orders []listOrder) (pageRequest, error) {
+	q := r.URL.Query()
+	req := pageRequest{limit: defaultPageLimit, order: orders[0]}
+
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return req, errors.New("Invalid limit parameter")
+		}
+		req.limit = min(limit, maxPageLimit)
+	}
+
+	if s := q.Get("sort"); s != "" {
+		found := false
+		for _, o := range orders {
+			if o.name == s {
+				req.order = o
+				found = true
+				break
+			}
+		}
+		if !found {
+			return req, errors.New("Unknown sort parameter")
+		}
+	}
+
+	if s := q.Get("cursor"); s != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(s)
+		var c cursorData
+		if err != nil || json.Unmarshal(raw, &c) != nil ||
+			c.Sort != req.order.name || len(c.Values) != len(req.order.keys) {
+			return req, errors.New("Invalid cursor parameter")
+		}
+		req.after = c.Values
+	}
+
+	return req, nil
+}
+
+// apply appends the cursor condition, ORDER BY and LIMIT to a query that
+// already has a WHERE clause. One extra row is requested to detect
+// whether there is a next page.
+func (p pageRequest) apply(query string, args []any) (string, []any) {
+	if p.after != nil {
+		cond, condArgs := p.order.after(p.after)
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	query += " ORDER BY " + p.order.orderBy() + " LIMIT ?"
+	args = append(args, p.limit+1)
+	return query, args
+}
+
+// keyDests returns scan destinations for the extra sort key columns.
+func (p pageRequest) keyDests(values []int64) []any {
+	dests := make([]any, len(values))
+	for i := range values {
+		dests[i] = &values[i]
+	}
+	return dests
+}
+
+// newPage trims the extra row fetched by apply and sets the next cursor
+// from the key values of the last item kept.
+func newPage[T any](p pageRequest, items []T, keys [][]int64) Page[T] {
+	page := Page[T]{Items: items}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	if len(items) > p.limit {
+		page.Items = items[:p.limit]
+		cursor := encodeCursor(p.order.name, keys[p.limit-1])
+		page.NextCursor = &cursor
+	}
+	return page
+}
+
+// paginate does in Go what apply and newPage do in SQL, for items
+// already in memory: key returns the sort key values of an item, in
+// the order of p.order.keys.
+func paginate[T any](p pageRequest, items []T, key func(T) []int64) Page[T] {
+	compare := func(a, b []int64) int {
+		for i, k := range p.order.keys {
+			c := cmp.Compare(a[i], b[i])
+			if k.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+
+	keys := make([][]int64, len(items))
+	for i, item := range items {
+		keys[i] = key(item)
+	}
+	index := make([]int, len(items))
+	for i := range index {
+		index[i] = i
+	}
+	slices.SortFunc(index, func(a, b int) int { return compare(keys[a], keys[b]) })
+
+	var kept []T
+	var keptKeys [][]int64
+	for _, i := range index {
+		if p.after != nil && compare(keys[i], p.after) <= 0 {
+			continue
+		}
+		kept = append(kept, items[i])
+		keptKeys = append(keptKeys, keys[i])
+		if len(kept) > p.limit {
+			break
+		}
+	}
+	return newPage(p, kept, keptKeys)
+}
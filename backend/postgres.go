@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"net/url"
+)
+
+// openPostgres opens the Postgres database at the connection URL without
+// touching its schema. Sessions run in UTC, so CURRENT_TIMESTAMP and the
+// times the server writes agree, as they do on SQLite.
+func openPostgres(connURL string) (*database, error) {
+	u, err := url.Parse(connURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("timezone", "UTC")
+	u.RawQuery = q.Encode()
+
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		return nil, err
+	}
+	return &database{DB: db, dialect: postgresDialect}, nil
+}
+
+// postgresMigrations are the schema changes for Postgres. The first
+// creates the schema the SQLite migrations had reached by then, but for
+// full-text search, which the second adds the Postgres way. Later
+// changes to the schema get a migration here as well as in migrations.
+var postgresMigrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		// Flags are INTEGER 0/1 and ids have no foreign keys where SQLite
+		// has none, so the same queries behave the same on both.
+		up: `
+			CREATE TABLE users (
+				id SERIAL PRIMARY KEY,
+				username TEXT NOT NULL UNIQUE,
+				password_hash TEXT NOT NULL DEFAULT '',
+				role TEXT NOT NULL DEFAULT 'member'
+					CHECK (role IN ('admin', 'moderator', 'member', 'readonly'))
+			);
+			CREATE TABLE topics (
+				id SERIAL PRIMARY KEY,
+				title TEXT NOT NULL,
+				description TEXT,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				is_archived INTEGER NOT NULL DEFAULT 0
+			);
+			CREATE TABLE posts (
+				id SERIAL PRIMARY KEY,
+				topic_id INTEGER NOT NULL REFERENCES topics(id),
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				title TEXT NOT NULL,
+				content TEXT NOT NULL,
+				is_pinned INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ,
+				updated_by INTEGER,
+				deleted_at TIMESTAMPTZ,
+				deleted_by INTEGER,
+				accepted_comment_id INTEGER
+			);
+			CREATE TABLE comments (
+				id SERIAL PRIMARY KEY,
+				post_id INTEGER NOT NULL REFERENCES posts(id),
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				content TEXT NOT NULL,
+				is_pinned INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ,
+				updated_by INTEGER,
+				deleted_at TIMESTAMPTZ,
+				deleted_by INTEGER,
+				parent_id INTEGER,
+				depth INTEGER NOT NULL DEFAULT 0
+			);
+			CREATE INDEX comments_parent_id ON comments (parent_id);
+			CREATE TABLE sessions (
+				id TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				expires_at TIMESTAMPTZ NOT NULL
+			);
+			CREATE TABLE post_revisions (
+				id SERIAL PRIMARY KEY,
+				post_id INTEGER NOT NULL REFERENCES posts(id),
+				title TEXT NOT NULL,
+				content TEXT NOT NULL,
+				editor_id INTEGER NOT NULL REFERENCES users(id),
+				created_at TIMESTAMPTZ NOT NULL
+			);
+			CREATE INDEX post_revisions_post_id ON post_revisions (post_id);
+			CREATE TABLE comment_revisions (
+				id SERIAL PRIMARY KEY,
+				comment_id INTEGER NOT NULL REFERENCES comments(id),
+				content TEXT NOT NULL,
+				editor_id INTEGER NOT NULL REFERENCES users(id),
+				created_at TIMESTAMPTZ NOT NULL
+			);
+			CREATE INDEX comment_revisions_comment_id ON comment_revisions (comment_id);
+			CREATE TABLE votes (
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment')),
+				target_id INTEGER NOT NULL,
+				value INTEGER NOT NULL CHECK (value IN (-1, 1)),
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (user_id, target_type, target_id)
+			);
+			CREATE INDEX votes_target ON votes (target_type, target_id);
+			CREATE TABLE role_permissions (
+				role TEXT NOT NULL,
+				permission TEXT NOT NULL,
+				PRIMARY KEY (role, permission)
+			);
+			INSERT INTO role_permissions (role, permission) VALUES
+				('admin', 'content.create'),
+				('admin', 'content.vote'),
+				('admin', 'content.edit_own'),
+				('admin', 'content.moderate'),
+				('admin', 'trash.manage'),
+				('admin', 'topics.manage'),
+				('admin', 'roles.manage'),
+				('admin', 'users.sanction'),
+				('admin', 'reports.review'),
+				('admin', 'audit.view'),
+				('moderator', 'content.create'),
+				('moderator', 'content.vote'),
+				('moderator', 'content.edit_own'),
+				('moderator', 'content.moderate'),
+				('moderator', 'trash.manage'),
+				('moderator', 'topics.manage'),
+				('moderator', 'users.sanction'),
+				('moderator', 'reports.review'),
+				('moderator', 'audit.view'),
+				('topic_moderator', 'content.moderate'),
+				('topic_moderator', 'reports.review'),
+				('member', 'content.create'),
+				('member', 'content.vote'),
+				('member', 'content.edit_own');
+			CREATE TABLE topic_moderators (
+				topic_id INTEGER NOT NULL REFERENCES topics(id),
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (topic_id, user_id)
+			);
+			CREATE INDEX topic_moderators_user_id ON topic_moderators (user_id);
+			CREATE TABLE sanctions (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				issued_by INTEGER NOT NULL REFERENCES users(id),
+				reason TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL,
+				expires_at TIMESTAMPTZ,
+				lifted_at TIMESTAMPTZ,
+				lifted_by INTEGER REFERENCES users(id)
+			);
+			CREATE INDEX sanctions_user_id ON sanctions (user_id);
+			CREATE TABLE reports (
+				id SERIAL PRIMARY KEY,
+				reporter_id INTEGER NOT NULL REFERENCES users(id),
+				target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment')),
+				target_id INTEGER NOT NULL,
+				topic_id INTEGER NOT NULL REFERENCES topics(id),
+				reason TEXT NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				resolution TEXT CHECK (resolution IN ('dismissed', 'deleted', 'banned')),
+				resolved_by INTEGER REFERENCES users(id),
+				resolved_at TIMESTAMPTZ,
+				resolution_note TEXT NOT NULL DEFAULT ''
+			);
+			CREATE INDEX reports_target ON reports (target_type, target_id);
+			CREATE INDEX reports_topic_id ON reports (topic_id);
+			CREATE TABLE audit_log (
+				id SERIAL PRIMARY KEY,
+				actor_id INTEGER NOT NULL REFERENCES users(id),
+				action TEXT NOT NULL,
+				target_type TEXT NOT NULL,
+				target_id INTEGER NOT NULL,
+				before TEXT,
+				after TEXT,
+				created_at TIMESTAMPTZ NOT NULL
+			);
+			CREATE INDEX audit_log_actor_id ON audit_log (actor_id);
+			CREATE INDEX audit_log_action ON audit_log (action);
+			CREATE INDEX audit_log_created_at ON audit_log (created_at);
+			CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_log is append-only';
+			END;
+			$$ LANGUAGE plpgsql;
+			CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON audit_log
+				FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+			CREATE TABLE notifications (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				actor_id INTEGER NOT NULL REFERENCES users(id),
+				kind TEXT NOT NULL CHECK (kind IN ('comment', 'reply', 'mention', 'edit', 'pin')),
+				post_id INTEGER NOT NULL REFERENCES posts(id),
+				comment_id INTEGER REFERENCES comments(id),
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				read_at TIMESTAMPTZ
+			);
+			CREATE INDEX notifications_user_id ON notifications (user_id, read_at);
+			CREATE INDEX notifications_post_id ON notifications (post_id);
+			CREATE INDEX notifications_comment_id ON notifications (comment_id);
+		`,
+		down: `
+			DROP TABLE notifications;
+			DROP TABLE audit_log;
+			DROP FUNCTION audit_log_append_only;
+			DROP TABLE reports;
+			DROP TABLE sanctions;
+			DROP TABLE topic_moderators;
+			DROP TABLE role_permissions;
+			DROP TABLE votes;
+			DROP TABLE comment_revisions;
+			DROP TABLE post_revisions;
+			DROP TABLE sessions;
+			DROP TABLE comments;
+			DROP TABLE posts;
+			DROP TABLE topics;
+			DROP TABLE users;
+		`,
+	},
+	{
+		version: 2,
+		name:    "full-text search",
+		// Generated tsvector columns with GIN indexes stand in for the FTS5
+		// tables. forum_search stems English words like the porter
+		// tokenizer but, like it, keeps stop words, so every word of a
+		// query has to match.
+		up: `
+			CREATE TEXT SEARCH DICTIONARY forum_stem (TEMPLATE = snowball, LANGUAGE = english);
+			CREATE TEXT SEARCH CONFIGURATION forum_search (COPY = english);
+			ALTER TEXT SEARCH CONFIGURATION forum_search
+				ALTER MAPPING FOR asciiword, asciihword, hword_asciipart, word, hword, hword_part WITH forum_stem;
+			ALTER TABLE topics ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('forum_search', title), 'A') ||
+				setweight(to_tsvector('forum_search', coalesce(description, '')), 'B')
+			) STORED;
+			CREATE INDEX topics_search_vector ON topics USING GIN (search_vector);
+			ALTER TABLE posts ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('forum_search', title), 'A') ||
+				setweight(to_tsvector('forum_search', content), 'B')
+			) STORED;
+			CREATE INDEX posts_search_vector ON posts USING GIN (search_vector);
+			ALTER TABLE comments ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+				to_tsvector('forum_search', content)
+			) STORED;
+			CREATE INDEX comments_search_vector ON comments USING GIN (search_vector);
+		`,
+		down: `
+			ALTER TABLE comments DROP COLUMN search_vector;
+			ALTER TABLE posts DROP COLUMN search_vector;
+			ALTER TABLE topics DROP COLUMN search_vector;
+			DROP TEXT SEARCH CONFIGURATION forum_search;
+			DROP TEXT SEARCH DICTIONARY forum_stem;
+		`,
+	},
+	{
+		version: 3,
+		name:    "case-insensitive usernames",
+		// renameCaseDuplicates makes the usernames distinct first
+		before: renameCaseDuplicates,
+		up: `
+			CREATE UNIQUE INDEX users_username_lower ON users (lower(username));
+		`,
+		down: `
+			DROP INDEX users_username_lower;
+		`,
+	},
+}
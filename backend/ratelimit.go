@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimit allows a burst of up to requests, refilled evenly over per.
+type rateLimit struct {
+	requests int
+	per      time.Duration
+}
+
+// bucket is a token bucket: each request takes a token, and tokens come
+// back at the limit's rate.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one bucket per caller for one route.
+type rateLimiter struct {
+	limit rateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit rateLimit) *rateLimiter {
+	return &rateLimiter{limit: limit, buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+// allow takes a token from the bucket of every key, or from none of
+// them if one is empty, in which case it returns false and how long
+// until they all have a token again.
+func (l *rateLimiter) allow(keys []string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(l.limit.requests)
+	refill := capacity / l.limit.per.Seconds()
+
+	// Forget callers whose buckets have filled up again
+	if now.Sub(l.lastSweep) > l.limit.per {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > l.limit.per {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	ok := true
+	var wait time.Duration
+	buckets := make([]*bucket, len(keys))
+	for i, key := range keys {
+		b, found := l.buckets[key]
+		if !found {
+			b = &bucket{tokens: capacity, last: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*refill)
+		b.last = now
+		buckets[i] = b
+
+		if b.tokens < 1 {
+			ok = false
+			wait = max(wait, time.Duration((1-b.tokens)/refill*float64(time.Second)))
+		}
+	}
+	if !ok {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// rateLimitKeys identifies the caller: by the client's IP address, and
+// also by the logged-in user if there is one. A request has to fit in
+// the limit of each, so neither more accounts nor more addresses get a
+// caller more requests. Put withSession outside withRateLimit so that
+// users are known.
+func rateLimitKeys(r *http.Request) []string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	keys := []string{"ip:" + host}
+	if user := currentUser(r); user != nil {
+		keys = append(keys, "user:"+strconv.Itoa(user.ID))
+	}
+	return keys
+}
+
+// withRateLimit is a small wrapper that limits how often each caller
+// can make requests with the given methods, or without methods, any
+// request that changes data. Callers over the limit get 429 with a
+// Retry-After header in seconds. Routes that share a limiter share its
+// limit.
+func withRateLimit(limiter *rateLimiter, handler http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limited := slices.Contains(methods, r.Method)
+		if len(methods) == 0 {
+			limited = r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+		}
+		if !limited {
+			handler(w, r)
+			return
+		}
+
+		ok, wait := limiter.allow(rateLimitKeys(r), time.Now())
+		if !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeError(w, fmt.Sprintf("Too many requests; try again in %d seconds", seconds), http.StatusTooManyRequests)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// withEditLimit limits PUT and DELETE on /posts/{id} or, for kind
+// "comment", /comments/{id}. Moderators of the content's topic are not
+// limited, since cleaning up after spam means deleting a lot at once.
+func (s *server) withEditLimit(limiter *rateLimiter, kind string, handler http.HandlerFunc) http.HandlerFunc {
+	limited := withRateLimit(limiter, handler, http.MethodPut, http.MethodDelete)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == http.MethodPut || r.Method == http.MethodDelete) && s.moderatesContent(r, kind) {
+			handler(w, r)
+			return
+		}
+		limited(w, r)
+	}
+}
+
+// moderatesContent reports whether the current user may moderate the
+// post or comment in the {id} path parameter. It is false if either
+// cannot be found; the handler then answers for itself.
+func (s *server) moderatesContent(r *http.Request, kind string) bool {
+	user := currentUser(r)
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if user == nil || err != nil {
+		return false
+	}
+	var topicID int
+	if kind == "comment" {
+		_, _, topicID, err = s.store.CommentOwner(id)
+	} else {
+		_, topicID, err = s.store.PostOwner(id)
+	}
+	if err != nil {
+		return false
+	}
+	allowed, err := s.store.Authorize(user.ID, permModerateContent, topicID)
+	return err == nil && allowed
+}
+
+// isDuplicatePost reports whether the user posted the same title and
+// content within the duplicate window.
+func (s *server) isDuplicatePost(st PostStore, userID int, title, content string) (bool, error) {
+	if s.config.DuplicateWindow == 0 {
+		return false, nil
+	}
+	return st.HasRecentPost(userID, title, content, time.Now().Add(-s.config.DuplicateWindow))
+}
+
+// isDuplicateComment reports whether the user wrote the same comment on
+// the same post within the duplicate window.
+func (s *server) isDuplicateComment(st CommentStore, userID, postID int, content string) (bool, error) {
+	if s.config.DuplicateWindow == 0 {
+		return false, nil
+	}
+	return st.HasRecentComment(userID, postID, content, time.Now().Add(-s.config.DuplicateWindow))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterKeys(t *testing.T) {
+	limiter := newRateLimiter(rateLimit{2, time.Hour})
+	now := time.Now()
+	for _, tt := range []struct {
+		keys []string
+		ok   bool
+	}{
+		{[]string{"ip:a", "user:1"}, true},
+		{[]string{"ip:a", "user:1"}, true},
+		// The user is over the limit from another address too
+		{[]string{"ip:b", "user:1"}, false},
+		// and so is anyone else at the first address
+		{[]string{"ip:a", "user:2"}, false},
+		{[]string{"ip:a"}, false},
+		// A refused request took no token from ip:b or user:2
+		{[]string{"ip:b", "user:2"}, true},
+		{[]string{"ip:b", "user:2"}, true},
+		{[]string{"ip:b"}, false},
+	} {
+		ok, wait := limiter.allow(tt.keys, now)
+		if ok != tt.ok {
+			t.Fatalf("allow(%v) = %v, want %v", tt.keys, ok, tt.ok)
+		}
+		if !ok && wait <= 0 {
+			t.Errorf("allow(%v) wait = %v, want a positive wait", tt.keys, wait)
+		}
+	}
+
+	// Half the period brings back one token
+	if ok, _ := limiter.allow([]string{"ip:a", "user:1"}, now.Add(30*time.Minute)); !ok {
+		t.Error("no token after half the period")
+	}
+}
+
+// limitedServer is a test server whose routes use limits.
+func limitedServer(t *testing.T, limits func(l *RateLimits)) *testServer {
+	ts := newTestServer(t)
+	limits(&ts.config.RateLimits)
+	ts.handler = ts.routes()
+	return ts
+}
+
+func TestEditRateLimit(t *testing.T) {
+	const postPath = "/posts/1"
+	edit := UpdatePostRequest{Title: "Edited", Content: "New content"}
+
+	t.Run("edits do not use the create limit", func(t *testing.T) {
+		ts := limitedServer(t, func(l *RateLimits) {
+			l.Posts = rateLimit{1, time.Hour}
+			l.Comments = rateLimit{1, time.Hour}
+		})
+		wantStatus(t, ts.do(http.MethodPost, "/topics/1/posts", "bob", CreatePostRequest{Title: "New", Content: "post"}), http.StatusCreated)
+		wantStatus(t, ts.do(http.MethodPost, "/posts/1/comments", "carol", CreateCommentRequest{Content: "Hi"}), http.StatusCreated)
+		wantError(t, ts.do(http.MethodPost, "/topics/1/posts", "bob", CreatePostRequest{Title: "Newer", Content: "post"}), http.StatusTooManyRequests, "")
+		wantError(t, ts.do(http.MethodPost, "/posts/1/comments", "carol", CreateCommentRequest{Content: "Hi again"}), http.StatusTooManyRequests, "")
+
+		wantStatus(t, ts.do(http.MethodPut, postPath, "bob", edit), http.StatusOK)
+		wantStatus(t, ts.do(http.MethodPut, fmt.Sprint("/comments/", ts.comment.ID), "carol", UpdateCommentRequest{Content: "Edited"}), http.StatusOK)
+		wantStatus(t, ts.do(http.MethodDelete, fmt.Sprint("/comments/", ts.comment.ID), "carol", nil), http.StatusNoContent)
+		wantStatus(t, ts.do(http.MethodDelete, postPath, "bob", nil), http.StatusNoContent)
+	})
+
+	t.Run("creates do not use the edit limit", func(t *testing.T) {
+		ts := limitedServer(t, func(l *RateLimits) { l.Edits = rateLimit{2, time.Hour} })
+		wantStatus(t, ts.do(http.MethodPut, postPath, "bob", edit), http.StatusOK)
+		wantStatus(t, ts.do(http.MethodPut, "/posts", "bob", UpdatePostRequest{ID: 1, Title: "Edited", Content: "Again"}), http.StatusOK)
+		// Posts and comments, and the deprecated aliases, share the limit
+		wantError(t, ts.do(http.MethodDelete, fmt.Sprint("/comments/", ts.comment.ID), "carol", nil), http.StatusTooManyRequests, "")
+		wantError(t, ts.do(http.MethodPut, "/posts", "bob", UpdatePostRequest{ID: 1, Title: "Edited", Content: "Once more"}), http.StatusTooManyRequests, "")
+
+		wantStatus(t, ts.do(http.MethodPost, "/topics/1/posts", "bob", CreatePostRequest{Title: "New", Content: "post"}), http.StatusCreated)
+		wantStatus(t, ts.do(http.MethodPost, "/posts/1/comments", "carol", CreateCommentRequest{Content: "Hi"}), http.StatusCreated)
+		// Reads are never limited
+		wantStatus(t, ts.do(http.MethodGet, postPath, "bob", nil), http.StatusOK)
+	})
+
+	t.Run("moderators are not limited", func(t *testing.T) {
+		ts := limitedServer(t, func(l *RateLimits) { l.Edits = rateLimit{1, time.Hour} })
+		for i := range 3 {
+			w := ts.do(http.MethodPut, postPath, "mona", UpdatePostRequest{Title: "Edited", Content: fmt.Sprint("By mona ", i)})
+			wantStatus(t, w, http.StatusOK)
+		}
+		wantStatus(t, ts.do(http.MethodDelete, fmt.Sprint("/comments/", ts.comment.ID), "tom", nil), http.StatusNoContent)
+
+		// Their edits took nothing from the limit of their address
+		wantStatus(t, ts.do(http.MethodPut, postPath, "bob", edit), http.StatusOK)
+		wantError(t, ts.do(http.MethodPut, postPath, "bob", edit), http.StatusTooManyRequests, "")
+	})
+}
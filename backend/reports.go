@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Report is a user's complaint about a post or comment. Resolution,
+// ResolvedBy and ResolvedAt are null while the report is open. Post or
+// Comment holds the reported content while it is still visible.
+type Report struct {
+	ID         int        `json:"id"`
+	TargetType string     `json:"targetType"`
+	TargetID   int        `json:"targetId"`
+	TopicID    int        `json:"topicId"`
+	Reason     string     `json:"reason"`
+	Reporter   string     `json:"reporter"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	Resolution *string    `json:"resolution"`
+	ResolvedBy *string    `json:"resolvedBy"`
+	ResolvedAt *time.Time `json:"resolvedAt"`
+	Note       string     `json:"note"`
+	Post       *Post      `json:"post,omitempty"`
+	Comment    *Comment   `json:"comment,omitempty"`
+}
+
+// CreateReportRequest represents the JSON body for reporting content.
+type CreateReportRequest struct {
+	TargetType string `json:"targetType"`
+	TargetID   int    `json:"targetId"`
+	Reason     string `json:"reason"`
+}
+
+// ResolveReportRequest represents the JSON body for acting on a report.
+type ResolveReportRequest struct {
+	Action string `json:"action"`
+	Note   string `json:"note"`
+}
+
+// reportResolutions maps each action a moderator can take on a report
+// to the resolution it is recorded with.
+var reportResolutions = map[string]string{
+	"dismiss": "dismissed",
+	"delete":  "deleted",
+	"ban":     "banned",
+}
+
+// reviewableTopics returns the topics in which the user may review
+// reports, or nil for forum-wide moderators. ok is false if the user may
+// not review any reports.
+func reviewableTopics(st Store, userID int) (topicIDs []int, ok bool, err error) {
+	global, err := st.Authorize(userID, permReviewReports, 0)
+	if err != nil || global {
+		return nil, global, err
+	}
+
+	user, err := st.User(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, topicID := range user.ModeratedTopicIDs {
+		allowed, err := st.Authorize(userID, permReviewReports, topicID)
+		if err != nil {
+			return nil, false, err
+		}
+		if allowed {
+			topicIDs = append(topicIDs, topicID)
+		}
+	}
+	return topicIDs, len(topicIDs) > 0, nil
+}
+
+// reportsHandler handles:
+//   - GET  /reports → moderation queue (?status=resolved for past reports)
+//   - POST /reports → report a post or comment
+func (s *server) reportsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListReports(w, r)
+	case http.MethodPost:
+		s.handleCreateReport(w, r)
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleListReports handles GET /reports
+// Open reports are listed oldest first, resolved ones most recently
+// resolved first. Moderators of single topics only see reports there.
+func (s *server) handleListReports(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+
+	var resolved bool
+	switch r.URL.Query().Get("status") {
+	case "", "open":
+	case "resolved":
+		resolved = true
+	default:
+		writeError(w, "Status must be open or resolved", http.StatusBadRequest)
+		return
+	}
+
+	topicIDs, ok, err := reviewableTopics(s.store, user.ID)
+	if err != nil {
+		writeError(w, "Authorization check failed", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		writeError(w, "Only moderators can review reports", http.StatusForbidden)
+		return
+	}
+
+	reports, err := s.store.Reports(resolved, topicIDs)
+	if err != nil {
+		writeError(w, "Failed to query reports", http.StatusInternalServerError)
+		return
+	}
+
+	// Attach the reported content that is still visible
+	for i := range reports {
+		rep := &reports[i]
+		if rep.TargetType == "post" {
+			post, err := s.store.Post(rep.TargetID)
+			if err == nil {
+				rep.Post = &post
+			} else if err != errNotFound {
+				writeError(w, "Failed to load reported post", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			comment, err := s.store.Comment(rep.TargetID)
+			if err == nil {
+				rep.Comment = &comment
+			} else if err != errNotFound {
+				writeError(w, "Failed to load reported comment", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reports); err != nil {
+		writeError(w, "Failed to encode reports", http.StatusInternalServerError)
+	}
+}
+
+// handleCreateReport handles POST /reports
+// It takes { "targetType": "post" | "comment", "targetId": 1, "reason": "..." }.
+// Users cannot report their own content or report the same thing twice
+// while their first report is open.
+func (s *server) handleCreateReport(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	var v validator
+	v.check(req.TargetType == "post" || req.TargetType == "comment", "targetType", "targetType must be post or comment")
+	v.check(req.TargetID != 0, "targetId", "Missing targetId")
+	reason := v.text("reason", "Reason", req.Reason, 1, maxReasonLength)
+	if !v.valid(w) {
+		return
+	}
+
+	var created Report
+	err := s.store.Tx(func(tx Store) error {
+		var authorID, topicID int
+		var err error
+		missing := "Post not found"
+		if req.TargetType == "post" {
+			authorID, topicID, err = tx.PostOwner(req.TargetID)
+		} else {
+			authorID, _, topicID, err = tx.CommentOwner(req.TargetID)
+			missing = "Comment not found"
+		}
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, missing}
+		}
+		if err != nil {
+			return err
+		}
+		if authorID == user.ID {
+			return &statusError{http.StatusBadRequest, "You cannot report your own " + req.TargetType}
+		}
+
+		open, err := tx.HasOpenReport(user.ID, req.TargetType, req.TargetID)
+		if err != nil {
+			return err
+		}
+		if open {
+			return &statusError{http.StatusConflict, "You have already reported this " + req.TargetType}
+		}
+
+		created, err = tx.CreateReport(user.ID, req.TargetType, req.TargetID, topicID, reason)
+		return err
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to create report")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(created); err != nil {
+		writeError(w, "Failed to encode report", http.StatusInternalServerError)
+	}
+}
+
+// resolveReportHandler handles POST /reports/{id}/resolve
+// It takes { "action": "dismiss" | "delete" | "ban", "note": "..." }.
+// delete moves the content to the trash and ban bans its author (with
+// the note, or else the report's reason, as the ban reason). Every open
+// report on the same content is resolved with the same outcome.
+func (s *server) resolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req ResolveReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	var v validator
+	resolution, ok := reportResolutions[req.Action]
+	v.check(ok, "action", "Action must be dismiss, delete or ban")
+	note := v.text("note", "Note", req.Note, 0, maxReasonLength)
+	if !v.valid(w) {
+		return
+	}
+
+	var resolved Report
+	var deleted *Event
+	err := s.store.Tx(func(tx Store) error {
+		report, err := tx.Report(id)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Report not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if report.Resolution != nil {
+			return &statusError{http.StatusConflict, "Report is already resolved"}
+		}
+
+		allowed, err := tx.Authorize(user.ID, permReviewReports, report.TopicID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Only moderators can review reports"}
+		}
+
+		switch req.Action {
+		case "delete":
+			// Content that is already in the trash stays as it is
+			var before any
+			postID := report.TargetID
+			if report.TargetType == "post" {
+				before, err = tx.Post(report.TargetID)
+			} else {
+				var comment Comment
+				comment, err = tx.Comment(report.TargetID)
+				before, postID = comment, comment.PostID
+			}
+			if err == errNotFound {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if report.TargetType == "post" {
+				err = tx.TrashPost(report.TargetID, user.ID)
+			} else {
+				err = tx.TrashComment(report.TargetID, user.ID)
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.RecordAudit(user.ID, report.TargetType+".delete", report.TargetType, report.TargetID, before, nil); err != nil {
+				return err
+			}
+			deleted = &Event{report.TargetType + ".deleted", report.TopicID, postID, deletedRef{report.TargetID}}
+		case "ban":
+			canBan, err := tx.Authorize(user.ID, permSanctionUsers, 0)
+			if err != nil {
+				return err
+			}
+			if !canBan {
+				return &statusError{http.StatusForbidden, "Only moderators can ban users"}
+			}
+
+			authorID, err := tx.ContentAuthor(report.TargetType, report.TargetID)
+			if err != nil {
+				return err
+			}
+			reason := note
+			if reason == "" {
+				reason = report.Reason
+			}
+			if _, err := sanctionUser(tx, user.ID, authorID, reason, nil); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.ResolveReports(report.TargetType, report.TargetID, resolution, user.ID, note); err != nil {
+			return err
+		}
+		resolved, err = tx.Report(id)
+		if err != nil {
+			return err
+		}
+		return tx.RecordAudit(user.ID, "report.resolve", "report", id, report, resolved)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to resolve report")
+		return
+	}
+	if deleted != nil {
+		s.events.publish(*deleted)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resolved); err != nil {
+		writeError(w, "Failed to encode report", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestResolveReport(t *testing.T) {
+	t.Run("delete", func(t *testing.T) {
+		ts := newTestServer(t)
+		for _, reporter := range []string{"carol", "tom"} {
+			w := ts.do(http.MethodPost, "/reports", reporter, CreateReportRequest{TargetType: "post", TargetID: ts.post.ID, Reason: "spam"})
+			wantStatus(t, w, http.StatusCreated)
+		}
+		sub := ts.events.subscribe(0, 0)
+		defer ts.events.unsubscribe(sub)
+
+		w := ts.do(http.MethodPost, "/reports/1/resolve", "tom", ResolveReportRequest{Action: "delete"})
+		wantStatus(t, w, http.StatusOK)
+		if got := decode[Report](t, w); got.Resolution == nil || *got.Resolution != "deleted" {
+			t.Errorf("resolution = %v, want deleted", got.Resolution)
+		}
+
+		// The post went to the trash the same way DELETE /posts/{id} sends it
+		wantStatus(t, ts.do(http.MethodGet, fmt.Sprint("/posts/", ts.post.ID), "", nil), http.StatusNotFound)
+		if e := <-sub.events; e.Type != "post.deleted" || e.PostID != ts.post.ID {
+			t.Errorf("event = %+v, want post.deleted for post %d", e, ts.post.ID)
+		}
+
+		// Every open report on the post was resolved with it
+		open, err := ts.store.Reports(false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(open) != 0 {
+			t.Errorf("%d reports still open", len(open))
+		}
+	})
+
+	t.Run("ban", func(t *testing.T) {
+		ts := newTestServer(t)
+		w := ts.do(http.MethodPost, "/reports", "bob", CreateReportRequest{TargetType: "comment", TargetID: ts.comment.ID, Reason: "abuse"})
+		wantStatus(t, w, http.StatusCreated)
+
+		// Topic moderators can review but not ban
+		w = ts.do(http.MethodPost, "/reports/1/resolve", "tom", ResolveReportRequest{Action: "ban"})
+		wantError(t, w, http.StatusForbidden, "")
+
+		w = ts.do(http.MethodPost, "/reports/1/resolve", "mona", ResolveReportRequest{Action: "ban"})
+		wantStatus(t, w, http.StatusOK)
+		sanction, err := ts.store.ActiveSanction(ts.users["carol"].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sanction == nil || sanction.Reason != "abuse" || sanction.ExpiresAt != nil {
+			t.Errorf("sanction = %+v, want a ban for abuse", sanction)
+		}
+
+		w = ts.do(http.MethodPost, "/reports/1/resolve", "mona", ResolveReportRequest{Action: "dismiss"})
+		wantError(t, w, http.StatusConflict, "")
+	})
+}
+
+func TestCreateReport(t *testing.T) {
+	ts := newTestServer(t)
+	report := CreateReportRequest{TargetType: "comment", TargetID: ts.comment.ID, Reason: "Rude"}
+	w := ts.do(http.MethodPost, "/reports", "bob", report)
+	wantStatus(t, w, http.StatusCreated)
+	if got := decode[Report](t, w); got.Reporter != "bob" || got.TopicID != ts.general.ID || got.Resolution != nil {
+		t.Errorf("report = %+v, want bob's open report in General", got)
+	}
+	// One open report per user and target
+	wantError(t, ts.do(http.MethodPost, "/reports", "bob", report), http.StatusConflict, "")
+	wantStatus(t, ts.do(http.MethodPost, "/reports", "tom", report), http.StatusCreated)
+
+	for _, tt := range []struct {
+		name  string
+		body  CreateReportRequest
+		field string
+	}{
+		{"invalid targetType", CreateReportRequest{TargetType: "topic", TargetID: 1, Reason: "Spam"}, "targetType"},
+		{"missing targetId", CreateReportRequest{TargetType: "post", Reason: "Spam"}, "targetId"},
+		{"missing reason", CreateReportRequest{TargetType: "post", TargetID: 1}, "reason"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			wantError(t, ts.do(http.MethodPost, "/reports", "carol", tt.body), http.StatusBadRequest, tt.field)
+		})
+	}
+
+	spam := CreateReportRequest{TargetType: "post", TargetID: 1, Reason: "Spam"}
+	runRequests(t, []request{
+		{"anonymous", http.MethodPost, "/reports", "", spam, http.StatusUnauthorized},
+		{"banned", http.MethodPost, "/reports", "ben", spam, http.StatusForbidden},
+		{"own post", http.MethodPost, "/reports", "bob", spam, http.StatusBadRequest},
+		{"unknown post", http.MethodPost, "/reports", "carol", CreateReportRequest{TargetType: "post", TargetID: 9999, Reason: "Spam"}, http.StatusNotFound},
+		{"unknown comment", http.MethodPost, "/reports", "carol", CreateReportRequest{TargetType: "comment", TargetID: 9999, Reason: "Spam"}, http.StatusNotFound},
+		{"invalid JSON", http.MethodPost, "/reports", "carol", "{", http.StatusBadRequest},
+		{"wrong method", http.MethodDelete, "/reports", "carol", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestListReports(t *testing.T) {
+	ts := newTestServer(t)
+	for _, report := range []CreateReportRequest{
+		{TargetType: "post", TargetID: ts.post.ID, Reason: "Spam"},
+		{TargetType: "post", TargetID: ts.homeworkPost.ID, Reason: "Off topic"},
+	} {
+		wantStatus(t, ts.do(http.MethodPost, "/reports", "rita", report), http.StatusCreated)
+	}
+	wantStatus(t, ts.do(http.MethodPost, "/reports/2/resolve", "mona", ResolveReportRequest{Action: "dismiss"}), http.StatusOK)
+
+	for _, tt := range []struct {
+		name, username, status string
+		want                   []int
+	}{
+		{"open", "mona", "", []int{1}},
+		{"resolved", "mona", "resolved", []int{2}},
+		// tom only moderates General, where post 1 is
+		{"topic moderator", "tom", "open", []int{1}},
+		{"topic moderator resolved", "tom", "resolved", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ts.do(http.MethodGet, "/reports?status="+tt.status, tt.username, nil)
+			wantStatus(t, w, http.StatusOK)
+			var got []int
+			for _, rep := range decode[[]Report](t, w) {
+				got = append(got, rep.ID)
+				if rep.TargetType == "post" && rep.Post == nil && rep.Resolution == nil {
+					t.Errorf("report %d has no post", rep.ID)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("reports = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	runRequests(t, []request{
+		{"anonymous", http.MethodGet, "/reports", "", nil, http.StatusUnauthorized},
+		{"member", http.MethodGet, "/reports", "bob", nil, http.StatusForbidden},
+		{"banned", http.MethodGet, "/reports", "ben", nil, http.StatusForbidden},
+		{"invalid status", http.MethodGet, "/reports?status=closed", "mona", nil, http.StatusBadRequest},
+	})
+}
+
+func TestResolveReportErrors(t *testing.T) {
+	t.Run("invalid action", func(t *testing.T) {
+		ts := newTestServer(t)
+		w := ts.do(http.MethodPost, "/reports/1/resolve", "mona", ResolveReportRequest{Action: "archive"})
+		wantError(t, w, http.StatusBadRequest, "action")
+	})
+
+	// Report 1 is carol's on bob's post in General, report 2 bob's on
+	// carol's post in Homework
+	runReportRequests := func(t *testing.T, requests []request) {
+		for _, req := range requests {
+			t.Run(req.name, func(t *testing.T) {
+				ts := newTestServer(t)
+				if _, err := ts.store.CreateReport(ts.users["carol"].ID, "post", ts.post.ID, ts.general.ID, "Spam"); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := ts.store.CreateReport(ts.users["bob"].ID, "post", ts.homeworkPost.ID, ts.homework.ID, "Spam"); err != nil {
+					t.Fatal(err)
+				}
+				wantError(t, ts.do(req.method, req.path, req.username, req.body), req.status, "")
+			})
+		}
+	}
+	dismiss := ResolveReportRequest{Action: "dismiss"}
+	runReportRequests(t, []request{
+		{"anonymous", http.MethodPost, "/reports/1/resolve", "", dismiss, http.StatusUnauthorized},
+		{"member", http.MethodPost, "/reports/1/resolve", "bob", dismiss, http.StatusForbidden},
+		{"moderator of another topic", http.MethodPost, "/reports/2/resolve", "tom", dismiss, http.StatusForbidden},
+		{"unknown report", http.MethodPost, "/reports/9999/resolve", "mona", dismiss, http.StatusNotFound},
+		{"invalid id", http.MethodPost, "/reports/x/resolve", "mona", dismiss, http.StatusBadRequest},
+		{"invalid JSON", http.MethodPost, "/reports/1/resolve", "mona", "{", http.StatusBadRequest},
+		{"wrong method", http.MethodGet, "/reports/1/resolve", "mona", nil, http.StatusMethodNotAllowed},
+	})
+}
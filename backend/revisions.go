@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PostRevision is a previous version of a post. Editor is the user who
+// wrote that version and CreatedAt is when it was written.
+type PostRevision struct {
+	ID        int       `json:"id"`
+	PostID    int       `json:"postId"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Editor    string    `json:"editor"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CommentRevision is a previous version of a comment.
+type CommentRevision struct {
+	ID        int       `json:"id"`
+	CommentID int       `json:"commentId"`
+	Content   string    `json:"content"`
+	Editor    string    `json:"editor"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// pathID parses the {id} path parameter, writing 400 if it is not a number.
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, "Invalid id in path", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// postRevisionsHandler handles GET /posts/{id}/revisions
+// and returns earlier versions of the post, newest first.
+func (s *server) postRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := s.store.Post(id); err == errNotFound {
+		writeError(w, "Post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, "Failed to query post", http.StatusInternalServerError)
+		return
+	}
+
+	revisions, err := s.store.PostRevisions(id)
+	if err != nil {
+		writeError(w, "Failed to query post revisions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(revisions); err != nil {
+		writeError(w, "Failed to encode post revisions", http.StatusInternalServerError)
+	}
+}
+
+// commentRevisionsHandler handles GET /comments/{id}/revisions
+// and returns earlier versions of the comment, newest first.
+func (s *server) commentRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := s.store.Comment(id); err == errNotFound {
+		writeError(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, "Failed to query comment", http.StatusInternalServerError)
+		return
+	}
+
+	revisions, err := s.store.CommentRevisions(id)
+	if err != nil {
+		writeError(w, "Failed to query comment revisions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(revisions); err != nil {
+		writeError(w, "Failed to encode comment revisions", http.StatusInternalServerError)
+	}
+}
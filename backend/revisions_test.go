@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPostRevisions(t *testing.T) {
+	ts := newTestServer(t)
+	original := ts.post.Title
+	wantStatus(t, ts.do(http.MethodPut, "/posts/1", "bob", UpdatePostRequest{Title: "Second", Content: "v2"}), http.StatusOK)
+	wantStatus(t, ts.do(http.MethodPut, "/posts/1", "mona", UpdatePostRequest{Title: "Third", Content: "v3"}), http.StatusOK)
+
+	// Anyone can read the history, newest first
+	w := ts.do(http.MethodGet, "/posts/1/revisions", "", nil)
+	wantStatus(t, w, http.StatusOK)
+	revisions := decode[[]PostRevision](t, w)
+	if len(revisions) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revisions))
+	}
+	if r := revisions[0]; r.Title != "Second" || r.Editor != "bob" {
+		t.Errorf("newest revision = %+v, want bob's second version", r)
+	}
+	if r := revisions[1]; r.Title != original {
+		t.Errorf("oldest revision = %+v, want the original %q", r, original)
+	}
+
+	runRequests(t, []request{
+		{"unknown post", http.MethodGet, "/posts/9999/revisions", "", nil, http.StatusNotFound},
+		{"invalid id", http.MethodGet, "/posts/x/revisions", "", nil, http.StatusBadRequest},
+	})
+}
+
+func TestCommentRevisions(t *testing.T) {
+	ts := newTestServer(t)
+	original := ts.comment.Content
+	wantStatus(t, ts.do(http.MethodPut, "/comments/1", "carol", UpdateCommentRequest{Content: "Edited"}), http.StatusOK)
+
+	w := ts.do(http.MethodGet, "/comments/1/revisions", "", nil)
+	wantStatus(t, w, http.StatusOK)
+	if revisions := decode[[]CommentRevision](t, w); len(revisions) != 1 || revisions[0].Content != original || revisions[0].Editor != "carol" {
+		t.Errorf("revisions = %+v, want carol's original comment", revisions)
+	}
+
+	runRequests(t, []request{
+		{"unknown comment", http.MethodGet, "/comments/9999/revisions", "", nil, http.StatusNotFound},
+		{"invalid id", http.MethodGet, "/comments/x/revisions", "", nil, http.StatusBadRequest},
+	})
+}
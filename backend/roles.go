@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Roles a user can hold. Every user has exactly one; per-topic
+// moderators are members (or better) listed in topic_moderators.
+const (
+	roleAdmin     = "admin"
+	roleModerator = "moderator"
+	roleMember    = "member"
+	roleReadOnly  = "readonly"
+)
+
+// topicModeratorRole is the role_permissions entry whose permissions a
+// user gets inside the topics they moderate. It cannot be assigned as a
+// user's role.
+const topicModeratorRole = "topic_moderator"
+
+// permission names one thing a role allows. The grants themselves live
+// in the role_permissions table.
+type permission string
+
+const (
+	// permCreateContent allows writing posts and comments.
+	permCreateContent permission = "content.create"
+	// permVote allows voting on other users' posts and comments.
+	permVote permission = "content.vote"
+	// permEditOwn allows editing and deleting your own posts and
+	// comments, and accepting answers on your own posts.
+	permEditOwn permission = "content.edit_own"
+	// permModerateContent allows editing, deleting and pinning anyone's
+	// posts and comments, and accepting answers on any post.
+	permModerateContent permission = "content.moderate"
+	// permManageTrash allows viewing and restoring deleted content.
+	permManageTrash permission = "trash.manage"
+	// permManageTopics allows creating, editing, archiving and deleting topics.
+	permManageTopics permission = "topics.manage"
+	// permManageRoles allows assigning roles and topic moderators.
+	permManageRoles permission = "roles.manage"
+	// permSanctionUsers allows banning and suspending users. Users who
+	// hold it cannot be sanctioned themselves.
+	permSanctionUsers permission = "users.sanction"
+	// permReviewReports allows working through the moderation queue.
+	permReviewReports permission = "reports.review"
+	// permViewAudit allows reading the audit log of privileged actions.
+	permViewAudit permission = "audit.view"
+)
+
+// isValidRole reports whether role can be assigned to a user.
+func isValidRole(role string) bool {
+	switch role {
+	case roleAdmin, roleModerator, roleMember, roleReadOnly:
+		return true
+	}
+	return false
+}
+
+// isGlobalModerator reports whether role moderates the whole forum.
+func isGlobalModerator(role string) bool {
+	return role == roleAdmin || role == roleModerator
+}
+
+// requirePermission returns the logged-in user if they hold perm (see
+// authorize) and are not sanctioned, or writes 401/403 and returns false.
+func (s *server) requirePermission(w http.ResponseWriter, r *http.Request, perm permission, topicID int, message string) (*User, bool) {
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	allowed, err := s.store.Authorize(user.ID, perm, topicID)
+	if err != nil {
+		writeError(w, "Authorization check failed", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !allowed {
+		writeError(w, message, http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// setRole gives a user a new role. It refuses to demote the last admin,
+// so someone can always hand out roles.
+func setRole(st UserStore, userID int, role string) error {
+	user, err := st.User(userID)
+	if err == errNotFound {
+		return &statusError{http.StatusNotFound, "User not found"}
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Role == roleAdmin && role != roleAdmin {
+		users, err := st.Users()
+		if err != nil {
+			return err
+		}
+		admins := 0
+		for _, u := range users {
+			if u.Role == roleAdmin {
+				admins++
+			}
+		}
+		if admins == 1 {
+			return &statusError{http.StatusConflict, "The last admin cannot be given another role"}
+		}
+	}
+
+	return st.SetRole(userID, role)
+}
+
+// RoleRequest represents the JSON body for changing a user's role.
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// adminUsersHandler handles GET /admin/users and lists every user with
+// their role and moderated topics. Only admins can use it.
+func (s *server) adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := s.requirePermission(w, r, permManageRoles, 0, "Only admins can manage roles"); !ok {
+		return
+	}
+
+	users, err := s.store.Users()
+	if err != nil {
+		writeError(w, "Failed to query users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(users); err != nil {
+		writeError(w, "Failed to encode users", http.StatusInternalServerError)
+	}
+}
+
+// userRoleHandler handles PUT /admin/users/{id}/role
+// It takes { "role": "admin" | "moderator" | "member" | "readonly" }
+// and returns the updated user. Only admins can change roles.
+func (s *server) userRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	admin, ok := s.requirePermission(w, r, permManageRoles, 0, "Only admins can manage roles")
+	if !ok {
+		return
+	}
+
+	var req RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if !isValidRole(req.Role) {
+		writeFieldError(w, "role", "Role must be admin, moderator, member or readonly")
+		return
+	}
+
+	var updated User
+	err := s.store.Tx(func(tx Store) error {
+		before, err := tx.User(id)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "User not found"}
+		} else if err != nil {
+			return err
+		}
+		if err := setRole(tx, id, req.Role); err != nil {
+			return err
+		}
+		updated, err = tx.User(id)
+		if err != nil {
+			return err
+		}
+		return tx.RecordAudit(admin.ID, "user.role", "user", id, before, updated)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to update role")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		writeError(w, "Failed to encode user", http.StatusInternalServerError)
+	}
+}
+
+// topicModeratorHandler handles:
+//   - PUT    /topics/{id}/moderators/{userId} → make the user a moderator of the topic
+//   - DELETE /topics/{id}/moderators/{userId} → remove them again
+//
+// It returns the updated user. Only admins can assign topic moderators.
+func (s *server) topicModeratorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	topicID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		writeError(w, "Invalid user id in path", http.StatusBadRequest)
+		return
+	}
+	admin, ok := s.requirePermission(w, r, permManageRoles, 0, "Only admins can manage roles")
+	if !ok {
+		return
+	}
+
+	var updated User
+	err = s.store.Tx(func(tx Store) error {
+		if _, err := tx.Topic(topicID); err == errNotFound {
+			return &statusError{http.StatusNotFound, "Topic not found"}
+		} else if err != nil {
+			return err
+		}
+		before, err := tx.User(userID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "User not found"}
+		} else if err != nil {
+			return err
+		}
+
+		action := "user.topic_moderator_add"
+		if r.Method == http.MethodDelete {
+			action = "user.topic_moderator_remove"
+		}
+		if err := tx.SetTopicModerator(topicID, userID, r.Method == http.MethodPut); err != nil {
+			return err
+		}
+
+		updated, err = tx.User(userID)
+		if err != nil {
+			return err
+		}
+		return tx.RecordAudit(admin.ID, action, "user", userID, before, updated)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to update topic moderators")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		writeError(w, "Failed to encode user", http.StatusInternalServerError)
+	}
+}
+
+// runRoleCommand handles "role <username> <role>", which is how the
+// first admin of an existing database is appointed.
+func runRoleCommand(store Store, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected a username and a role")
+	}
+	username, role := args[0], args[1]
+	if !isValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	return store.Tx(func(tx Store) error {
+		user, _, err := tx.UserByName(username)
+		if err == errNotFound {
+			return fmt.Errorf("no user named %q", username)
+		}
+		if err != nil {
+			return err
+		}
+		if err := setRole(tx, user.ID, role); err != nil {
+			var se *statusError
+			if errors.As(err, &se) {
+				return errors.New(se.message)
+			}
+			return err
+		}
+		return nil
+	})
+}
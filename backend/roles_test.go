@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestAdminUsers(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodGet, "/admin/users", "alice", nil)
+	wantStatus(t, w, http.StatusOK)
+	users := decode[[]User](t, w)
+	if len(users) != len(ts.users) {
+		t.Fatalf("got %d users, want %d", len(users), len(ts.users))
+	}
+	for _, u := range users {
+		if u.Username == "tom" && !slices.Equal(u.ModeratedTopicIDs, []int{ts.general.ID}) {
+			t.Errorf("tom moderates %v, want General", u.ModeratedTopicIDs)
+		}
+	}
+
+	runRequests(t, []request{
+		{"anonymous", http.MethodGet, "/admin/users", "", nil, http.StatusUnauthorized},
+		{"moderator", http.MethodGet, "/admin/users", "mona", nil, http.StatusForbidden},
+		{"wrong method", http.MethodPost, "/admin/users", "alice", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestUserRole(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodPut, "/admin/users/4/role", "alice", RoleRequest{Role: roleModerator})
+	wantStatus(t, w, http.StatusOK)
+	if user := decode[User](t, w); user.Role != roleModerator {
+		t.Errorf("role = %q, want moderator", user.Role)
+	}
+	if n := ts.auditCount("user.role", ts.users["bob"].ID); n != 1 {
+		t.Errorf("%d user.role audit entries, want 1", n)
+	}
+	// bob can now see the trash
+	wantStatus(t, ts.do(http.MethodGet, "/trash", "bob", nil), http.StatusOK)
+
+	t.Run("invalid role", func(t *testing.T) {
+		ts := newTestServer(t)
+		wantError(t, ts.do(http.MethodPut, "/admin/users/4/role", "alice", RoleRequest{Role: topicModeratorRole}), http.StatusBadRequest, "role")
+	})
+
+	// alice is the only admin
+	runRequests(t, []request{
+		{"last admin", http.MethodPut, "/admin/users/1/role", "alice", RoleRequest{Role: roleMember}, http.StatusConflict},
+		{"anonymous", http.MethodPut, "/admin/users/4/role", "", RoleRequest{Role: roleMember}, http.StatusUnauthorized},
+		{"moderator", http.MethodPut, "/admin/users/4/role", "mona", RoleRequest{Role: roleMember}, http.StatusForbidden},
+		{"unknown user", http.MethodPut, "/admin/users/9999/role", "alice", RoleRequest{Role: roleMember}, http.StatusNotFound},
+		{"invalid id", http.MethodPut, "/admin/users/x/role", "alice", RoleRequest{Role: roleMember}, http.StatusBadRequest},
+		{"invalid JSON", http.MethodPut, "/admin/users/4/role", "alice", "{", http.StatusBadRequest},
+		{"wrong method", http.MethodGet, "/admin/users/4/role", "alice", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestTopicModerator(t *testing.T) {
+	ts := newTestServer(t)
+	edit := UpdatePostRequest{Title: "Edited", Content: "By bob"}
+	wantError(t, ts.do(http.MethodPut, "/posts/2", "bob", edit), http.StatusForbidden, "")
+
+	w := ts.do(http.MethodPut, "/topics/2/moderators/4", "alice", nil)
+	wantStatus(t, w, http.StatusOK)
+	if user := decode[User](t, w); !slices.Contains(user.ModeratedTopicIDs, ts.homework.ID) {
+		t.Errorf("bob moderates %v, want Homework", user.ModeratedTopicIDs)
+	}
+	if n := ts.auditCount("user.topic_moderator_add", ts.users["bob"].ID); n != 1 {
+		t.Errorf("%d user.topic_moderator_add audit entries, want 1", n)
+	}
+	// bob moderates carol's post in Homework now
+	wantStatus(t, ts.do(http.MethodPut, "/posts/2", "bob", edit), http.StatusOK)
+
+	w = ts.do(http.MethodDelete, "/topics/2/moderators/4", "alice", nil)
+	wantStatus(t, w, http.StatusOK)
+	if user := decode[User](t, w); len(user.ModeratedTopicIDs) != 0 {
+		t.Errorf("bob moderates %v, want nothing", user.ModeratedTopicIDs)
+	}
+	wantError(t, ts.do(http.MethodPut, "/posts/2", "bob", edit), http.StatusForbidden, "")
+
+	runRequests(t, []request{
+		{"anonymous", http.MethodPut, "/topics/2/moderators/4", "", nil, http.StatusUnauthorized},
+		{"moderator", http.MethodPut, "/topics/2/moderators/4", "mona", nil, http.StatusForbidden},
+		{"topic moderator", http.MethodPut, "/topics/1/moderators/4", "tom", nil, http.StatusForbidden},
+		{"unknown topic", http.MethodPut, "/topics/9999/moderators/4", "alice", nil, http.StatusNotFound},
+		{"unknown user", http.MethodPut, "/topics/2/moderators/9999", "alice", nil, http.StatusNotFound},
+		{"invalid topic id", http.MethodPut, "/topics/x/moderators/4", "alice", nil, http.StatusBadRequest},
+		{"invalid user id", http.MethodPut, "/topics/2/moderators/x", "alice", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodGet, "/topics/2/moderators/4", "alice", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+// TestMemoryRolePermissions checks that the memory store grants each
+// role what the migrations put in role_permissions.
+func TestMemoryRolePermissions(t *testing.T) {
+	db := testDB(t)
+	if testStore == storePostgres {
+		db = emptyPostgres(t)
+	}
+	rows, err := db.Query("SELECT role, permission FROM role_permissions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	granted := map[string][]permission{}
+	for rows.Next() {
+		var role string
+		var perm permission
+		if err := rows.Scan(&role, &perm); err != nil {
+			t.Fatal(err)
+		}
+		granted[role] = append(granted[role], perm)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, role := range []string{roleAdmin, roleModerator, topicModeratorRole, roleMember, roleReadOnly} {
+		want := slices.Sorted(slices.Values(granted[role]))
+		got := slices.Sorted(slices.Values(memoryRolePermissions[role]))
+		if !slices.Equal(got, want) {
+			t.Errorf("memory grants %s %v, migrations grant %v", role, got, want)
+		}
+	}
+	for role := range granted {
+		if _, ok := memoryRolePermissions[role]; !ok {
+			t.Errorf("memory store has no grants for %s", role)
+		}
+	}
+}
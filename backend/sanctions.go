@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Sanction is a ban (no expiry) or temporary suspension of a user.
+// While it is active the user cannot log in or change anything.
+type Sanction struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"userId"`
+	Username  string     `json:"username"`
+	Reason    string     `json:"reason"`
+	IssuedBy  string     `json:"issuedBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// SanctionRequest represents the JSON body for banning or suspending a user.
+type SanctionRequest struct {
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// sanctionMessage is the error shown to a sanctioned user.
+func sanctionMessage(s *Sanction) string {
+	if s.ExpiresAt == nil {
+		return "Your account is banned: " + s.Reason
+	}
+	return fmt.Sprintf("Your account is suspended until %s: %s",
+		s.ExpiresAt.UTC().Format(time.RFC3339), s.Reason)
+}
+
+// requireActiveUser returns the logged-in user, or writes 401 if there is
+// none and 403 if they are banned or suspended. Every handler that changes
+// data starts with it.
+func requireActiveUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if user.Sanction != nil {
+		writeError(w, sanctionMessage(user.Sanction), http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// banHandler handles POST /users/{id}/ban
+// It takes { "reason": "..." } and bans the user until the ban is lifted.
+func (s *server) banHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SanctionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil {
+		writeFieldError(w, "expiresAt", "Bans do not expire; use /suspend for a temporary sanction")
+		return
+	}
+	s.issueSanction(w, r, req)
+}
+
+// suspendHandler handles POST /users/{id}/suspend
+// It takes { "reason": "...", "expiresAt": "2024-01-31T00:00:00Z" } and
+// suspends the user until then.
+func (s *server) suspendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SanctionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	var v validator
+	v.check(req.ExpiresAt != nil, "expiresAt", "Missing expiresAt")
+	v.check(req.ExpiresAt == nil || req.ExpiresAt.After(time.Now()), "expiresAt", "expiresAt must be in the future")
+	if !v.valid(w) {
+		return
+	}
+	s.issueSanction(w, r, req)
+}
+
+// issueSanction does the work of banHandler and suspendHandler once the
+// body has been read.
+func (s *server) issueSanction(w http.ResponseWriter, r *http.Request, req SanctionRequest) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	moderator, ok := s.requirePermission(w, r, permSanctionUsers, 0, "Only moderators can ban or suspend users")
+	if !ok {
+		return
+	}
+
+	var v validator
+	reason := v.text("reason", "Reason", req.Reason, 1, maxReasonLength)
+	if !v.valid(w) {
+		return
+	}
+
+	var issued Sanction
+	err := s.store.Tx(func(tx Store) error {
+		var err error
+		issued, err = sanctionUser(tx, moderator.ID, id, reason, req.ExpiresAt)
+		return err
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to sanction user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(issued); err != nil {
+		writeError(w, "Failed to encode sanction", http.StatusInternalServerError)
+	}
+}
+
+// sanctionUser bans (expiresAt nil) or suspends a user on behalf of a
+// moderator. Moderators cannot sanction each other; their role has to
+// be changed first.
+func sanctionUser(st Store, issuerID, userID int, reason string, expiresAt *time.Time) (Sanction, error) {
+	if _, err := st.User(userID); err == errNotFound {
+		return Sanction{}, &statusError{http.StatusNotFound, "User not found"}
+	} else if err != nil {
+		return Sanction{}, err
+	}
+
+	staff, err := st.Authorize(userID, permSanctionUsers, 0)
+	if err != nil {
+		return Sanction{}, err
+	}
+	if staff {
+		return Sanction{}, &statusError{http.StatusForbidden, "Moderators and admins cannot be banned or suspended"}
+	}
+
+	issued, err := st.CreateSanction(issuerID, userID, reason, expiresAt)
+	if err != nil {
+		return Sanction{}, err
+	}
+	action := "user.ban"
+	if expiresAt != nil {
+		action = "user.suspend"
+	}
+	return issued, st.RecordAudit(issuerID, action, "user", userID, nil, issued)
+}
+
+// sanctionsHandler handles GET /sanctions and lists the bans and
+// suspensions in force, newest first. Only moderators can see them.
+func (s *server) sanctionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := s.requirePermission(w, r, permSanctionUsers, 0, "Only moderators can view sanctions"); !ok {
+		return
+	}
+
+	sanctions, err := s.store.Sanctions()
+	if err != nil {
+		writeError(w, "Failed to query sanctions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sanctions); err != nil {
+		writeError(w, "Failed to encode sanctions", http.StatusInternalServerError)
+	}
+}
+
+// liftSanctionHandler handles DELETE /sanctions/{id} and ends a ban or
+// suspension early. The sanction is kept with who lifted it.
+func (s *server) liftSanctionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	moderator, ok := s.requirePermission(w, r, permSanctionUsers, 0, "Only moderators can lift sanctions")
+	if !ok {
+		return
+	}
+
+	err := s.store.Tx(func(tx Store) error {
+		lifted, err := tx.LiftSanction(id, moderator.ID)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "No active sanction with that id"}
+		}
+		if err != nil {
+			return err
+		}
+		return tx.RecordAudit(moderator.ID, "sanction.lift", "sanction", id, lifted, nil)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to lift sanction")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The fixture users' ids: alice 1, mona 2, tom 3, bob 4, carol 5, rita 6
+// and ben 7, whose ban is sanction 1.
+
+func TestBan(t *testing.T) {
+	ts := newTestServer(t)
+	carol := ts.users["carol"].ID
+	w := ts.do(http.MethodPost, fmt.Sprintf("/users/%d/ban", carol), "mona", SanctionRequest{Reason: "Spam"})
+	wantStatus(t, w, http.StatusCreated)
+	ban := decode[Sanction](t, w)
+	if ban.UserID != carol || ban.IssuedBy != "mona" || ban.ExpiresAt != nil {
+		t.Errorf("sanction = %+v, want mona's ban of carol", ban)
+	}
+	if n := ts.auditCount("user.ban", carol); n != 1 {
+		t.Errorf("%d user.ban audit entries, want 1", n)
+	}
+
+	w = ts.do(http.MethodPost, "/topics/1/posts", "carol", CreatePostRequest{Title: "Still here", Content: "?"})
+	if !strings.Contains(w.Body.String(), "banned: Spam") {
+		t.Errorf("body = %s, want the ban reason", w.Body)
+	}
+	wantError(t, w, http.StatusForbidden, "")
+
+	w = ts.do(http.MethodGet, "/sanctions", "mona", nil)
+	wantStatus(t, w, http.StatusOK)
+	if sanctions := decode[[]Sanction](t, w); len(sanctions) != 2 {
+		t.Errorf("sanctions = %+v, want ben's and carol's", sanctions)
+	}
+
+	wantStatus(t, ts.do(http.MethodDelete, fmt.Sprint("/sanctions/", ban.ID), "alice", nil), http.StatusNoContent)
+	if n := ts.auditCount("sanction.lift", ban.ID); n != 1 {
+		t.Errorf("%d sanction.lift audit entries, want 1", n)
+	}
+	w = ts.do(http.MethodPost, "/topics/1/posts", "carol", CreatePostRequest{Title: "Back", Content: "again"})
+	wantStatus(t, w, http.StatusCreated)
+	// A lifted sanction cannot be lifted again
+	wantError(t, ts.do(http.MethodDelete, fmt.Sprint("/sanctions/", ban.ID), "alice", nil), http.StatusNotFound, "")
+}
+
+func TestSuspend(t *testing.T) {
+	ts := newTestServer(t)
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	w := ts.do(http.MethodPost, "/users/4/suspend", "mona", SanctionRequest{Reason: "Cool off", ExpiresAt: &until})
+	wantStatus(t, w, http.StatusCreated)
+	if s := decode[Sanction](t, w); s.ExpiresAt == nil || !s.ExpiresAt.Equal(until) {
+		t.Errorf("sanction = %+v, want it to expire at %s", s, until)
+	}
+	if n := ts.auditCount("user.suspend", ts.users["bob"].ID); n != 1 {
+		t.Errorf("%d user.suspend audit entries, want 1", n)
+	}
+
+	w = ts.do(http.MethodPut, "/posts/1", "bob", UpdatePostRequest{Title: "Edited", Content: "New content"})
+	if !strings.Contains(w.Body.String(), "suspended until") {
+		t.Errorf("body = %s, want the suspension", w.Body)
+	}
+	wantError(t, w, http.StatusForbidden, "")
+}
+
+func TestSanctionErrors(t *testing.T) {
+	ban := SanctionRequest{Reason: "Spam"}
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	for _, tt := range []struct {
+		name, path string
+		body       SanctionRequest
+		field      string
+	}{
+		{"ban without reason", "/users/5/ban", SanctionRequest{}, "reason"},
+		{"ban with expiry", "/users/5/ban", SanctionRequest{Reason: "Spam", ExpiresAt: &future}, "expiresAt"},
+		{"suspend without reason", "/users/5/suspend", SanctionRequest{ExpiresAt: &future}, "reason"},
+		{"suspend without expiry", "/users/5/suspend", ban, "expiresAt"},
+		{"suspend into the past", "/users/5/suspend", SanctionRequest{Reason: "Spam", ExpiresAt: &past}, "expiresAt"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			wantError(t, ts.do(http.MethodPost, tt.path, "mona", tt.body), http.StatusBadRequest, tt.field)
+		})
+	}
+
+	runRequests(t, []request{
+		{"ban anonymous", http.MethodPost, "/users/5/ban", "", ban, http.StatusUnauthorized},
+		{"ban as member", http.MethodPost, "/users/5/ban", "bob", ban, http.StatusForbidden},
+		{"ban as topic moderator", http.MethodPost, "/users/5/ban", "tom", ban, http.StatusForbidden},
+		{"ban a moderator", http.MethodPost, "/users/2/ban", "alice", ban, http.StatusForbidden},
+		{"ban an admin", http.MethodPost, "/users/1/ban", "mona", ban, http.StatusForbidden},
+		{"ban unknown user", http.MethodPost, "/users/9999/ban", "mona", ban, http.StatusNotFound},
+		{"ban invalid JSON", http.MethodPost, "/users/5/ban", "mona", "{", http.StatusBadRequest},
+		{"ban invalid id", http.MethodPost, "/users/x/ban", "mona", ban, http.StatusBadRequest},
+		{"ban wrong method", http.MethodGet, "/users/5/ban", "mona", nil, http.StatusMethodNotAllowed},
+
+		{"suspend as member", http.MethodPost, "/users/5/suspend", "bob", SanctionRequest{Reason: "Spam", ExpiresAt: &future}, http.StatusForbidden},
+		{"suspend wrong method", http.MethodDelete, "/users/5/suspend", "mona", nil, http.StatusMethodNotAllowed},
+
+		{"list anonymous", http.MethodGet, "/sanctions", "", nil, http.StatusUnauthorized},
+		{"list as member", http.MethodGet, "/sanctions", "bob", nil, http.StatusForbidden},
+		{"list as topic moderator", http.MethodGet, "/sanctions", "tom", nil, http.StatusForbidden},
+		{"list wrong method", http.MethodPost, "/sanctions", "mona", nil, http.StatusMethodNotAllowed},
+
+		{"lift anonymous", http.MethodDelete, "/sanctions/1", "", nil, http.StatusUnauthorized},
+		{"lift as member", http.MethodDelete, "/sanctions/1", "bob", nil, http.StatusForbidden},
+		{"lift unknown sanction", http.MethodDelete, "/sanctions/9999", "mona", nil, http.StatusNotFound},
+		{"lift invalid id", http.MethodDelete, "/sanctions/x", "mona", nil, http.StatusBadRequest},
+		{"lift wrong method", http.MethodGet, "/sanctions/1", "mona", nil, http.StatusMethodNotAllowed},
+	})
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultSearchLimit and maxSearchLimit bound the number of search results.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+// Markers put around matched terms by snippet(). They are swapped for
+// <mark> tags after the rest of the snippet has been HTML-escaped.
+const (
+	snippetOpen  = "\x01"
+	snippetClose = "\x02"
+)
+
+// SearchResult is one hit from GET /search.
+type SearchResult struct {
+	Type    string  `json:"type"`
+	ID      int     `json:"id"`
+	TopicID int     `json:"topicId"`
+	PostID  int     `json:"postId,omitempty"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Author  string  `json:"author,omitempty"`
+	Rank    float64 `json:"rank"`
+}
+
+// searchTerm is a word or quoted phrase of a search query. With prefix,
+// its last word also matches longer words that start with it.
+type searchTerm struct {
+	text   string
+	prefix bool
+}
+
+// SearchQuery is what GET /search asks the store for. Every term must
+// match; Type, Author and TopicID narrow the results down when set.
+type SearchQuery struct {
+	Terms   []searchTerm
+	Type    string
+	Author  string
+	TopicID int
+	Limit   int
+}
+
+// parseSearchTerms splits user input into terms. Quoted text is kept as
+// a phrase, punctuation around other words is dropped, and a trailing *
+// on a word makes it a prefix search.
+func parseSearchTerms(input string) ([]searchTerm, error) {
+	var terms []searchTerm
+	rest := strings.TrimSpace(input)
+	for rest != "" {
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, errors.New("Unterminated quote in search query")
+			}
+			if phrase := strings.TrimSpace(rest[1 : end+1]); phrase != "" {
+				terms = append(terms, searchTerm{text: phrase})
+			}
+			rest = strings.TrimSpace(rest[end+2:])
+			continue
+		}
+
+		end := strings.IndexFunc(rest, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = strings.TrimSpace(rest[end:])
+
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if word != "" {
+			terms = append(terms, searchTerm{text: word, prefix: prefix})
+		}
+	}
+
+	if len(terms) == 0 {
+		return nil, errors.New("Search query has no searchable words")
+	}
+	return terms, nil
+}
+
+// buildMatchQuery turns terms into an FTS5 query. Every term is quoted,
+// so FTS5 operators and punctuation cannot cause syntax errors.
+func buildMatchQuery(terms []searchTerm) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term.text, `"`, `""`) + `"`
+		if term.prefix {
+			quoted[i] += "*"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// buildTSQuery turns terms into a Postgres tsquery: the words of a term
+// follow each other (<->), a prefix term's last word ends in :*, and
+// every term must match (&). Terms are cut into words the way
+// searchTokens does, so only letters and digits reach to_tsquery and
+// its operators cannot be used. It returns "" if no term has a word.
+func buildTSQuery(terms []searchTerm) string {
+	var parts []string
+	for _, term := range terms {
+		tokens := searchTokens(term.text)
+		if len(tokens) == 0 {
+			continue
+		}
+		words := make([]string, len(tokens))
+		for i, t := range tokens {
+			words[i] = t.word
+		}
+		if term.prefix {
+			words[len(words)-1] += ":*"
+		}
+		parts = append(parts, strings.Join(words, " <-> "))
+	}
+	return strings.Join(parts, " & ")
+}
+
+// snippetWords is how many words a snippet shows, as in FTS5's snippet().
+const snippetWords = 16
+
+// searchDocument is a topic, post or comment for searchDocuments: result
+// is filled in but for Snippet and Rank, and fields are the texts to
+// search with their weights, as in the FTS5 tables and bm25 calls.
+type searchDocument struct {
+	result SearchResult
+	fields []searchField
+}
+
+type searchField struct {
+	text   string
+	weight float64
+}
+
+// searchToken is a word of a text, lower case, with its byte offsets.
+type searchToken struct {
+	word       string
+	start, end int
+}
+
+// searchTokens splits text into words the way FTS5's unicode61
+// tokenizer does, minus removing diacritics.
+func searchTokens(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range text + " " {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			tokens = append(tokens, searchToken{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	return tokens
+}
+
+// matchTerm marks the tokens where term matches and returns how many
+// times it does.
+func matchTerm(term searchTerm, tokens []searchToken, marked []bool) int {
+	words := searchTokens(term.text)
+	if len(words) == 0 {
+		return 0
+	}
+	hits := 0
+	for i := 0; i+len(words) <= len(tokens); i++ {
+		match := true
+		for j, w := range words {
+			word := tokens[i+j].word
+			last := j == len(words)-1
+			if word != w.word && !(last && term.prefix && strings.HasPrefix(word, w.word)) {
+				match = false
+				break
+			}
+		}
+		if match {
+			hits++
+			for j := range words {
+				marked[i+j] = true
+			}
+		}
+	}
+	return hits
+}
+
+// searchDocuments does in Go what the FTS5 search does in SQLite, for
+// stores without an index, but without stemming: a document matches if
+// every term is in one of its fields, ranks better (lower) the more
+// weighted hits it has, and gets a snippet from the field with most
+// hits. It returns up to limit results, best first.
+func searchDocuments(terms []searchTerm, docs []searchDocument, limit int) []SearchResult {
+	results := []SearchResult{}
+	for _, doc := range docs {
+		tokens := make([][]searchToken, len(doc.fields))
+		marked := make([][]bool, len(doc.fields))
+		hits := make([]int, len(doc.fields))
+		for i, f := range doc.fields {
+			tokens[i] = searchTokens(f.text)
+			marked[i] = make([]bool, len(tokens[i]))
+		}
+
+		matched := true
+		for _, term := range terms {
+			found := false
+			for i := range doc.fields {
+				n := matchTerm(term, tokens[i], marked[i])
+				hits[i] += n
+				found = found || n > 0
+			}
+			if !found {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		res := doc.result
+		best := 0
+		for i, f := range doc.fields {
+			res.Rank -= f.weight * float64(hits[i])
+			if hits[i] > hits[best] {
+				best = i
+			}
+		}
+		res.Snippet = snippet(doc.fields[best].text, tokens[best], marked[best])
+		results = append(results, res)
+	}
+
+	slices.SortStableFunc(results, func(a, b SearchResult) int { return cmp.Compare(a.Rank, b.Rank) })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// bestSnippet returns a snippet of whichever text the terms match most
+// often, the first on a tie, as FTS5's snippet() does with column -1.
+// Words are matched without stemming, so a text that only matched
+// through a stem gets a snippet from its start with nothing marked.
+func bestSnippet(terms []searchTerm, texts ...string) string {
+	best, bestHits := "", -1
+	for _, text := range texts {
+		tokens := searchTokens(text)
+		marked := make([]bool, len(tokens))
+		hits := 0
+		for _, term := range terms {
+			hits += matchTerm(term, tokens, marked)
+		}
+		if hits > bestHits {
+			best, bestHits = snippet(text, tokens, marked), hits
+		}
+	}
+	return best
+}
+
+// snippet returns up to snippetWords words of text, starting just
+// before the first marked word, with the marked words between
+// snippetOpen and snippetClose and … where text was cut off.
+func snippet(text string, tokens []searchToken, marked []bool) string {
+	if len(tokens) == 0 {
+		return text
+	}
+	first := slices.Index(marked, true)
+	start := max(0, min(first-2, len(tokens)-snippetWords))
+	end := min(len(tokens), start+snippetWords)
+
+	var b strings.Builder
+	pos := 0
+	if start > 0 {
+		b.WriteString("…")
+		pos = tokens[start].start
+	}
+	for i := start; i < end; i++ {
+		t := tokens[i]
+		b.WriteString(text[pos:t.start])
+		if marked[i] {
+			b.WriteString(snippetOpen + text[t.start:t.end] + snippetClose)
+		} else {
+			b.WriteString(text[t.start:t.end])
+		}
+		pos = t.end
+	}
+	if end < len(tokens) {
+		b.WriteString("…")
+	} else {
+		b.WriteString(text[pos:])
+	}
+	return b.String()
+}
+
+// highlightSnippet HTML-escapes a snippet and wraps matches in <mark>.
+func highlightSnippet(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, snippetOpen, "<mark>")
+	return strings.ReplaceAll(s, snippetClose, "</mark>")
+}
+
+// searchHandler handles GET /search?q=...
+// Optional parameters: type (topic, post or comment), author (username,
+// in any case), topicId and limit. Results are ordered by relevance (bm25, best first)
+// and carry a snippet with matches wrapped in <mark>.
+func (s *server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	// Content is stored in NFC, so the query has to be too
+	terms, err := parseSearchTerms(normalize(q.Get("q")))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kind := q.Get("type")
+	if kind != "" && kind != "topic" && kind != "post" && kind != "comment" {
+		writeError(w, "Invalid type parameter", http.StatusBadRequest)
+		return
+	}
+
+	author := normalize(q.Get("author"))
+
+	topicID := 0
+	if value := q.Get("topicId"); value != "" {
+		if topicID, err = strconv.Atoi(value); err != nil {
+			writeError(w, "Invalid topicId parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultSearchLimit
+	if value := q.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			writeError(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxSearchLimit)
+	}
+
+	results, err := s.store.Search(SearchQuery{Terms: terms, Type: kind, Author: author, TopicID: topicID, Limit: limit})
+	if err == errSearchUnavailable {
+		writeError(w, "Search is not available on this server", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		writeError(w, "Failed to run search", http.StatusInternalServerError)
+		return
+	}
+	for i := range results {
+		results[i].Snippet = highlightSnippet(results[i].Snippet)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		writeError(w, "Failed to encode search results", http.StatusInternalServerError)
+	}
+}
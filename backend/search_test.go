@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestParseSearchTerms(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  []searchTerm
+		err   bool
+	}{
+		{"hello world", []searchTerm{{"hello", false}, {"world", false}}, false},
+		{"  hello,   world!  ", []searchTerm{{"hello", false}, {"world", false}}, false},
+		{`"say hello" wel*`, []searchTerm{{"say hello", false}, {"wel", true}}, false},
+		{`a"b c"`, []searchTerm{{"a", false}, {"b c", false}}, false},
+		{`"" hello`, []searchTerm{{"hello", false}}, false},
+		{"hello AND NOT world", []searchTerm{{"hello", false}, {"AND", false}, {"NOT", false}, {"world", false}}, false},
+		{"(hello) -world +x:y", []searchTerm{{"hello", false}, {"world", false}, {"x:y", false}}, false},
+		{`"unterminated`, nil, true},
+		{"", nil, true},
+		{"*** -- ?", nil, true},
+	} {
+		got, err := parseSearchTerms(tt.input)
+		if (err != nil) != tt.err {
+			t.Errorf("parseSearchTerms(%q) error = %v, want error %v", tt.input, err, tt.err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("parseSearchTerms(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestBuildMatchQuery(t *testing.T) {
+	tests := []struct {
+		terms []searchTerm
+		want  string
+	}{
+		{[]searchTerm{{"hello", false}}, `"hello"`},
+		{[]searchTerm{{"say hello", false}, {"wel", true}}, `"say hello" "wel"*`},
+		// FTS5 operators and column filters are plain words once quoted
+		{[]searchTerm{{"hello", false}, {"AND", false}, {"NOT", false}, {"NEAR", false}}, `"hello" "AND" "NOT" "NEAR"`},
+		{[]searchTerm{{"title:hello", false}, {"^start", false}}, `"title:hello" "^start"`},
+		{[]searchTerm{{`say "hi"`, false}}, `"say ""hi"""`},
+	}
+	for _, tt := range tests {
+		if got := buildMatchQuery(tt.terms); got != tt.want {
+			t.Errorf("buildMatchQuery(%v) = %s, want %s", tt.terms, got, tt.want)
+		}
+	}
+
+	// Every query has to be valid FTS5 syntax
+	db := testDB(t)
+	if ready, err := searchIndexReady(db); err != nil || !ready {
+		t.Skip("SQLite was built without FTS5; run with -tags sqlite_fts5")
+	}
+	for _, tt := range tests {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM posts_fts WHERE posts_fts MATCH ?", tt.want).Scan(&count); err != nil {
+			t.Errorf("MATCH %s: %v", tt.want, err)
+		}
+	}
+}
+
+// tsqueryTests are the cases of TestBuildTSQuery, which
+// TestPostgresTSQuery also runs through to_tsquery.
+var tsqueryTests = []struct {
+	terms []searchTerm
+	want  string
+}{
+	{[]searchTerm{{"hello", false}}, "hello"},
+	{[]searchTerm{{"Say Hello", false}, {"wel", true}}, "say <-> hello & wel:*"},
+	{[]searchTerm{{"just a phrase", true}}, "just <-> a <-> phrase:*"},
+	// tsquery operators and weights are dropped with the punctuation
+	{[]searchTerm{{"hello", false}, {"!world", false}, {"a|b", false}, {"x:y", false}}, "hello & world & a <-> b & x <-> y"},
+	{[]searchTerm{{"'quote'", false}, {"(", false}}, "quote"},
+	{[]searchTerm{{"&", false}}, ""},
+}
+
+func TestBuildTSQuery(t *testing.T) {
+	for _, tt := range tsqueryTests {
+		if got := buildTSQuery(tt.terms); got != tt.want {
+			t.Errorf("buildTSQuery(%v) = %q, want %q", tt.terms, got, tt.want)
+		}
+	}
+}
+
+func TestBestSnippet(t *testing.T) {
+	for _, tt := range []struct {
+		terms []searchTerm
+		texts []string
+		want  string
+	}{
+		{[]searchTerm{{"hello", false}}, []string{"Welcome", "Say hello"}, "Say \x01hello\x02"},
+		{[]searchTerm{{"wel", true}}, []string{"Welcome", "Say hello"}, "\x01Welcome\x02"},
+		// A tie goes to the first text, as does a match only by stem
+		{[]searchTerm{{"hello", false}}, []string{"hello", "hello"}, "\x01hello\x02"},
+		{[]searchTerm{{"questions", false}}, []string{"A question", "Another question"}, "A question"},
+		{[]searchTerm{{"x", false}}, []string{"", "x"}, "\x01x\x02"},
+	} {
+		if got := bestSnippet(tt.terms, tt.texts...); got != tt.want {
+			t.Errorf("bestSnippet(%v, %q) = %q, want %q", tt.terms, tt.texts, got, tt.want)
+		}
+	}
+}
+
+// searchFor runs GET /search with the parameters and returns the
+// results, skipping the test if the store cannot search.
+func searchFor(t *testing.T, ts *testServer, params url.Values) []SearchResult {
+	t.Helper()
+	w := ts.do(http.MethodGet, "/search?"+params.Encode(), "", nil)
+	if w.Code == http.StatusNotImplemented {
+		t.Skip("SQLite was built without FTS5; run with -tags sqlite_fts5")
+	}
+	wantStatus(t, w, http.StatusOK)
+	return decode[[]SearchResult](t, w)
+}
+
+func TestSearch(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		params url.Values
+		want   []string
+	}{
+		{"words", url.Values{"q": {"hello"}}, []string{"comment 1", "post 1"}},
+		{"all words", url.Values{"q": {"say hello"}}, []string{"post 1"}},
+		{"phrase", url.Values{"q": {`"hello say"`}}, []string{}},
+		{"prefix", url.Values{"q": {"hel*"}}, []string{"comment 1", "post 1"}},
+		{"topic", url.Values{"q": {"assignments"}}, []string{"topic 2"}},
+		{"operators are words", url.Values{"q": {"hello OR welcome"}}, []string{}},
+		{"type", url.Values{"q": {"hello"}, "type": {"comment"}}, []string{"comment 1"}},
+		{"author", url.Values{"q": {"hello"}, "author": {"bob"}}, []string{"post 1"}},
+		{"author in another case", url.Values{"q": {"hello"}, "author": {"BoB"}}, []string{"post 1"}},
+		{"comment author in another case", url.Values{"q": {"hello"}, "author": {"Carol"}}, []string{"comment 1"}},
+		{"topicId", url.Values{"q": {"hello"}, "topicId": {"2"}}, []string{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			got := []string{}
+			for _, res := range searchFor(t, ts, tt.params) {
+				got = append(got, fmt.Sprint(res.Type, " ", res.ID))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("limit", func(t *testing.T) {
+		ts := newTestServer(t)
+		if results := searchFor(t, ts, url.Values{"q": {"hello"}, "limit": {"1"}}); len(results) != 1 {
+			t.Errorf("results = %+v, want one", results)
+		}
+	})
+
+	runRequests(t, []request{
+		{"no query", http.MethodGet, "/search", "", nil, http.StatusBadRequest},
+		{"unterminated quote", http.MethodGet, "/search?q=%22hello", "", nil, http.StatusBadRequest},
+		{"no words", http.MethodGet, "/search?q=!!!", "", nil, http.StatusBadRequest},
+		{"invalid type", http.MethodGet, "/search?q=hello&type=user", "", nil, http.StatusBadRequest},
+		{"invalid topicId", http.MethodGet, "/search?q=hello&topicId=general", "", nil, http.StatusBadRequest},
+		{"invalid limit", http.MethodGet, "/search?q=hello&limit=0", "", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/search?q=hello", "bob", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestSearchSnippet(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodPost, fmt.Sprintf("/posts/%d/comments", ts.post.ID), "carol", CreateCommentRequest{Content: "<b>bold</b> & hello"})
+	wantStatus(t, w, http.StatusCreated)
+	created := decode[Comment](t, w)
+
+	results := searchFor(t, ts, url.Values{"q": {"bold"}})
+	if len(results) != 1 || results[0].ID != created.ID {
+		t.Fatalf("results = %+v, want comment %d", results, created.ID)
+	}
+	if want := "&lt;b&gt;<mark>bold</mark>&lt;/b&gt; &amp; hello"; results[0].Snippet != want {
+		t.Errorf("snippet = %q, want %q", results[0].Snippet, want)
+	}
+}
+
+func TestSearchTrash(t *testing.T) {
+	ts := newTestServer(t)
+	wantStatus(t, ts.do(http.MethodDelete, fmt.Sprint("/posts/", ts.post.ID), "bob", nil), http.StatusNoContent)
+
+	// The post is in the trash, and its comment with it
+	if results := searchFor(t, ts, url.Values{"q": {"hello"}}); len(results) != 0 {
+		t.Errorf("results = %+v, want none", results)
+	}
+
+	w := ts.do(http.MethodPost, "/trash/restore", "mona", RestoreRequest{Type: "post", ID: ts.post.ID})
+	wantStatus(t, w, http.StatusOK)
+	if results := searchFor(t, ts, url.Values{"q": {"hello"}}); len(results) != 2 {
+		t.Errorf("results = %+v, want the post and its comment", results)
+	}
+}
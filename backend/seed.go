@@ -0,0 +1,158 @@
+package main
+
+import "database/sql"
+
+// seedPosts are the sample posts. They name their topic and author,
+// which seeding looks up rather than assuming their ids.
+var seedPosts = []struct {
+	topic, author, title, content string
+	pinned                        bool
+}{
+	{"General", "alice", "Welcome to the forum", "Introduce yourself and say hi!", true},
+	{"General", "bob", "General chat", "Talk about anything not related to homework.", false},
+	{"Homework", "alice", "Math homework question", "I am stuck on question 3 of the worksheet.", false},
+	{"Homework", "bob", "Project deadline reminder", "Don't forget the assignment is due next week.", false},
+}
+
+// seedComments are the sample comments, on the seedPosts with the given
+// titles. parent is the index of the comment replied to, or -1.
+var seedComments = []struct {
+	post, author, content string
+	parent                int
+	pinned                bool
+}{
+	{"Welcome to the forum", "alice", "Hello everyone!", -1, true},
+	{"Welcome to the forum", "bob", "Nice to meet you all.", -1, false},
+	{"General chat", "bob", "I love random chats.", -1, false},
+	{"Math homework question", "alice", "Same, I'm also stuck on that question.", -1, false},
+	{"Project deadline reminder", "bob", "Thanks for the reminder!", -1, false},
+	{"Math homework question", "bob", "Try factoring the left-hand side first.", 3, false},
+}
+
+// seedIDs looks up the id of every name with query, which selects the
+// id of one row by name. It returns nil if any of them is missing, so
+// sample content is left out rather than given to the wrong user or
+// topic when the sample users or topics have been removed or renamed.
+func seedIDs(tx *transaction, query string, names ...string) (map[string]int, error) {
+	ids := make(map[string]int, len(names))
+	for _, name := range names {
+		var id int
+		err := tx.QueryRow(query, name).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids[name] = id
+	}
+	return ids, nil
+}
+
+// seedTables inserts some default data into every table that is empty.
+// It backs sqlStore.Seed, which is run by the "seed" command and on
+// start with the seed setting.
+func seedTables(tx *transaction) error {
+	// Seed users if empty
+	var userCount int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount); err != nil {
+		return err
+	}
+	if userCount == 0 {
+		// alice is the admin, bob is a member, both use seedPassword
+		hash, err := hashPassword(seedPassword)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO users (username, password_hash, role) VALUES
+				('alice', ?, 'admin'),
+				('bob', ?, 'member');
+		`, hash, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Seed topics if empty
+	var topicCount int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM topics").Scan(&topicCount); err != nil {
+		return err
+	}
+	if topicCount == 0 {
+		_, err := tx.Exec(`
+			INSERT INTO topics (title, description) VALUES
+				('General', 'General discussion'),
+				('Homework', 'Ask about assignments');
+		`)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Seed posts if empty, by the seeded users in the seeded topics
+	var postCount int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM posts").Scan(&postCount); err != nil {
+		return err
+	}
+	if postCount == 0 {
+		users, err := seedIDs(tx, "SELECT id FROM users WHERE username = ?", "alice", "bob")
+		if err != nil {
+			return err
+		}
+		topics, err := seedIDs(tx, "SELECT id FROM topics WHERE title = ? ORDER BY id LIMIT 1", "General", "Homework")
+		if err != nil {
+			return err
+		}
+		if users != nil && topics != nil {
+			for _, p := range seedPosts {
+				_, err := tx.Exec(
+					"INSERT INTO posts (topic_id, user_id, title, content, is_pinned) VALUES (?, ?, ?, ?, ?)",
+					topics[p.topic], users[p.author], p.title, p.content, boolToInt(p.pinned),
+				)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Seed comments if empty, on the seeded posts
+	var commentCount int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM comments").Scan(&commentCount); err != nil {
+		return err
+	}
+	if commentCount == 0 {
+		users, err := seedIDs(tx, "SELECT id FROM users WHERE username = ?", "alice", "bob")
+		if err != nil {
+			return err
+		}
+		titles := make([]string, len(seedPosts))
+		for i, p := range seedPosts {
+			titles[i] = p.title
+		}
+		posts, err := seedIDs(tx, "SELECT id FROM posts WHERE title = ? ORDER BY id LIMIT 1", titles...)
+		if err != nil {
+			return err
+		}
+		if users != nil && posts != nil {
+			ids := make([]int, len(seedComments))
+			for i, c := range seedComments {
+				var parentID *int
+				depth := 0
+				if c.parent >= 0 {
+					parentID, depth = &ids[c.parent], 1
+				}
+				ids[i], err = tx.dialect.insertID(tx,
+					"INSERT INTO comments (post_id, parent_id, depth, user_id, content, is_pinned) VALUES (?, ?, ?, ?, ?, ?)",
+					posts[c.post], parentID, depth, users[c.author], c.content, boolToInt(c.pinned),
+				)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
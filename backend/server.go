@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// Where the server can keep its data; see the store setting.
+const (
+	storeSQLite   = "sqlite"
+	storePostgres = "postgres"
+	storeMemory   = "memory"
+)
+
+// server holds what the handlers share. Everything they keep goes
+// through the store, so they work the same on every backend.
+type server struct {
+	config        Config
+	store         Store
+	events        *eventBus
+	sessionSecret []byte
+}
+
+func newServer(cfg Config, store Store, sessionSecret []byte) *server {
+	return &server{
+		config:        cfg,
+		store:         store,
+		events:        newEventBus(),
+		sessionSecret: sessionSecret,
+	}
+}
+
+// openStore opens the store the configuration asks for. For SQLite and
+// Postgres it applies pending migrations and also returns the database,
+// which the caller closes.
+func openStore(cfg Config) (Store, *database, error) {
+	if cfg.Store == storeMemory {
+		return newMemoryStore(), nil, nil
+	}
+	db, err := initDatabase(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newSQLStore(db), db, nil
+}
+
+// routes registers the handlers with CORS, session and rate limit wrappers.
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	limits := s.config.RateLimits
+	votes := newRateLimiter(limits.Votes)
+	// Creating is limited apart from editing and deleting, and the
+	// deprecated aliases go through the same limited handlers
+	edits := newRateLimiter(limits.Edits)
+	topicPosts := withRateLimit(newRateLimiter(limits.Posts), s.topicPostsHandler, http.MethodPost)
+	post := s.withEditLimit(edits, "post", s.postHandler)
+	postComments := withRateLimit(newRateLimiter(limits.Comments), s.postCommentsHandler, http.MethodPost)
+	comment := s.withEditLimit(edits, "comment", s.commentHandler)
+
+	mux.HandleFunc("/health", s.withCORS(healthHandler))
+	mux.HandleFunc("/register", s.withCORS(withRateLimit(newRateLimiter(limits.Register), s.registerHandler)))
+	mux.HandleFunc("/login", s.withCORS(withRateLimit(newRateLimiter(limits.Login), s.loginHandler)))
+	mux.HandleFunc("/logout", s.withCORS(s.logoutHandler))
+	mux.HandleFunc("/me", s.withCORS(s.withSession(s.meHandler)))
+	mux.HandleFunc("/topics", s.withCORS(s.withSession(s.topicsHandler)))
+	mux.HandleFunc("/topics/archive", s.withCORS(s.withSession(s.archiveTopicHandler)))
+	mux.HandleFunc("/topics/{id}/posts", s.withCORS(s.withSession(topicPosts)))
+	mux.HandleFunc("/posts/{id}", s.withCORS(s.withSession(post)))
+	mux.HandleFunc("/posts/{id}/pin", s.withCORS(s.withSession(s.pinPostHandler)))
+	mux.HandleFunc("/posts/{id}/comments", s.withCORS(s.withSession(postComments)))
+	mux.HandleFunc("/comments/{id}", s.withCORS(s.withSession(comment)))
+	mux.HandleFunc("/comments/{id}/pin", s.withCORS(s.withSession(s.pinCommentHandler)))
+	mux.HandleFunc("GET /posts/{id}/revisions", s.withCORS(s.postRevisionsHandler))
+	mux.HandleFunc("GET /comments/{id}/revisions", s.withCORS(s.commentRevisionsHandler))
+	mux.HandleFunc("/posts/{id}/vote", s.withCORS(s.withSession(withRateLimit(votes, s.postVoteHandler))))
+	mux.HandleFunc("/comments/{id}/vote", s.withCORS(s.withSession(withRateLimit(votes, s.commentVoteHandler))))
+	mux.HandleFunc("/posts/{id}/answer", s.withCORS(s.withSession(s.acceptedAnswerHandler)))
+	mux.HandleFunc("/admin/users", s.withCORS(s.withSession(s.adminUsersHandler)))
+	mux.HandleFunc("/admin/users/{id}/role", s.withCORS(s.withSession(s.userRoleHandler)))
+	mux.HandleFunc("/topics/{id}/moderators/{userId}", s.withCORS(s.withSession(s.topicModeratorHandler)))
+	mux.HandleFunc("/users/{id}/ban", s.withCORS(s.withSession(s.banHandler)))
+	mux.HandleFunc("/users/{id}/suspend", s.withCORS(s.withSession(s.suspendHandler)))
+	mux.HandleFunc("/sanctions", s.withCORS(s.withSession(s.sanctionsHandler)))
+	mux.HandleFunc("/sanctions/{id}", s.withCORS(s.withSession(s.liftSanctionHandler)))
+	mux.HandleFunc("/reports", s.withCORS(s.withSession(withRateLimit(newRateLimiter(limits.Reports), s.reportsHandler))))
+	mux.HandleFunc("/reports/{id}/resolve", s.withCORS(s.withSession(s.resolveReportHandler)))
+	mux.HandleFunc("/audit", s.withCORS(s.withSession(s.auditHandler)))
+	mux.HandleFunc("/notifications", s.withCORS(s.withSession(s.notificationsHandler)))
+	mux.HandleFunc("/notifications/unread-count", s.withCORS(s.withSession(s.unreadCountHandler)))
+	mux.HandleFunc("/notifications/read", s.withCORS(s.withSession(s.markReadHandler)))
+	mux.HandleFunc("/trash", s.withCORS(s.withSession(s.trashHandler)))
+	mux.HandleFunc("/trash/restore", s.withCORS(s.withSession(s.restoreHandler)))
+	mux.HandleFunc("/search", s.withCORS(s.searchHandler))
+	mux.HandleFunc("/events", s.withCORS(s.eventsHandler))
+
+	// Deprecated aliases that take ids in the query string or the body,
+	// kept for clients written against them
+	mux.HandleFunc("/posts", s.withCORS(deprecated(s.withSession(postsHandler(topicPosts, post)))))
+	mux.HandleFunc("/posts/pin", s.withCORS(deprecated(s.withSession(s.legacyPinPostHandler))))
+	mux.HandleFunc("/comments", s.withCORS(deprecated(s.withSession(commentsHandler(postComments, comment)))))
+	mux.HandleFunc("/comments/pin", s.withCORS(deprecated(s.withSession(s.legacyPinCommentHandler))))
+
+	return mux
+}
+
+// withCORS is a small wrapper that adds CORS headers to responses.
+func (s *server) withCORS(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("Request", "method", r.Method, "path", r.URL.Path)
+
+		// Only the configured origins may read responses from a browser
+		if origin := s.config.allowOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if origin != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		// Handle preflight OPTIONS requests
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// Normal request
+		handler(w, r)
+	}
+}
+
+// deprecated is a small wrapper that marks the responses of a deprecated
+// route with a Deprecation header.
+func deprecated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		handler(w, r)
+	}
+}
+
+// idFromQuery copies the id a deprecated route takes as a query parameter
+// into the {id} path parameter, writing 400 if it is missing or not a
+// number.
+func idFromQuery(w http.ResponseWriter, r *http.Request, param string) bool {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		writeError(w, "Missing "+param+" parameter", http.StatusBadRequest)
+		return false
+	}
+	if _, err := strconv.Atoi(value); err != nil {
+		writeError(w, "Invalid "+param+" parameter", http.StatusBadRequest)
+		return false
+	}
+	r.SetPathValue("id", value)
+	return true
+}
+
+// idFromBody copies the id a deprecated route takes in the given field of
+// the JSON body into the {id} path parameter, writing 400 if it is
+// missing. The body can still be read afterwards.
+func idFromBody(w http.ResponseWriter, r *http.Request, field string) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, "Failed to read body", http.StatusBadRequest)
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return false
+	}
+	var id int
+	if raw, ok := fields[field]; ok {
+		if err := json.Unmarshal(raw, &id); err != nil {
+			writeError(w, "Invalid JSON body", http.StatusBadRequest)
+			return false
+		}
+	}
+	if id == 0 {
+		writeFieldError(w, field, "Missing "+field)
+		return false
+	}
+	r.SetPathValue("id", strconv.Itoa(id))
+	return true
+}
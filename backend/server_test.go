@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testDatabases numbers the in-memory databases so no two tests share one.
+var testDatabases atomic.Int64
+
+// testDB opens a fresh in-memory SQLite database with every migration
+// applied. It lives until the test ends.
+func testDB(t *testing.T) *database {
+	t.Helper()
+	db := emptyTestDB(t)
+	if err := migrateUp(db, latestMigration(db), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// emptyTestDB opens a fresh in-memory SQLite database with no schema.
+func emptyTestDB(t *testing.T) *database {
+	t.Helper()
+	// Every connection of the pool has to see the same database
+	name := fmt.Sprintf("forum-test-%d", testDatabases.Add(1))
+	dsn := strings.Replace(databaseDSN(name), "?", "?mode=memory&cache=shared&", 1)
+	sqlDB, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &database{DB: sqlDB, dialect: sqliteDialect}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// fixturePassword is the password of every fixture user.
+const fixturePassword = "fixture-password"
+
+// fixtures is the data every handler test starts from:
+//   - users alice (admin), mona (moderator), tom (member, moderator of
+//     General), bob and carol (members), rita (readonly) and ben (a
+//     banned member), each with a session
+//   - topics General, Homework and Archived, which is archived
+//   - bob's post in General with carol's comment on it, carol's post in
+//     Homework, and bob's post in Archived
+//
+// They are created in the same order every time, so their ids are the
+// same on every test server.
+type fixtures struct {
+	users  map[string]User
+	tokens map[string]string
+
+	general, homework, archived Topic
+
+	post, homeworkPost, archivedPost Post
+	comment                          Comment
+}
+
+// token returns the session token of a fixture user, or "" for anonymous.
+func (fx *fixtures) token(username string) string {
+	return fx.tokens[username]
+}
+
+// testServer is a server on a fresh store, loaded with fixtures.
+type testServer struct {
+	*server
+	t       *testing.T
+	handler http.Handler
+	fixtures
+}
+
+// testStores are the store backends the suite runs on. TestMain runs
+// every test once for each, with testStore set to the one in use. With
+// FORUM_TEST_POSTGRES_URL set it runs on Postgres as well.
+var (
+	testStores = []string{storeSQLite, storeMemory}
+	testStore  string
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv("FORUM_TEST_POSTGRES_URL") != "" {
+		testStores = append(testStores, storePostgres)
+	}
+	code := 0
+	for _, testStore = range testStores {
+		fmt.Println("Running with the", testStore, "store")
+		code = max(code, m.Run())
+	}
+	os.Exit(code)
+}
+
+// newTestServer starts a server on a fresh store of the backend being
+// tested, loaded with the fixtures.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	var store Store
+	switch testStore {
+	case storeMemory:
+		store = newMemoryStore()
+	case storePostgres:
+		store = newSQLStore(emptyPostgres(t))
+	default:
+		store = newSQLStore(testDB(t))
+	}
+	s := newServer(defaultConfig(), store, []byte("test secret"))
+	ts := &testServer{server: s, t: t, handler: s.routes()}
+	ts.loadFixtures()
+	return ts
+}
+
+// loadFixtures creates the fixtures through the store.
+func (ts *testServer) loadFixtures() {
+	t := ts.t
+	t.Helper()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The lowest cost keeps the tests fast; login still checks the hash
+	hash, err := bcrypt.GenerateFromPassword([]byte(fixturePassword), bcrypt.MinCost)
+	must(err)
+
+	fx := &ts.fixtures
+	fx.users = map[string]User{}
+	fx.tokens = map[string]string{}
+	for _, u := range []struct{ name, role string }{
+		{"alice", roleAdmin},
+		{"mona", roleModerator},
+		{"tom", roleMember},
+		{"bob", roleMember},
+		{"carol", roleMember},
+		{"rita", roleReadOnly},
+		{"ben", roleMember},
+	} {
+		user, err := ts.store.CreateUser(u.name, string(hash), u.role)
+		must(err)
+		fx.users[u.name] = user
+		fx.tokens[u.name], _, err = ts.createSession(ts.store, user.ID)
+		must(err)
+	}
+
+	fx.general, err = ts.store.CreateTopic("General", "Anything goes")
+	must(err)
+	fx.homework, err = ts.store.CreateTopic("Homework", "Ask about assignments")
+	must(err)
+	fx.archived, err = ts.store.CreateTopic("Archived", "Read only")
+	must(err)
+	must(ts.store.SetTopicModerator(fx.general.ID, fx.users["tom"].ID, true))
+
+	fx.post, err = ts.store.CreatePost(fx.general.ID, fx.users["bob"].ID, "Welcome", "Say hello")
+	must(err)
+	fx.comment, err = ts.store.CreateComment(fx.post.ID, nil, 0, fx.users["carol"].ID, "Hello!")
+	must(err)
+	fx.homeworkPost, err = ts.store.CreatePost(fx.homework.ID, fx.users["carol"].ID, "Problem set 1", "Question 3?")
+	must(err)
+	fx.archivedPost, err = ts.store.CreatePost(fx.archived.ID, fx.users["bob"].ID, "Old news", "From last term")
+	must(err)
+	fx.archived, err = ts.store.ArchiveTopic(fx.archived.ID, true)
+	must(err)
+
+	_, err = ts.store.CreateSanction(fx.users["alice"].ID, fx.users["ben"].ID, "spam", nil)
+	must(err)
+}
+
+// do sends a request to the server as the fixture user with the given
+// name ("" for anonymous). A body that is not a string is sent as JSON.
+func (ts *testServer) do(method, path, username string, body any) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	if token := ts.token(username); token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, r)
+	return w
+}
+
+// wantStatus fails the test if the response does not have the status.
+func wantStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, status, w.Body)
+	}
+}
+
+// decode reads the JSON body of a response.
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	return v
+}
+
+// wantError fails the test unless the response is an error with the
+// status or, if field is not "", a validation error for that field.
+func wantError(t *testing.T, w *httptest.ResponseRecorder, status int, field string) {
+	t.Helper()
+	wantStatus(t, w, status)
+	body := decode[APIError](t, w)
+	code := errorCode(status)
+	if field != "" {
+		code = "validation_failed"
+		if body.Fields[field] == "" {
+			t.Errorf("no message for field %q in %+v", field, body)
+		}
+	}
+	if body.Code != code {
+		t.Errorf("code = %q, want %q", body.Code, code)
+	}
+}
+
+// request is one row of a table of requests and the status they get.
+type request struct {
+	name     string
+	method   string
+	path     string
+	username string
+	body     any
+	status   int
+}
+
+// runRequests sends each request to a fresh server and checks its status.
+// For errors it also checks the error body.
+func runRequests(t *testing.T, requests []request) {
+	t.Helper()
+	for _, req := range requests {
+		t.Run(req.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			w := ts.do(req.method, req.path, req.username, req.body)
+			if req.status >= 400 {
+				wantError(t, w, req.status, "")
+			} else {
+				wantStatus(t, w, req.status)
+			}
+		})
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodGet, "/health", "", nil)
+	wantStatus(t, w, http.StatusOK)
+}
+
+func TestCORS(t *testing.T) {
+	ts := newTestServer(t)
+
+	w := ts.do(http.MethodOptions, "/posts", "", nil)
+	wantStatus(t, w, http.StatusNoContent)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+
+	ts.config.AllowedOrigins = []string{"http://localhost:5173"}
+	r := httptest.NewRequest(http.MethodGet, "/topics", nil)
+	r.Header.Set("Origin", "http://evil.example")
+	w = httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin for another origin = %q, want none", got)
+	}
+}
+
+func TestDeprecatedRoutes(t *testing.T) {
+	id := map[string]int{"id": 1}
+	for _, tt := range []struct {
+		name     string
+		method   string
+		path     string
+		username string
+		body     any
+		status   int
+	}{
+		{"list posts", http.MethodGet, "/posts?topicId=1", "", nil, http.StatusOK},
+		{"create post", http.MethodPost, "/posts", "bob", CreatePostRequest{TopicID: 1, Title: "Hi", Content: "there"}, http.StatusCreated},
+		{"update post", http.MethodPut, "/posts", "bob", UpdatePostRequest{ID: 1, Title: "Edited", Content: "New content"}, http.StatusOK},
+		{"delete post", http.MethodDelete, "/posts", "bob", id, http.StatusNoContent},
+		{"pin post", http.MethodPost, "/posts/pin", "tom", PinPostRequest{ID: 1, Pinned: true}, http.StatusOK},
+		{"unpin post", http.MethodPost, "/posts/pin", "tom", PinPostRequest{ID: 1}, http.StatusOK},
+		{"list comments", http.MethodGet, "/comments?postId=1", "", nil, http.StatusOK},
+		{"create comment", http.MethodPost, "/comments", "bob", CreateCommentRequest{PostID: 1, Content: "Hi"}, http.StatusCreated},
+		{"update comment", http.MethodPut, "/comments", "carol", UpdateCommentRequest{ID: 1, Content: "Edited"}, http.StatusOK},
+		{"delete comment", http.MethodDelete, "/comments", "carol", id, http.StatusNoContent},
+		{"pin comment", http.MethodPost, "/comments/pin", "tom", PinCommentRequest{ID: 1, Pinned: true}, http.StatusOK},
+		{"unknown post", http.MethodPut, "/posts", "alice", UpdatePostRequest{ID: 9999, Title: "Edited", Content: "New content"}, http.StatusNotFound},
+		{"missing topicId", http.MethodGet, "/posts", "", nil, http.StatusBadRequest},
+		{"invalid topicId", http.MethodGet, "/posts?topicId=x", "", nil, http.StatusBadRequest},
+		{"missing postId", http.MethodGet, "/comments", "", nil, http.StatusBadRequest},
+		{"invalid JSON", http.MethodDelete, "/posts", "bob", "{", http.StatusBadRequest},
+		{"wrong method", http.MethodGet, "/posts/pin", "alice", nil, http.StatusMethodNotAllowed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			w := ts.do(tt.method, tt.path, tt.username, tt.body)
+			if w.Header().Get("Deprecation") != "true" {
+				t.Error("no Deprecation header")
+			}
+			if tt.status >= 400 {
+				wantError(t, w, tt.status, "")
+			} else {
+				wantStatus(t, w, tt.status)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		method, path, field string
+	}{
+		{http.MethodPost, "/posts", "topicId"},
+		{http.MethodPut, "/posts", "id"},
+		{http.MethodDelete, "/posts", "id"},
+		{http.MethodPost, "/posts/pin", "id"},
+		{http.MethodPost, "/comments", "postId"},
+		{http.MethodPut, "/comments", "id"},
+		{http.MethodDelete, "/comments", "id"},
+		{http.MethodPost, "/comments/pin", "id"},
+	} {
+		t.Run(tt.method+" "+tt.path+" without "+tt.field, func(t *testing.T) {
+			ts := newTestServer(t)
+			wantError(t, ts.do(tt.method, tt.path, "alice", map[string]any{}), http.StatusBadRequest, tt.field)
+		})
+	}
+
+	// An alias and its route count against the same limit
+	t.Run("shared rate limit", func(t *testing.T) {
+		ts := newTestServer(t)
+		limit := ts.config.RateLimits.Posts.requests
+		for i := range limit {
+			path, body := "/topics/1/posts", any(CreatePostRequest{Title: fmt.Sprint("Post ", i), Content: "there"})
+			if i%2 == 1 {
+				path, body = "/posts", CreatePostRequest{TopicID: 1, Title: fmt.Sprint("Post ", i), Content: "there"}
+			}
+			wantStatus(t, ts.do(http.MethodPost, path, "bob", body), http.StatusCreated)
+		}
+		w := ts.do(http.MethodPost, "/posts", "bob", CreatePostRequest{TopicID: 1, Title: "One more", Content: "there"})
+		wantError(t, w, http.StatusTooManyRequests, "")
+	})
+}
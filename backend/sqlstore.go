@@ -0,0 +1,1600 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// sqlStore is the Store on a SQL database, SQLite or Postgres. Inside
+// Tx, q is the transaction; otherwise it is db.
+type sqlStore struct {
+	db *database
+	q  querier
+}
+
+func newSQLStore(db *database) *sqlStore {
+	return &sqlStore{db: db, q: db}
+}
+
+// Tx runs fn in a transaction. Inside a transaction it just runs fn,
+// so the changes are kept or discarded with the outer one.
+func (s *sqlStore) Tx(fn func(tx Store) error) error {
+	if _, ok := s.q.(*transaction); ok {
+		return fn(s)
+	}
+	return withTx(s.db, func(tx *transaction) error {
+		return fn(&sqlStore{db: s.db, q: tx})
+	})
+}
+
+// Seed inserts the sample data into every table that is empty.
+func (s *sqlStore) Seed() error {
+	return withTx(s.db, seedTables)
+}
+
+// notFound turns sql.ErrNoRows into errNotFound.
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+	return err
+}
+
+// insertID runs an INSERT and returns the id of the new row.
+func (s *sqlStore) insertID(query string, args ...any) (int, error) {
+	return s.db.dialect.insertID(s.q, query, args...)
+}
+
+// ---- topics ----
+
+// loadTopic reads a single topic by id.
+func loadTopic(q querier, id int) (Topic, error) {
+	t := Topic{ID: id}
+	err := q.QueryRow("SELECT title, description, is_archived FROM topics WHERE id = ?", id).
+		Scan(&t.Title, &t.Description, &t.IsArchived)
+	return t, err
+}
+
+func (s *sqlStore) Topics() ([]Topic, error) {
+	rows, err := s.q.Query("SELECT id, title, description, is_archived FROM topics ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var topics []Topic
+	for rows.Next() {
+		var t Topic
+		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.IsArchived); err != nil {
+			return nil, err
+		}
+		topics = append(topics, t)
+	}
+	return topics, rows.Err()
+}
+
+func (s *sqlStore) Topic(id int) (Topic, error) {
+	t, err := loadTopic(s.q, id)
+	return t, notFound(err)
+}
+
+func (s *sqlStore) CreateTopic(title, description string) (Topic, error) {
+	id, err := s.insertID(
+		"INSERT INTO topics (title, description, is_archived) VALUES (?, ?, 0)",
+		title, description,
+	)
+	if err != nil {
+		return Topic{}, err
+	}
+	return s.Topic(id)
+}
+
+func (s *sqlStore) UpdateTopic(id int, title, description string) (Topic, error) {
+	if _, err := s.q.Exec(
+		"UPDATE topics SET title = ?, description = ? WHERE id = ?",
+		title, description, id,
+	); err != nil {
+		return Topic{}, err
+	}
+	return s.Topic(id)
+}
+
+func (s *sqlStore) ArchiveTopic(id int, archived bool) (Topic, error) {
+	if _, err := s.q.Exec(
+		"UPDATE topics SET is_archived = ? WHERE id = ?",
+		boolToInt(archived), id,
+	); err != nil {
+		return Topic{}, err
+	}
+	return s.Topic(id)
+}
+
+func (s *sqlStore) DeleteTopic(id int) error {
+	// Children before parents, so foreign keys hold at every step:
+	// votes and edit history, then comments, posts, the topic's
+	// reports and moderators and finally the topic.
+	statements := []string{
+		`DELETE FROM votes WHERE target_type = 'comment' AND target_id IN (
+			SELECT comments.id FROM comments
+			JOIN posts ON comments.post_id = posts.id
+			WHERE posts.topic_id = ?
+		)`,
+		"DELETE FROM votes WHERE target_type = 'post' AND target_id IN (SELECT id FROM posts WHERE topic_id = ?)",
+		`DELETE FROM comment_revisions WHERE comment_id IN (
+			SELECT comments.id FROM comments
+			JOIN posts ON comments.post_id = posts.id
+			WHERE posts.topic_id = ?
+		)`,
+		"DELETE FROM post_revisions WHERE post_id IN (SELECT id FROM posts WHERE topic_id = ?)",
+		"DELETE FROM notifications WHERE post_id IN (SELECT id FROM posts WHERE topic_id = ?)",
+		"DELETE FROM comments WHERE post_id IN (SELECT id FROM posts WHERE topic_id = ?)",
+		"DELETE FROM posts WHERE topic_id = ?",
+		"DELETE FROM reports WHERE topic_id = ?",
+		"DELETE FROM topic_moderators WHERE topic_id = ?",
+		"DELETE FROM topics WHERE id = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := s.q.Exec(stmt, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---- posts ----
+
+// postScore is the sum of the votes on a post.
+const postScore = "(SELECT COALESCE(SUM(value), 0) FROM votes WHERE target_type = 'post' AND target_id = posts.id)"
+
+// postAcceptedComment is the accepted answer of a post, or NULL if there
+// is none or it has been moved to the trash.
+const postAcceptedComment = `(SELECT comments.id FROM comments
+	WHERE comments.id = posts.accepted_comment_id AND comments.deleted_at IS NULL)`
+
+// postColumns selects the fields of a Post; use with scanPost.
+// MyVote depends on the caller and is filled in by setMyPostVotes.
+const postColumns = `
+	SELECT posts.id, posts.topic_id, posts.title, posts.content, users.username,
+		posts.is_pinned, ` + postScore + `, ` + postAcceptedComment + `,
+		posts.created_at, posts.updated_at`
+
+// scanPost reads a row selected with postColumns, followed by any extra columns.
+func scanPost(row rowScanner, extra ...any) (Post, error) {
+	var p Post
+	var acceptedID sql.NullInt64
+	var updatedAt sql.NullTime
+	dests := append([]any{&p.ID, &p.TopicID, &p.Title, &p.Content, &p.Author,
+		&p.IsPinned, &p.Score, &acceptedID, &p.CreatedAt, &updatedAt}, extra...)
+	if err := row.Scan(dests...); err != nil {
+		return p, err
+	}
+	p.ContentHTML = renderMarkdown(p.Content)
+	if acceptedID.Valid {
+		id := int(acceptedID.Int64)
+		p.AcceptedCommentID = &id
+		p.IsResolved = true
+	}
+	if updatedAt.Valid {
+		p.UpdatedAt = &updatedAt.Time
+	}
+	return p, nil
+}
+
+// loadPost reads a single post by id. Trashed posts are not found.
+func loadPost(q querier, id int) (Post, error) {
+	return scanPost(q.QueryRow(postColumns+`
+		FROM posts
+		JOIN users ON posts.user_id = users.id
+		WHERE posts.id = ? AND posts.deleted_at IS NULL
+	`, id))
+}
+
+// postOrders are the sort options for GET /topics/{id}/posts. The first is the default.
+var postOrders = []listOrder{
+	{name: "pinned-first", keys: []sortKey{{"posts.is_pinned", true}, {"posts.id", false}}},
+	{name: "newest", keys: []sortKey{{"posts.id", true}}},
+	{name: "oldest", keys: []sortKey{{"posts.id", false}}},
+	{name: "most-commented", keys: []sortKey{
+		{"(SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.deleted_at IS NULL)", true},
+		{"posts.id", true},
+	}},
+	{name: "top", keys: []sortKey{{postScore, true}, {"posts.id", true}}},
+}
+
+func (s *sqlStore) Posts(topicID int, unresolved bool, page pageRequest) (Page[Post], error) {
+	where := "WHERE posts.topic_id = ? AND posts.deleted_at IS NULL"
+	if unresolved {
+		where += " AND " + postAcceptedComment + " IS NULL"
+	}
+
+	query, args := page.apply(postColumns+page.order.columns()+`
+		FROM posts
+		JOIN users ON posts.user_id = users.id
+		`+where, []any{topicID})
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return Page[Post]{}, err
+	}
+	defer rows.Close()
+
+	var posts []Post
+	var keys [][]int64
+	for rows.Next() {
+		key := make([]int64, len(page.order.keys))
+		p, err := scanPost(rows, page.keyDests(key)...)
+		if err != nil {
+			return Page[Post]{}, err
+		}
+		posts = append(posts, p)
+		keys = append(keys, key)
+	}
+	return newPage(page, posts, keys), rows.Err()
+}
+
+func (s *sqlStore) Post(id int) (Post, error) {
+	p, err := loadPost(s.q, id)
+	return p, notFound(err)
+}
+
+func (s *sqlStore) PostOwner(id int) (userID, topicID int, err error) {
+	err = s.q.QueryRow("SELECT user_id, topic_id FROM posts WHERE id = ? AND deleted_at IS NULL", id).
+		Scan(&userID, &topicID)
+	return userID, topicID, notFound(err)
+}
+
+func (s *sqlStore) CreatePost(topicID, userID int, title, content string) (Post, error) {
+	id, err := s.insertID(
+		"INSERT INTO posts (topic_id, user_id, title, content, is_pinned) VALUES (?, ?, ?, ?, 0)",
+		topicID, userID, title, content,
+	)
+	if err != nil {
+		return Post{}, err
+	}
+	return s.Post(id)
+}
+
+func (s *sqlStore) UpdatePost(id, editorID int, title, content string) (Post, error) {
+	// Keep the version being replaced, credited to whoever wrote it
+	if _, err := s.q.Exec(`
+		INSERT INTO post_revisions (post_id, title, content, editor_id, created_at)
+		SELECT id, title, content, COALESCE(updated_by, user_id), COALESCE(updated_at, created_at)
+		FROM posts WHERE id = ?
+	`, id); err != nil {
+		return Post{}, err
+	}
+
+	if _, err := s.q.Exec(
+		"UPDATE posts SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP, updated_by = ? WHERE id = ?",
+		title, content, editorID, id,
+	); err != nil {
+		return Post{}, err
+	}
+	return s.Post(id)
+}
+
+func (s *sqlStore) PinPost(id int, pinned bool) (Post, error) {
+	if _, err := s.q.Exec("UPDATE posts SET is_pinned = ? WHERE id = ?", boolToInt(pinned), id); err != nil {
+		return Post{}, err
+	}
+	return s.Post(id)
+}
+
+func (s *sqlStore) AcceptAnswer(id int, commentID *int) (Post, error) {
+	if _, err := s.q.Exec("UPDATE posts SET accepted_comment_id = ? WHERE id = ?", commentID, id); err != nil {
+		return Post{}, err
+	}
+	return s.Post(id)
+}
+
+func (s *sqlStore) TrashPost(id, userID int) error {
+	_, err := s.q.Exec(
+		"UPDATE posts SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? WHERE id = ?",
+		userID, id,
+	)
+	return err
+}
+
+func (s *sqlStore) VotePost(userID, id, value int) error {
+	return castVote(s.q, userID, "post", id, value)
+}
+
+func (s *sqlStore) PostVotes(userID int, ids []int) (map[int]int, error) {
+	return myVotes(s.q, userID, "post", ids)
+}
+
+func (s *sqlStore) PostRevisions(id int) ([]PostRevision, error) {
+	rows, err := s.q.Query(`
+		SELECT post_revisions.id, post_revisions.post_id, post_revisions.title,
+			post_revisions.content, users.username, post_revisions.created_at
+		FROM post_revisions
+		JOIN users ON post_revisions.editor_id = users.id
+		WHERE post_revisions.post_id = ?
+		ORDER BY post_revisions.id DESC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []PostRevision{}
+	for rows.Next() {
+		var rev PostRevision
+		if err := rows.Scan(&rev.ID, &rev.PostID, &rev.Title, &rev.Content, &rev.Editor, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *sqlStore) HasRecentPost(userID int, title, content string, since time.Time) (bool, error) {
+	var duplicate bool
+	err := s.q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM posts
+			WHERE user_id = ? AND title = ? AND content = ? AND created_at > ?
+		)
+	`, userID, title, content, timestamp(since)).Scan(&duplicate)
+	return duplicate, err
+}
+
+// timestamp formats t the way SQLite's CURRENT_TIMESTAMP stores it, so
+// that comparing the two compares times. Postgres reads it as UTC.
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.DateTime)
+}
+
+// ---- comments ----
+
+// commentScore is the sum of the votes on a comment.
+const commentScore = "(SELECT COALESCE(SUM(value), 0) FROM votes WHERE target_type = 'comment' AND target_id = comments.id)"
+
+// commentColumns selects the fields of a Comment; use with scanComment.
+// MyVote depends on the caller and is filled in by setMyCommentVotes.
+const commentColumns = `
+	SELECT comments.id, comments.post_id, comments.parent_id, comments.depth,
+		comments.content, users.username, comments.is_pinned, ` + commentScore + `,
+		comments.deleted_at IS NOT NULL, comments.created_at, comments.updated_at`
+
+// scanComment reads a row selected with commentColumns, followed by any extra columns.
+func scanComment(row rowScanner, extra ...any) (Comment, error) {
+	var c Comment
+	var parentID sql.NullInt64
+	var updatedAt sql.NullTime
+	dests := append([]any{&c.ID, &c.PostID, &parentID, &c.Depth, &c.Content, &c.Author,
+		&c.IsPinned, &c.Score, &c.IsDeleted, &c.CreatedAt, &updatedAt}, extra...)
+	if err := row.Scan(dests...); err != nil {
+		return c, err
+	}
+	c.ContentHTML = renderMarkdown(c.Content)
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		c.ParentID = &id
+	}
+	if updatedAt.Valid {
+		c.UpdatedAt = &updatedAt.Time
+	}
+	return c, nil
+}
+
+// loadComment reads a single comment by id. Trashed comments, and
+// comments on trashed posts, are not found.
+func loadComment(q querier, id int) (Comment, error) {
+	return scanComment(q.QueryRow(commentColumns+`
+		FROM comments
+		JOIN users ON comments.user_id = users.id
+		JOIN posts ON comments.post_id = posts.id
+		WHERE comments.id = ? AND comments.deleted_at IS NULL AND posts.deleted_at IS NULL
+	`, id))
+}
+
+// acceptedFirst sorts the accepted answer of the post above other comments.
+var acceptedFirst = sortKey{`CASE WHEN comments.deleted_at IS NULL
+	AND comments.id = (SELECT accepted_comment_id FROM posts WHERE posts.id = comments.post_id)
+	THEN 1 ELSE 0 END`, true}
+
+// commentOrders are the sort options for GET /posts/{id}/comments. The first is the default.
+// Every order puts the accepted answer first.
+var commentOrders = []listOrder{
+	{name: "pinned-first", keys: []sortKey{acceptedFirst, {"comments.is_pinned", true}, {"comments.id", false}}},
+	{name: "newest", keys: []sortKey{acceptedFirst, {"comments.id", true}}},
+	{name: "oldest", keys: []sortKey{acceptedFirst, {"comments.id", false}}},
+	{name: "top", keys: []sortKey{acceptedFirst, {commentScore, true}, {"comments.id", false}}},
+}
+
+// loadReplies reads every reply below the given top-level comments,
+// including deleted ones, grouped by the id of the comment they reply to.
+// Replies in each group are oldest first.
+func loadReplies(q querier, rootIDs []int) (map[int][]Comment, error) {
+	replies := make(map[int][]Comment)
+	if len(rootIDs) == 0 {
+		return replies, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(rootIDs)), ", ")
+	args := make([]any, len(rootIDs))
+	for i, id := range rootIDs {
+		args[i] = id
+	}
+
+	rows, err := q.Query(`
+		WITH RECURSIVE thread(id) AS (
+			SELECT id FROM comments WHERE parent_id IN (`+placeholders+`)
+			UNION ALL
+			SELECT comments.id FROM comments JOIN thread ON comments.parent_id = thread.id
+		)`+commentColumns+`
+		FROM comments
+		JOIN users ON comments.user_id = users.id
+		WHERE comments.id IN (SELECT id FROM thread)
+		ORDER BY comments.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		replies[*c.ParentID] = append(replies[*c.ParentID], c)
+	}
+	return replies, rows.Err()
+}
+
+// attachReplies fills in the replies of a page of top-level comments.
+func attachReplies(q querier, roots []Comment) ([]Comment, error) {
+	ids := make([]int, len(roots))
+	for i, c := range roots {
+		ids[i] = c.ID
+	}
+
+	replies, err := loadReplies(q, ids)
+	if err != nil {
+		return nil, err
+	}
+	return nestReplies(roots, replies), nil
+}
+
+func (s *sqlStore) Comments(postID int, page pageRequest) (Page[Comment], error) {
+	// Deleted comments are kept as placeholders while they have replies
+	query, args := page.apply(commentColumns+page.order.columns()+`
+		FROM comments
+		JOIN users ON comments.user_id = users.id
+		WHERE comments.post_id = ? AND comments.parent_id IS NULL
+			AND (comments.deleted_at IS NULL
+				OR EXISTS (SELECT 1 FROM comments AS reply WHERE reply.parent_id = comments.id))
+	`, []any{postID})
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return Page[Comment]{}, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	var keys [][]int64
+	for rows.Next() {
+		key := make([]int64, len(page.order.keys))
+		c, err := scanComment(rows, page.keyDests(key)...)
+		if err != nil {
+			return Page[Comment]{}, err
+		}
+		comments = append(comments, c)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[Comment]{}, err
+	}
+	rows.Close()
+
+	result := newPage(page, comments, keys)
+	result.Items, err = attachReplies(s.q, result.Items)
+	return result, err
+}
+
+func (s *sqlStore) Comment(id int) (Comment, error) {
+	c, err := loadComment(s.q, id)
+	return c, notFound(err)
+}
+
+func (s *sqlStore) CommentOwner(id int) (userID, postID, topicID int, err error) {
+	err = s.q.QueryRow(`
+		SELECT comments.user_id, comments.post_id, posts.topic_id
+		FROM comments
+		JOIN posts ON comments.post_id = posts.id
+		WHERE comments.id = ? AND comments.deleted_at IS NULL AND posts.deleted_at IS NULL
+	`, id).Scan(&userID, &postID, &topicID)
+	return userID, postID, topicID, notFound(err)
+}
+
+func (s *sqlStore) CreateComment(postID int, parentID *int, depth, userID int, content string) (Comment, error) {
+	id, err := s.insertID(
+		"INSERT INTO comments (post_id, parent_id, depth, user_id, content, is_pinned) VALUES (?, ?, ?, ?, ?, 0)",
+		postID, parentID, depth, userID, content,
+	)
+	if err != nil {
+		return Comment{}, err
+	}
+	return s.Comment(id)
+}
+
+func (s *sqlStore) UpdateComment(id, editorID int, content string) (Comment, error) {
+	// Keep the version being replaced, credited to whoever wrote it
+	if _, err := s.q.Exec(`
+		INSERT INTO comment_revisions (comment_id, content, editor_id, created_at)
+		SELECT id, content, COALESCE(updated_by, user_id), COALESCE(updated_at, created_at)
+		FROM comments WHERE id = ?
+	`, id); err != nil {
+		return Comment{}, err
+	}
+
+	if _, err := s.q.Exec(
+		"UPDATE comments SET content = ?, updated_at = CURRENT_TIMESTAMP, updated_by = ? WHERE id = ?",
+		content, editorID, id,
+	); err != nil {
+		return Comment{}, err
+	}
+	return s.Comment(id)
+}
+
+func (s *sqlStore) PinComment(id int, pinned bool) (Comment, error) {
+	if _, err := s.q.Exec("UPDATE comments SET is_pinned = ? WHERE id = ?", boolToInt(pinned), id); err != nil {
+		return Comment{}, err
+	}
+	return s.Comment(id)
+}
+
+func (s *sqlStore) TrashComment(id, userID int) error {
+	_, err := s.q.Exec(
+		"UPDATE comments SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? WHERE id = ?",
+		userID, id,
+	)
+	return err
+}
+
+func (s *sqlStore) VoteComment(userID, id, value int) error {
+	return castVote(s.q, userID, "comment", id, value)
+}
+
+func (s *sqlStore) CommentVotes(userID int, ids []int) (map[int]int, error) {
+	return myVotes(s.q, userID, "comment", ids)
+}
+
+func (s *sqlStore) CommentRevisions(id int) ([]CommentRevision, error) {
+	rows, err := s.q.Query(`
+		SELECT comment_revisions.id, comment_revisions.comment_id,
+			comment_revisions.content, users.username, comment_revisions.created_at
+		FROM comment_revisions
+		JOIN users ON comment_revisions.editor_id = users.id
+		WHERE comment_revisions.comment_id = ?
+		ORDER BY comment_revisions.id DESC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []CommentRevision{}
+	for rows.Next() {
+		var rev CommentRevision
+		if err := rows.Scan(&rev.ID, &rev.CommentID, &rev.Content, &rev.Editor, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *sqlStore) HasRecentComment(userID, postID int, content string, since time.Time) (bool, error) {
+	var duplicate bool
+	err := s.q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM comments
+			WHERE user_id = ? AND post_id = ? AND content = ? AND created_at > ?
+		)
+	`, userID, postID, content, timestamp(since)).Scan(&duplicate)
+	return duplicate, err
+}
+
+// ---- votes ----
+
+// myVotes returns the user's votes on the given posts or comments,
+// keyed by id. Anonymous callers (userID 0) have no votes.
+func myVotes(q querier, userID int, targetType string, ids []int) (map[int]int, error) {
+	votes := make(map[int]int)
+	if userID == 0 || len(ids) == 0 {
+		return votes, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := []any{userID, targetType}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := q.Query(`
+		SELECT target_id, value FROM votes
+		WHERE user_id = ? AND target_type = ? AND target_id IN (`+placeholders+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, value int
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		votes[id] = value
+	}
+	return votes, rows.Err()
+}
+
+// castVote stores, changes (value 1 or -1) or retracts (value 0) the
+// user's vote on a post or comment.
+func castVote(q querier, userID int, targetType string, targetID, value int) error {
+	if value == 0 {
+		_, err := q.Exec(
+			"DELETE FROM votes WHERE user_id = ? AND target_type = ? AND target_id = ?",
+			userID, targetType, targetID,
+		)
+		return err
+	}
+
+	_, err := q.Exec(`
+		INSERT INTO votes (user_id, target_type, target_id, value) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, target_type, target_id) DO UPDATE SET value = excluded.value
+	`, userID, targetType, targetID, value)
+	return err
+}
+
+// ---- users ----
+
+// authorize is the single policy check behind sqlStore.Authorize. It
+// reports whether the user holds perm, either through their role or, when
+// topicID is not 0, as a moderator of that topic. Read-only users get
+// nothing from topic moderation.
+func authorize(q querier, userID int, perm permission, topicID int) (bool, error) {
+	var allowed bool
+	err := q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM users
+			JOIN role_permissions ON role_permissions.role = users.role
+			WHERE users.id = ? AND role_permissions.permission = ?
+		) OR EXISTS (
+			SELECT 1 FROM topic_moderators
+			JOIN users ON topic_moderators.user_id = users.id
+			JOIN role_permissions ON role_permissions.role = ?
+			WHERE topic_moderators.user_id = ? AND topic_moderators.topic_id = ?
+				AND users.role <> ? AND role_permissions.permission = ?
+		)
+	`, userID, perm, topicModeratorRole, userID, topicID, roleReadOnly, perm).Scan(&allowed)
+	return allowed, err
+}
+
+// loadModeratedTopics returns the ids of the topics a user moderates.
+func loadModeratedTopics(q querier, userID int) ([]int, error) {
+	rows, err := q.Query("SELECT topic_id FROM topic_moderators WHERE user_id = ? ORDER BY topic_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// loadUser reads a single user, with the topics they moderate.
+func loadUser(q querier, id int) (User, error) {
+	user := User{ID: id}
+	err := q.QueryRow("SELECT username, role FROM users WHERE id = ?", id).Scan(&user.Username, &user.Role)
+	if err != nil {
+		return user, err
+	}
+	user.IsModerator = isGlobalModerator(user.Role)
+	user.ModeratedTopicIDs, err = loadModeratedTopics(q, id)
+	return user, err
+}
+
+// sanctionColumns selects the fields of a Sanction; use with scanSanction.
+const sanctionColumns = `
+	SELECT sanctions.id, sanctions.user_id, users.username, sanctions.reason,
+		issuer.username, sanctions.created_at, sanctions.expires_at
+	FROM sanctions
+	JOIN users ON sanctions.user_id = users.id
+	JOIN users AS issuer ON sanctions.issued_by = issuer.id`
+
+// activeSanctionFilter limits a query on sanctions to those in force at
+// the time given as its parameter.
+const activeSanctionFilter = `sanctions.lifted_at IS NULL
+	AND (sanctions.expires_at IS NULL OR sanctions.expires_at > ?)`
+
+// scanSanction reads one row selected with sanctionColumns.
+func scanSanction(row rowScanner) (Sanction, error) {
+	var s Sanction
+	var expiresAt sql.NullTime
+	err := row.Scan(&s.ID, &s.UserID, &s.Username, &s.Reason, &s.IssuedBy, &s.CreatedAt, &expiresAt)
+	if expiresAt.Valid {
+		s.ExpiresAt = &expiresAt.Time
+	}
+	return s, err
+}
+
+// activeSanction returns the sanction currently in force against a user,
+// or nil. If there are several, the one that lasts longest wins.
+func activeSanction(q querier, userID int) (*Sanction, error) {
+	s, err := scanSanction(q.QueryRow(sanctionColumns+`
+		WHERE sanctions.user_id = ? AND `+activeSanctionFilter+`
+		ORDER BY sanctions.expires_at IS NULL DESC, sanctions.expires_at DESC
+		LIMIT 1
+	`, userID, time.Now().UTC()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *sqlStore) Users() ([]User, error) {
+	users := []User{}
+	index := make(map[int]int)
+	rows, err := s.q.Query("SELECT id, username, role FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Role); err != nil {
+			return nil, err
+		}
+		u.IsModerator = isGlobalModerator(u.Role)
+		u.ModeratedTopicIDs = []int{}
+		index[u.ID] = len(users)
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	mods, err := s.q.Query("SELECT user_id, topic_id FROM topic_moderators ORDER BY topic_id")
+	if err != nil {
+		return nil, err
+	}
+	defer mods.Close()
+	for mods.Next() {
+		var userID, topicID int
+		if err := mods.Scan(&userID, &topicID); err != nil {
+			return nil, err
+		}
+		u := &users[index[userID]]
+		u.ModeratedTopicIDs = append(u.ModeratedTopicIDs, topicID)
+	}
+	return users, mods.Err()
+}
+
+func (s *sqlStore) User(id int) (User, error) {
+	u, err := loadUser(s.q, id)
+	return u, notFound(err)
+}
+
+func (s *sqlStore) UserByName(username string) (User, string, error) {
+	var user User
+	var hash string
+	err := s.q.QueryRow("SELECT id, username, password_hash, role FROM users WHERE lower(username) = lower(?)", username).
+		Scan(&user.ID, &user.Username, &hash, &user.Role)
+	if err != nil {
+		return user, "", notFound(err)
+	}
+	user.IsModerator = isGlobalModerator(user.Role)
+	user.ModeratedTopicIDs, err = loadModeratedTopics(s.q, user.ID)
+	return user, hash, err
+}
+
+func (s *sqlStore) CreateUser(username, passwordHash, role string) (User, error) {
+	id, err := s.insertID(
+		"INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)",
+		username, passwordHash, role,
+	)
+	// The unique index on lower(username) catches other cases
+	if isUniqueError(err) {
+		return User{}, errUsernameTaken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return s.User(id)
+}
+
+func (s *sqlStore) SetPassword(id int, passwordHash string) error {
+	result, err := s.q.Exec("UPDATE users SET password_hash = ? WHERE id = ?", passwordHash, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNotFound
+	}
+	_, err = s.q.Exec("DELETE FROM sessions WHERE user_id = ?", id)
+	return err
+}
+
+func (s *sqlStore) SetRole(id int, role string) error {
+	_, err := s.q.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	return err
+}
+
+func (s *sqlStore) SetTopicModerator(topicID, userID int, moderator bool) error {
+	var err error
+	if moderator {
+		_, err = s.q.Exec(
+			"INSERT INTO topic_moderators (topic_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING",
+			topicID, userID,
+		)
+	} else {
+		_, err = s.q.Exec(
+			"DELETE FROM topic_moderators WHERE topic_id = ? AND user_id = ?",
+			topicID, userID,
+		)
+	}
+	return err
+}
+
+func (s *sqlStore) Authorize(userID int, perm permission, topicID int) (bool, error) {
+	return authorize(s.q, userID, perm, topicID)
+}
+
+func (s *sqlStore) CreateSession(id string, userID int, expires time.Time) error {
+	_, err := s.q.Exec(
+		"INSERT INTO sessions (id, user_id, expires_at) VALUES (?, ?, ?)",
+		id, userID, expires,
+	)
+	return err
+}
+
+func (s *sqlStore) SessionUser(id string) (User, error) {
+	var user User
+	err := s.q.QueryRow(`
+		SELECT users.id, users.username, users.role
+		FROM sessions
+		JOIN users ON sessions.user_id = users.id
+		WHERE sessions.id = ? AND sessions.expires_at > ?
+	`, id, time.Now().UTC()).Scan(&user.ID, &user.Username, &user.Role)
+	user.IsModerator = isGlobalModerator(user.Role)
+	return user, notFound(err)
+}
+
+func (s *sqlStore) DeleteSession(id string) error {
+	_, err := s.q.Exec("DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
+
+func (s *sqlStore) CreateSanction(issuerID, userID int, reason string, expiresAt *time.Time) (Sanction, error) {
+	var expires any
+	if expiresAt != nil {
+		expires = expiresAt.UTC()
+	}
+	id, err := s.insertID(
+		"INSERT INTO sanctions (user_id, issued_by, reason, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		userID, issuerID, reason, time.Now().UTC(), expires,
+	)
+	if err != nil {
+		return Sanction{}, err
+	}
+	return scanSanction(s.q.QueryRow(sanctionColumns+" WHERE sanctions.id = ?", id))
+}
+
+func (s *sqlStore) Sanctions() ([]Sanction, error) {
+	rows, err := s.q.Query(sanctionColumns+`
+		WHERE `+activeSanctionFilter+`
+		ORDER BY sanctions.created_at DESC, sanctions.id DESC
+	`, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sanctions := []Sanction{}
+	for rows.Next() {
+		sanction, err := scanSanction(rows)
+		if err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, sanction)
+	}
+	return sanctions, rows.Err()
+}
+
+func (s *sqlStore) ActiveSanction(userID int) (*Sanction, error) {
+	return activeSanction(s.q, userID)
+}
+
+func (s *sqlStore) LiftSanction(id, moderatorID int) (Sanction, error) {
+	now := time.Now().UTC()
+	lifted, err := scanSanction(s.q.QueryRow(sanctionColumns+`
+		WHERE sanctions.id = ? AND `+activeSanctionFilter, id, now))
+	if err != nil {
+		return lifted, notFound(err)
+	}
+
+	_, err = s.q.Exec(
+		"UPDATE sanctions SET lifted_at = ?, lifted_by = ? WHERE id = ?",
+		now, moderatorID, id,
+	)
+	return lifted, err
+}
+
+// ---- audit log and notifications ----
+
+// auditOrders is the only sort option for GET /audit: newest first.
+var auditOrders = []listOrder{
+	{name: "newest", keys: []sortKey{{"audit_log.id", true}}},
+}
+
+// snapshot encodes v for the audit log, or returns nil for no snapshot.
+func snapshot(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+// recordAudit appends an entry to the audit log. It runs in the same
+// transaction as the action, so an action is never left unrecorded.
+// Pass nil for before or after when there is no such snapshot.
+func recordAudit(q querier, actorID int, action, targetType string, targetID int, before, after any) error {
+	beforeJSON, err := snapshot(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := snapshot(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO audit_log (actor_id, action, target_type, target_id, before, after, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, actorID, action, targetType, targetID, beforeJSON, afterJSON, time.Now().UTC())
+	return err
+}
+
+// notificationOrders is the only sort option for GET /notifications:
+// newest first.
+var notificationOrders = []listOrder{
+	{name: "newest", keys: []sortKey{{"notifications.id", true}}},
+}
+
+func (s *sqlStore) RecordAudit(actorID int, action, targetType string, targetID int, before, after any) error {
+	return recordAudit(s.q, actorID, action, targetType, targetID, before, after)
+}
+
+func (s *sqlStore) AuditLog(filter AuditFilter, page pageRequest) (Page[AuditEntry], error) {
+	where := "WHERE 1 = 1"
+	var args []any
+	// Usernames match in any case, like everywhere else
+	for _, f := range []struct{ cond, value string }{
+		{"lower(actor.username) = lower(?)", filter.Actor},
+		{"audit_log.action = ?", filter.Action},
+		{"audit_log.target_type = ?", filter.TargetType},
+	} {
+		if f.value != "" {
+			where += " AND " + f.cond
+			args = append(args, f.value)
+		}
+	}
+	if !filter.From.IsZero() {
+		where += " AND audit_log.created_at >= ?"
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		where += " AND audit_log.created_at <= ?"
+		args = append(args, filter.To.UTC())
+	}
+
+	query, args := page.apply(`
+		SELECT audit_log.id, actor.username, audit_log.action, audit_log.target_type,
+			audit_log.target_id, audit_log.before, audit_log.after, audit_log.created_at`+page.order.columns()+`
+		FROM audit_log
+		JOIN users AS actor ON audit_log.actor_id = actor.id
+		`+where, args)
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return Page[AuditEntry]{}, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	var keys [][]int64
+	for rows.Next() {
+		var e AuditEntry
+		var before, after sql.NullString
+		key := make([]int64, len(page.order.keys))
+		dests := append([]any{&e.ID, &e.Actor, &e.Action, &e.TargetType,
+			&e.TargetID, &before, &after, &e.CreatedAt}, page.keyDests(key)...)
+		if err := rows.Scan(dests...); err != nil {
+			return Page[AuditEntry]{}, err
+		}
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		entries = append(entries, e)
+		keys = append(keys, key)
+	}
+	return newPage(page, entries, keys), rows.Err()
+}
+
+func (s *sqlStore) AddNotification(userID, actorID int, kind string, postID int, commentID *int) error {
+	_, err := s.q.Exec(
+		"INSERT INTO notifications (user_id, actor_id, kind, post_id, comment_id) VALUES (?, ?, ?, ?, ?)",
+		userID, actorID, kind, postID, commentID,
+	)
+	return err
+}
+
+func (s *sqlStore) Notifications(userID int, unread bool, page pageRequest) (Page[Notification], error) {
+	where := "WHERE notifications.user_id = ? AND posts.deleted_at IS NULL AND comments.deleted_at IS NULL"
+	if unread {
+		where += " AND notifications.read_at IS NULL"
+	}
+	query, args := page.apply(`
+		SELECT notifications.id, notifications.kind, actor.username, notifications.post_id,
+			posts.title, notifications.comment_id, notifications.read_at IS NOT NULL,
+			notifications.created_at`+page.order.columns()+`
+		FROM notifications
+		JOIN users AS actor ON notifications.actor_id = actor.id
+		JOIN posts ON notifications.post_id = posts.id
+		LEFT JOIN comments ON notifications.comment_id = comments.id
+		`+where, []any{userID})
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return Page[Notification]{}, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	var keys [][]int64
+	for rows.Next() {
+		var n Notification
+		var commentID sql.NullInt64
+		key := make([]int64, len(page.order.keys))
+		dests := append([]any{&n.ID, &n.Kind, &n.Actor, &n.PostID,
+			&n.PostTitle, &commentID, &n.IsRead, &n.CreatedAt}, page.keyDests(key)...)
+		if err := rows.Scan(dests...); err != nil {
+			return Page[Notification]{}, err
+		}
+		if commentID.Valid {
+			id := int(commentID.Int64)
+			n.CommentID = &id
+		}
+		notifications = append(notifications, n)
+		keys = append(keys, key)
+	}
+	return newPage(page, notifications, keys), rows.Err()
+}
+
+func (s *sqlStore) UnreadCount(userID int) (int, error) {
+	var count int
+	err := s.q.QueryRow(`
+		SELECT COUNT(*)
+		FROM notifications
+		JOIN posts ON notifications.post_id = posts.id
+		LEFT JOIN comments ON notifications.comment_id = comments.id
+		WHERE notifications.user_id = ? AND notifications.read_at IS NULL
+			AND posts.deleted_at IS NULL AND comments.deleted_at IS NULL
+	`, userID).Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) MarkRead(userID int, ids []int) error {
+	query := "UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL"
+	args := []any{userID}
+	if ids != nil {
+		if len(ids) == 0 {
+			return nil
+		}
+		query += " AND id IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")"
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	_, err := s.q.Exec(query, args...)
+	return err
+}
+
+// ---- reports ----
+
+// reportColumns selects the fields of a Report; use with scanReport.
+const reportColumns = `
+	SELECT reports.id, reports.target_type, reports.target_id, reports.topic_id,
+		reports.reason, reporter.username, reports.created_at,
+		reports.resolution, resolver.username, reports.resolved_at, reports.resolution_note
+	FROM reports
+	JOIN users AS reporter ON reports.reporter_id = reporter.id
+	LEFT JOIN users AS resolver ON reports.resolved_by = resolver.id`
+
+// scanReport reads one row selected with reportColumns.
+func scanReport(row rowScanner) (Report, error) {
+	var rep Report
+	var resolution, resolvedBy sql.NullString
+	var resolvedAt sql.NullTime
+	err := row.Scan(&rep.ID, &rep.TargetType, &rep.TargetID, &rep.TopicID,
+		&rep.Reason, &rep.Reporter, &rep.CreatedAt,
+		&resolution, &resolvedBy, &resolvedAt, &rep.Note)
+	if resolution.Valid {
+		rep.Resolution = &resolution.String
+	}
+	if resolvedBy.Valid {
+		rep.ResolvedBy = &resolvedBy.String
+	}
+	if resolvedAt.Valid {
+		rep.ResolvedAt = &resolvedAt.Time
+	}
+	return rep, err
+}
+
+func (s *sqlStore) Reports(resolved bool, topicIDs []int) ([]Report, error) {
+	where := " WHERE reports.resolved_at IS NULL"
+	order := " ORDER BY reports.created_at, reports.id"
+	if resolved {
+		where = " WHERE reports.resolved_at IS NOT NULL"
+		order = " ORDER BY reports.resolved_at DESC, reports.id DESC"
+	}
+	var args []any
+	if topicIDs != nil {
+		if len(topicIDs) == 0 {
+			return []Report{}, nil
+		}
+		for _, id := range topicIDs {
+			args = append(args, id)
+		}
+		where += " AND reports.topic_id IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ") + ")"
+	}
+
+	rows, err := s.q.Query(reportColumns+where+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		rep, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, rep)
+	}
+	return reports, rows.Err()
+}
+
+func (s *sqlStore) Report(id int) (Report, error) {
+	rep, err := scanReport(s.q.QueryRow(reportColumns+" WHERE reports.id = ?", id))
+	return rep, notFound(err)
+}
+
+func (s *sqlStore) HasOpenReport(reporterID int, targetType string, targetID int) (bool, error) {
+	var open bool
+	err := s.q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM reports
+			WHERE reporter_id = ? AND target_type = ? AND target_id = ? AND resolved_at IS NULL
+		)
+	`, reporterID, targetType, targetID).Scan(&open)
+	return open, err
+}
+
+func (s *sqlStore) CreateReport(reporterID int, targetType string, targetID, topicID int, reason string) (Report, error) {
+	id, err := s.insertID(
+		"INSERT INTO reports (reporter_id, target_type, target_id, topic_id, reason) VALUES (?, ?, ?, ?, ?)",
+		reporterID, targetType, targetID, topicID, reason,
+	)
+	if err != nil {
+		return Report{}, err
+	}
+	return s.Report(id)
+}
+
+func (s *sqlStore) ResolveReports(targetType string, targetID int, resolution string, resolverID int, note string) error {
+	_, err := s.q.Exec(`
+		UPDATE reports
+		SET resolution = ?, resolved_by = ?, resolved_at = CURRENT_TIMESTAMP, resolution_note = ?
+		WHERE target_type = ? AND target_id = ? AND resolved_at IS NULL
+	`, resolution, resolverID, note, targetType, targetID)
+	return err
+}
+
+func (s *sqlStore) ContentAuthor(targetType string, id int) (int, error) {
+	table := "posts"
+	if targetType == "comment" {
+		table = "comments"
+	}
+	var authorID int
+	err := s.q.QueryRow("SELECT user_id FROM "+table+" WHERE id = ?", id).Scan(&authorID)
+	return authorID, notFound(err)
+}
+
+// ---- trash ----
+
+func (s *sqlStore) TrashedPosts() ([]TrashedPost, error) {
+	rows, err := s.q.Query(postColumns + `, posts.deleted_at, COALESCE(deleter.username, '')
+		FROM posts
+		JOIN users ON posts.user_id = users.id
+		LEFT JOIN users AS deleter ON posts.deleted_by = deleter.id
+		WHERE posts.deleted_at IS NOT NULL
+		ORDER BY posts.deleted_at DESC, posts.id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []TrashedPost{}
+	for rows.Next() {
+		var t TrashedPost
+		if t.Post, err = scanPost(rows, &t.DeletedAt, &t.DeletedBy); err != nil {
+			return nil, err
+		}
+		posts = append(posts, t)
+	}
+	return posts, rows.Err()
+}
+
+func (s *sqlStore) TrashedComments() ([]TrashedComment, error) {
+	rows, err := s.q.Query(commentColumns + `, comments.deleted_at, COALESCE(deleter.username, '')
+		FROM comments
+		JOIN users ON comments.user_id = users.id
+		LEFT JOIN users AS deleter ON comments.deleted_by = deleter.id
+		WHERE comments.deleted_at IS NOT NULL
+		ORDER BY comments.deleted_at DESC, comments.id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []TrashedComment{}
+	for rows.Next() {
+		var t TrashedComment
+		if t.Comment, err = scanComment(rows, &t.DeletedAt, &t.DeletedBy); err != nil {
+			return nil, err
+		}
+		comments = append(comments, t)
+	}
+	return comments, rows.Err()
+}
+
+func (s *sqlStore) RestorePost(id int) error {
+	return s.restore("posts", id)
+}
+
+func (s *sqlStore) RestoreComment(id int) error {
+	return s.restore("comments", id)
+}
+
+// restore takes a row of posts or comments out of the trash.
+func (s *sqlStore) restore(table string, id int) error {
+	result, err := s.q.Exec(
+		"UPDATE "+table+" SET deleted_at = NULL, deleted_by = NULL WHERE id = ? AND deleted_at IS NOT NULL",
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		return errNotFound
+	}
+	return err
+}
+
+func (s *sqlStore) PurgeTrash(cutoff time.Time) (posts, comments int64, err error) {
+	before := timestamp(cutoff)
+
+	postStatements := []string{
+		`DELETE FROM votes WHERE target_type = 'comment' AND target_id IN (
+			SELECT id FROM comments WHERE post_id IN (SELECT id FROM posts WHERE deleted_at < ?1)
+		)`,
+		`DELETE FROM reports WHERE target_type = 'comment' AND target_id IN (
+			SELECT id FROM comments WHERE post_id IN (SELECT id FROM posts WHERE deleted_at < ?1)
+		)`,
+		`DELETE FROM votes WHERE target_type = 'post' AND target_id IN (SELECT id FROM posts WHERE deleted_at < ?1)`,
+		`DELETE FROM reports WHERE target_type = 'post' AND target_id IN (SELECT id FROM posts WHERE deleted_at < ?1)`,
+		`DELETE FROM notifications WHERE post_id IN (SELECT id FROM posts WHERE deleted_at < ?1)`,
+		`DELETE FROM comment_revisions WHERE comment_id IN (
+			SELECT id FROM comments WHERE post_id IN (SELECT id FROM posts WHERE deleted_at < ?1)
+		)`,
+		`DELETE FROM comments WHERE post_id IN (SELECT id FROM posts WHERE deleted_at < ?1)`,
+		`DELETE FROM post_revisions WHERE post_id IN (SELECT id FROM posts WHERE deleted_at < ?1)`,
+		`DELETE FROM posts WHERE deleted_at < ?1`,
+	}
+
+	// Removing a reply can leave its parent without replies, so this
+	// is repeated until nothing more is deleted.
+	const leafComments = `SELECT id FROM comments WHERE deleted_at < ?1
+		AND NOT EXISTS (SELECT 1 FROM comments AS reply WHERE reply.parent_id = comments.id)`
+	commentStatements := []string{
+		`DELETE FROM votes WHERE target_type = 'comment' AND target_id IN (` + leafComments + `)`,
+		`DELETE FROM reports WHERE target_type = 'comment' AND target_id IN (` + leafComments + `)`,
+		`DELETE FROM notifications WHERE comment_id IN (` + leafComments + `)`,
+		`DELETE FROM comment_revisions WHERE comment_id IN (` + leafComments + `)`,
+		`DELETE FROM comments WHERE id IN (` + leafComments + `)`,
+	}
+
+	err = s.Tx(func(st Store) error {
+		tx := st.(*sqlStore).q
+		var counts [9]int64
+		for i, stmt := range postStatements {
+			result, err := tx.Exec(stmt, before)
+			if err != nil {
+				return err
+			}
+			if counts[i], err = result.RowsAffected(); err != nil {
+				return err
+			}
+		}
+		posts, comments = counts[8], counts[6]
+
+		for {
+			for _, stmt := range commentStatements[:4] {
+				if _, err := tx.Exec(stmt, before); err != nil {
+					return err
+				}
+			}
+			result, err := tx.Exec(commentStatements[4], before)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return nil
+			}
+			comments += n
+		}
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return posts, comments, nil
+}
+
+// ---- search ----
+
+// searchIndexReady reports whether the full-text search migration has been applied.
+func searchIndexReady(q querier) (bool, error) {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'posts_fts'").Scan(&count)
+	return count > 0, err
+}
+
+// Search runs on the FTS5 tables, so on SQLite it needs SQLite built
+// with FTS5. Postgres has its own full-text search.
+func (s *sqlStore) Search(q SearchQuery) ([]SearchResult, error) {
+	if s.db.dialect == postgresDialect {
+		return s.searchPostgres(q)
+	}
+	ready, err := searchIndexReady(s.q)
+	if err != nil {
+		return nil, err
+	}
+	if !ready {
+		return nil, errSearchUnavailable
+	}
+	match := buildMatchQuery(q.Terms)
+
+	// Each branch is one SELECT of the UNION; a branch is skipped when the
+	// type filter excludes it, or for topics when filtering by author.
+	var parts []string
+	var args []any
+	marks := []any{snippetOpen, snippetClose}
+
+	if q.Type == "" || q.Type == "topic" {
+		if q.Author == "" {
+			sel := `
+				SELECT 'topic' AS type, topics.id, topics.id, 0, topics.title,
+					snippet(topics_fts, -1, ?, ?, '…', 16), '', bm25(topics_fts, 10.0, 1.0) AS rank
+				FROM topics_fts
+				JOIN topics ON topics.id = topics_fts.rowid
+				WHERE topics_fts MATCH ?`
+			args = append(args, marks...)
+			args = append(args, match)
+			if q.TopicID != 0 {
+				sel += " AND topics.id = ?"
+				args = append(args, q.TopicID)
+			}
+			parts = append(parts, sel)
+		}
+	}
+
+	if q.Type == "" || q.Type == "post" {
+		sel := `
+			SELECT 'post' AS type, posts.id, posts.topic_id, posts.id, posts.title,
+				snippet(posts_fts, -1, ?, ?, '…', 16), users.username, bm25(posts_fts, 10.0, 1.0) AS rank
+			FROM posts_fts
+			JOIN posts ON posts.id = posts_fts.rowid
+			JOIN users ON posts.user_id = users.id
+			WHERE posts_fts MATCH ? AND posts.deleted_at IS NULL`
+		args = append(args, marks...)
+		args = append(args, match)
+		if q.Author != "" {
+			sel += " AND lower(users.username) = lower(?)"
+			args = append(args, q.Author)
+		}
+		if q.TopicID != 0 {
+			sel += " AND posts.topic_id = ?"
+			args = append(args, q.TopicID)
+		}
+		parts = append(parts, sel)
+	}
+
+	if q.Type == "" || q.Type == "comment" {
+		sel := `
+			SELECT 'comment' AS type, comments.id, posts.topic_id, comments.post_id, posts.title,
+				snippet(comments_fts, -1, ?, ?, '…', 16), users.username, bm25(comments_fts) AS rank
+			FROM comments_fts
+			JOIN comments ON comments.id = comments_fts.rowid
+			JOIN posts ON comments.post_id = posts.id
+			JOIN users ON comments.user_id = users.id
+			WHERE comments_fts MATCH ? AND comments.deleted_at IS NULL AND posts.deleted_at IS NULL`
+		args = append(args, marks...)
+		args = append(args, match)
+		if q.Author != "" {
+			sel += " AND lower(users.username) = lower(?)"
+			args = append(args, q.Author)
+		}
+		if q.TopicID != 0 {
+			sel += " AND posts.topic_id = ?"
+			args = append(args, q.TopicID)
+		}
+		parts = append(parts, sel)
+	}
+
+	results := []SearchResult{}
+	if len(parts) == 0 {
+		return results, nil
+	}
+	query := strings.Join(parts, "\nUNION ALL\n") + "\nORDER BY rank LIMIT ?"
+	args = append(args, q.Limit)
+
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res SearchResult
+		if err := rows.Scan(&res.Type, &res.ID, &res.TopicID, &res.PostID, &res.Title,
+			&res.Snippet, &res.Author, &res.Rank); err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// searchPostgres runs on the search_vector columns of the Postgres
+// full-text search migration, which stem words like the FTS5 tables do.
+// Postgres finds and ranks the matches, with title matches weighted ten
+// times higher; snippets are cut in Go, as ts_headline would turn any
+// HTML in the text into spaces.
+func (s *sqlStore) searchPostgres(q SearchQuery) ([]SearchResult, error) {
+	results := []SearchResult{}
+	tsquery := buildTSQuery(q.Terms)
+	if tsquery == "" {
+		return results, nil
+	}
+
+	// Each branch is one SELECT of the UNION, with the texts to cut a
+	// snippet from; the branches are skipped as in the SQLite search.
+	var parts []string
+	var args []any
+
+	if (q.Type == "" || q.Type == "topic") && q.Author == "" {
+		sel := `
+			SELECT 'topic' AS type, topics.id AS id, topics.id AS topic_id, 0 AS post_id, topics.title AS title, '' AS author,
+				-ts_rank('{0.1, 0.1, 0.1, 1.0}', topics.search_vector, to_tsquery('forum_search', ?)) AS rank,
+				topics.title AS first_text, coalesce(topics.description, '') AS second_text
+			FROM topics
+			WHERE topics.search_vector @@ to_tsquery('forum_search', ?)`
+		args = append(args, tsquery, tsquery)
+		if q.TopicID != 0 {
+			sel += " AND topics.id = ?"
+			args = append(args, q.TopicID)
+		}
+		parts = append(parts, sel)
+	}
+
+	if q.Type == "" || q.Type == "post" {
+		sel := `
+			SELECT 'post', posts.id, posts.topic_id, posts.id, posts.title, users.username,
+				-ts_rank('{0.1, 0.1, 0.1, 1.0}', posts.search_vector, to_tsquery('forum_search', ?)) AS rank,
+				posts.title, posts.content
+			FROM posts
+			JOIN users ON posts.user_id = users.id
+			WHERE posts.search_vector @@ to_tsquery('forum_search', ?) AND posts.deleted_at IS NULL`
+		args = append(args, tsquery, tsquery)
+		if q.Author != "" {
+			sel += " AND lower(users.username) = lower(?)"
+			args = append(args, q.Author)
+		}
+		if q.TopicID != 0 {
+			sel += " AND posts.topic_id = ?"
+			args = append(args, q.TopicID)
+		}
+		parts = append(parts, sel)
+	}
+
+	if q.Type == "" || q.Type == "comment" {
+		sel := `
+			SELECT 'comment', comments.id, posts.topic_id, comments.post_id, posts.title, users.username,
+				-ts_rank('{0.1, 0.1, 0.1, 1.0}', comments.search_vector, to_tsquery('forum_search', ?)) AS rank,
+				comments.content, ''
+			FROM comments
+			JOIN posts ON comments.post_id = posts.id
+			JOIN users ON comments.user_id = users.id
+			WHERE comments.search_vector @@ to_tsquery('forum_search', ?)
+				AND comments.deleted_at IS NULL AND posts.deleted_at IS NULL`
+		args = append(args, tsquery, tsquery)
+		if q.Author != "" {
+			sel += " AND lower(users.username) = lower(?)"
+			args = append(args, q.Author)
+		}
+		if q.TopicID != 0 {
+			sel += " AND posts.topic_id = ?"
+			args = append(args, q.TopicID)
+		}
+		parts = append(parts, sel)
+	}
+
+	if len(parts) == 0 {
+		return results, nil
+	}
+	query := strings.Join(parts, "\nUNION ALL\n") + "\nORDER BY rank LIMIT ?"
+	args = append(args, q.Limit)
+
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res SearchResult
+		var first, second string
+		if err := rows.Scan(&res.Type, &res.ID, &res.TopicID, &res.PostID, &res.Title,
+			&res.Author, &res.Rank, &first, &second); err != nil {
+			return nil, err
+		}
+		res.Snippet = bestSnippet(q.Terms, first, second)
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
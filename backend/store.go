@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// errSearchUnavailable is returned by Search when the store has no
+// search index.
+var errSearchUnavailable = errors.New("search is not available")
+
+// errUsernameTaken is returned by CreateUser when another user has the
+// same username, ignoring case.
+var errUsernameTaken = errors.New("username already taken")
+
+// errNotFound is returned by a store when what was asked for does not
+// exist. Posts and comments in the trash are not found either.
+var errNotFound = errors.New("not found")
+
+// TopicStore keeps the topics.
+type TopicStore interface {
+	// Topics lists every topic, oldest first.
+	Topics() ([]Topic, error)
+	Topic(id int) (Topic, error)
+	CreateTopic(title, description string) (Topic, error)
+	UpdateTopic(id int, title, description string) (Topic, error)
+	ArchiveTopic(id int, archived bool) (Topic, error)
+	// DeleteTopic deletes a topic with its posts and comments and
+	// everything attached to them.
+	DeleteTopic(id int) error
+}
+
+// PostStore keeps the posts, with their votes and edit history.
+type PostStore interface {
+	// Posts lists one page of the posts in a topic. With unresolved,
+	// only posts without an accepted answer are listed.
+	Posts(topicID int, unresolved bool, page pageRequest) (Page[Post], error)
+	Post(id int) (Post, error)
+	// PostOwner returns the author and topic of a post.
+	PostOwner(id int) (userID, topicID int, err error)
+	CreatePost(topicID, userID int, title, content string) (Post, error)
+	// UpdatePost replaces the title and content of a post, keeping the
+	// version it replaces as a revision.
+	UpdatePost(id, editorID int, title, content string) (Post, error)
+	PinPost(id int, pinned bool) (Post, error)
+	// AcceptAnswer sets the accepted answer of a post, or clears it if
+	// commentID is nil.
+	AcceptAnswer(id int, commentID *int) (Post, error)
+	// TrashPost moves a post to the trash on behalf of userID.
+	TrashPost(id, userID int) error
+	// VotePost stores the user's vote (1 or -1) on a post, or retracts
+	// it if value is 0.
+	VotePost(userID, id, value int) error
+	// PostVotes returns the user's votes on the given posts, keyed by id.
+	PostVotes(userID int, ids []int) (map[int]int, error)
+	// PostRevisions lists the earlier versions of a post, newest first.
+	PostRevisions(id int) ([]PostRevision, error)
+	// HasRecentPost reports whether the user posted the same title and
+	// content since the given time.
+	HasRecentPost(userID int, title, content string, since time.Time) (bool, error)
+}
+
+// CommentStore keeps the comments, with their votes and edit history.
+type CommentStore interface {
+	// Comments lists one page of the top-level comments on a post, each
+	// with its replies nested under it. A comment in the trash is kept
+	// as a blank placeholder while it has replies.
+	Comments(postID int, page pageRequest) (Page[Comment], error)
+	// Comment returns a comment, unless it or its post is in the trash.
+	Comment(id int) (Comment, error)
+	// CommentOwner returns the author, post and topic of a comment.
+	CommentOwner(id int) (userID, postID, topicID int, err error)
+	// CreateComment adds a comment to a post, as a reply at the given
+	// depth if parentID is not nil.
+	CreateComment(postID int, parentID *int, depth, userID int, content string) (Comment, error)
+	// UpdateComment replaces the content of a comment, keeping the
+	// version it replaces as a revision.
+	UpdateComment(id, editorID int, content string) (Comment, error)
+	PinComment(id int, pinned bool) (Comment, error)
+	// TrashComment moves a comment to the trash on behalf of userID.
+	TrashComment(id, userID int) error
+	// VoteComment works like VotePost.
+	VoteComment(userID, id, value int) error
+	// CommentVotes returns the user's votes on the given comments, keyed by id.
+	CommentVotes(userID int, ids []int) (map[int]int, error)
+	// CommentRevisions lists the earlier versions of a comment, newest first.
+	CommentRevisions(id int) ([]CommentRevision, error)
+	// HasRecentComment reports whether the user wrote the same comment
+	// on the same post since the given time.
+	HasRecentComment(userID, postID int, content string, since time.Time) (bool, error)
+}
+
+// UserStore keeps the users with their roles, sessions and sanctions.
+// Users are returned with the topics they moderate.
+type UserStore interface {
+	// Users lists every user, oldest first.
+	Users() ([]User, error)
+	User(id int) (User, error)
+	// UserByName returns a user and their password hash, matching the
+	// username in any case.
+	UserByName(username string) (User, string, error)
+	// CreateUser fails with errUsernameTaken if the username is in use
+	// in any case, so no one can pose as alice by registering Alice.
+	CreateUser(username, passwordHash, role string) (User, error)
+	// SetPassword replaces a user's password hash and ends their
+	// sessions, so whoever knew the old password is logged out.
+	SetPassword(id int, passwordHash string) error
+	SetRole(id int, role string) error
+	// SetTopicModerator makes the user a moderator of the topic, or
+	// stops them being one.
+	SetTopicModerator(topicID, userID int, moderator bool) error
+	// Authorize reports whether the user holds perm, either through
+	// their role or, when topicID is not 0, as a moderator of that
+	// topic. Read-only users get nothing from topic moderation.
+	Authorize(userID int, perm permission, topicID int) (bool, error)
+
+	CreateSession(id string, userID int, expires time.Time) error
+	// SessionUser returns the user of a session that has not expired,
+	// without the topics they moderate.
+	SessionUser(id string) (User, error)
+	DeleteSession(id string) error
+
+	// CreateSanction bans (expiresAt nil) or suspends a user.
+	CreateSanction(issuerID, userID int, reason string, expiresAt *time.Time) (Sanction, error)
+	// Sanctions lists the sanctions in force, newest first.
+	Sanctions() ([]Sanction, error)
+	// ActiveSanction returns the sanction in force against a user, or
+	// nil. If there are several, the one that lasts longest wins.
+	ActiveSanction(userID int) (*Sanction, error)
+	// LiftSanction ends a sanction that is in force and returns it as
+	// it was before.
+	LiftSanction(id, moderatorID int) (Sanction, error)
+}
+
+// AuditStore keeps the audit log.
+type AuditStore interface {
+	// RecordAudit appends an entry to the audit log. Pass nil for
+	// before or after when there is no such snapshot.
+	RecordAudit(actorID int, action, targetType string, targetID int, before, after any) error
+	// AuditLog lists one page of the entries that pass the filter,
+	// newest first.
+	AuditLog(filter AuditFilter, page pageRequest) (Page[AuditEntry], error)
+}
+
+// NotificationStore keeps the users' notifications.
+type NotificationStore interface {
+	AddNotification(userID, actorID int, kind string, postID int, commentID *int) error
+	// Notifications lists one page of a user's notifications, newest
+	// first; with unread, only those not read yet. Notifications about
+	// posts or comments in the trash are left out, here and in
+	// UnreadCount.
+	Notifications(userID int, unread bool, page pageRequest) (Page[Notification], error)
+	UnreadCount(userID int) (int, error)
+	// MarkRead marks the user's notifications with the given ids read,
+	// or all of them if ids is nil.
+	MarkRead(userID int, ids []int) error
+}
+
+// TrashStore keeps the posts and comments in the trash.
+type TrashStore interface {
+	// TrashedPosts lists the posts in the trash, most recently deleted first.
+	TrashedPosts() ([]TrashedPost, error)
+	// TrashedComments lists the comments in the trash, most recently
+	// deleted first.
+	TrashedComments() ([]TrashedComment, error)
+	// RestorePost takes a post out of the trash. It returns errNotFound
+	// if the post is not in the trash.
+	RestorePost(id int) error
+	// RestoreComment works like RestorePost.
+	RestoreComment(id int) error
+	// PurgeTrash deletes for good what has been in the trash since
+	// before cutoff, with everything attached to it, and returns how
+	// many posts and comments went. A comment that still has replies is
+	// kept as a placeholder until they are gone.
+	PurgeTrash(cutoff time.Time) (posts, comments int64, err error)
+}
+
+// SearchStore finds topics, posts and comments by the words in them.
+type SearchStore interface {
+	// Search returns the best matches for the query, best first, or
+	// errSearchUnavailable if there is no search index.
+	Search(q SearchQuery) ([]SearchResult, error)
+}
+
+// ReportStore keeps the users' reports about posts and comments.
+type ReportStore interface {
+	// Reports lists the open reports, oldest first, or the resolved
+	// ones, most recently resolved first. If topicIDs is not nil, only
+	// the reports in those topics are listed.
+	Reports(resolved bool, topicIDs []int) ([]Report, error)
+	Report(id int) (Report, error)
+	// HasOpenReport reports whether the user has an open report on the
+	// post or comment.
+	HasOpenReport(reporterID int, targetType string, targetID int) (bool, error)
+	CreateReport(reporterID int, targetType string, targetID, topicID int, reason string) (Report, error)
+	// ResolveReports resolves every open report on the post or comment.
+	ResolveReports(targetType string, targetID int, resolution string, resolverID int, note string) error
+	// ContentAuthor returns the author of a post or comment, even one
+	// in the trash.
+	ContentAuthor(targetType string, id int) (int, error)
+}
+
+// Store is everything the handlers keep. There is one on SQL, which the
+// server normally runs on with SQLite or Postgres, and one in memory.
+type Store interface {
+	TopicStore
+	PostStore
+	CommentStore
+	UserStore
+	AuditStore
+	NotificationStore
+	ReportStore
+	TrashStore
+	SearchStore
+
+	// Tx runs fn with a Store whose changes are kept if fn returns nil
+	// and discarded otherwise. Inside Tx, fn must only use that Store.
+	Tx(fn func(tx Store) error) error
+	// Seed inserts sample data into whatever is still empty.
+	Seed() error
+}
@@ -0,0 +1,39 @@
+package main
+
+// maxCommentDepth is how deeply replies can nest. Top-level comments
+// have depth 0, so a reply chain can be at most this many levels long.
+const maxCommentDepth = 5
+
+// buildThread nests the replies under c. A deleted comment stays in the
+// thread as a blank placeholder while it still has visible replies, and
+// is dropped (ok is false) once it has none.
+func buildThread(c Comment, replies map[int][]Comment) (thread Comment, ok bool) {
+	for _, reply := range replies[c.ID] {
+		if reply, ok := buildThread(reply, replies); ok {
+			c.Replies = append(c.Replies, reply)
+		}
+	}
+
+	if c.IsDeleted {
+		if len(c.Replies) == 0 {
+			return c, false
+		}
+		c.Content = ""
+		c.ContentHTML = ""
+		c.Author = ""
+		c.Score = 0
+	}
+	return c, true
+}
+
+// nestReplies builds the thread of each top-level comment from the
+// replies grouped by parent id, dropping roots that end up empty.
+func nestReplies(roots []Comment, replies map[int][]Comment) []Comment {
+	threads := []Comment{}
+	for _, c := range roots {
+		if thread, ok := buildThread(c, replies); ok {
+			threads = append(threads, thread)
+		}
+	}
+	return threads
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// defaultTrashRetention is how long deleted content is kept before the
+// purge job removes it for good. The trash-retention setting overrides it.
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// trashPurgeInterval is how often the purge job runs.
+const trashPurgeInterval = time.Hour
+
+// TrashedPost is a deleted post as shown in the trash.
+type TrashedPost struct {
+	Post
+	DeletedAt time.Time `json:"deletedAt"`
+	DeletedBy string    `json:"deletedBy"`
+}
+
+// TrashedComment is a deleted comment as shown in the trash.
+type TrashedComment struct {
+	Comment
+	DeletedAt time.Time `json:"deletedAt"`
+	DeletedBy string    `json:"deletedBy"`
+}
+
+// Trash is the response body of GET /trash.
+type Trash struct {
+	Posts    []TrashedPost    `json:"posts"`
+	Comments []TrashedComment `json:"comments"`
+}
+
+// RestoreRequest represents the JSON body for restoring trashed content.
+type RestoreRequest struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+}
+
+// trashHandler handles GET /trash and lists deleted posts and comments,
+// most recently deleted first. Only moderators can see the trash.
+func (s *server) trashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := s.requirePermission(w, r, permManageTrash, 0, "Only moderators can view the trash"); !ok {
+		return
+	}
+
+	posts, err := s.store.TrashedPosts()
+	if err != nil {
+		writeError(w, "Failed to query trashed posts", http.StatusInternalServerError)
+		return
+	}
+	comments, err := s.store.TrashedComments()
+	if err != nil {
+		writeError(w, "Failed to query trashed comments", http.StatusInternalServerError)
+		return
+	}
+	trash := Trash{Posts: posts, Comments: comments}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(trash); err != nil {
+		writeError(w, "Failed to encode trash", http.StatusInternalServerError)
+	}
+}
+
+// restoreHandler handles POST /trash/restore
+// It takes { "type": "post" | "comment", "id": 1 } and takes the item
+// out of the trash, publishing it as a post.created or comment.created
+// event. A comment can only come back once its post has. Only
+// moderators can restore content.
+func (s *server) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	moderator, ok := s.requirePermission(w, r, permManageTrash, 0, "Only moderators can restore content")
+	if !ok {
+		return
+	}
+
+	var req RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.ID == 0 {
+		writeFieldError(w, "id", "Missing id")
+		return
+	}
+
+	if req.Type != "post" && req.Type != "comment" {
+		writeFieldError(w, "type", "Type must be post or comment")
+		return
+	}
+
+	var restored any
+	var event Event
+	err := s.store.Tx(func(tx Store) error {
+		var err error
+		if req.Type == "post" {
+			err = tx.RestorePost(req.ID)
+		} else {
+			err = tx.RestoreComment(req.ID)
+		}
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "No trashed " + req.Type + " with that id"}
+		}
+		if err != nil {
+			return err
+		}
+
+		// Clients see restored content come back as if it were new
+		if req.Type == "post" {
+			var post Post
+			post, err = tx.Post(req.ID)
+			restored, event = post, Event{"post.created", post.TopicID, post.ID, post}
+		} else {
+			var comment Comment
+			comment, err = tx.Comment(req.ID)
+			if err == nil {
+				var topicID int
+				_, _, topicID, err = tx.CommentOwner(req.ID)
+				restored, event = comment, Event{"comment.created", topicID, comment.PostID, comment}
+			}
+		}
+		if err == errNotFound {
+			return &statusError{http.StatusConflict, "The comment's post is in the trash; restore the post first"}
+		}
+		if err != nil {
+			return err
+		}
+		return tx.RecordAudit(moderator.ID, req.Type+".restore", req.Type, req.ID, nil, restored)
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to restore "+req.Type)
+		return
+	}
+	s.events.publish(event)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(restored); err != nil {
+		writeError(w, "Failed to encode restored "+req.Type, http.StatusInternalServerError)
+	}
+}
+
+// startTrashPurger purges the trash now and then every trashPurgeInterval
+// in the background. A retention of zero disables purging.
+func startTrashPurger(st TrashStore, retention time.Duration) {
+	if retention <= 0 {
+		slog.Info("Trash purging is disabled")
+		return
+	}
+
+	purge := func() {
+		posts, comments, err := st.PurgeTrash(time.Now().Add(-retention))
+		if err != nil {
+			slog.Error("Failed to purge trash", "err", err)
+			return
+		}
+		if posts > 0 || comments > 0 {
+			slog.Info("Purged trash", "posts", posts, "comments", comments)
+		}
+	}
+
+	go func() {
+		purge()
+		for range time.Tick(trashPurgeInterval) {
+			purge()
+		}
+	}()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRestorePublishesEvent(t *testing.T) {
+	for _, tt := range []struct {
+		kind, path string
+		id         func(ts *testServer) int
+		want       string
+	}{
+		{"post", "/posts/%d", func(ts *testServer) int { return ts.post.ID }, "post.created"},
+		{"comment", "/comments/%d", func(ts *testServer) int { return ts.comment.ID }, "comment.created"},
+	} {
+		t.Run(tt.kind, func(t *testing.T) {
+			ts := newTestServer(t)
+			id := tt.id(ts)
+			wantStatus(t, ts.do(http.MethodDelete, fmt.Sprintf(tt.path, id), "alice", nil), http.StatusNoContent)
+
+			sub := ts.events.subscribe(ts.general.ID, 0)
+			defer ts.events.unsubscribe(sub)
+			w := ts.do(http.MethodPost, "/trash/restore", "mona", RestoreRequest{Type: tt.kind, ID: id})
+			wantStatus(t, w, http.StatusOK)
+
+			e := <-sub.events
+			if e.Type != tt.want || e.PostID != ts.post.ID {
+				t.Fatalf("event = %+v, want %s on post %d", e, tt.want, ts.post.ID)
+			}
+			var restoredID int
+			switch data := e.Data.(type) {
+			case Post:
+				restoredID = data.ID
+			case Comment:
+				restoredID = data.ID
+			}
+			if restoredID != id {
+				t.Errorf("event data = %+v, want %s %d", e.Data, tt.kind, id)
+			}
+		})
+	}
+}
+
+func TestTrash(t *testing.T) {
+	ts := newTestServer(t)
+	wantStatus(t, ts.do(http.MethodDelete, "/comments/1", "carol", nil), http.StatusNoContent)
+	wantStatus(t, ts.do(http.MethodDelete, "/posts/2", "mona", nil), http.StatusNoContent)
+
+	w := ts.do(http.MethodGet, "/trash", "mona", nil)
+	wantStatus(t, w, http.StatusOK)
+	trash := decode[Trash](t, w)
+	if len(trash.Posts) != 1 || trash.Posts[0].ID != ts.homeworkPost.ID || trash.Posts[0].DeletedBy != "mona" {
+		t.Errorf("trashed posts = %+v, want carol's post deleted by mona", trash.Posts)
+	}
+	if len(trash.Comments) != 1 || trash.Comments[0].ID != ts.comment.ID || trash.Comments[0].DeletedBy != "carol" {
+		t.Errorf("trashed comments = %+v, want carol's comment deleted by her", trash.Comments)
+	}
+
+	w = ts.do(http.MethodPost, "/trash/restore", "alice", RestoreRequest{Type: "comment", ID: ts.comment.ID})
+	wantStatus(t, w, http.StatusOK)
+	if n := ts.auditCount("comment.restore", ts.comment.ID); n != 1 {
+		t.Errorf("%d comment.restore audit entries, want 1", n)
+	}
+	wantStatus(t, ts.do(http.MethodGet, "/comments/1", "", nil), http.StatusOK)
+	// Restoring it again finds nothing in the trash
+	w = ts.do(http.MethodPost, "/trash/restore", "alice", RestoreRequest{Type: "comment", ID: ts.comment.ID})
+	wantError(t, w, http.StatusNotFound, "")
+
+	for _, tt := range []struct {
+		name  string
+		body  any
+		field string
+	}{
+		{"missing id", RestoreRequest{Type: "post"}, "id"},
+		{"invalid type", RestoreRequest{Type: "topic", ID: 1}, "type"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			wantError(t, ts.do(http.MethodPost, "/trash/restore", "mona", tt.body), http.StatusBadRequest, tt.field)
+		})
+	}
+
+	restore := RestoreRequest{Type: "post", ID: 1}
+	runRequests(t, []request{
+		{"list anonymous", http.MethodGet, "/trash", "", nil, http.StatusUnauthorized},
+		{"list as member", http.MethodGet, "/trash", "bob", nil, http.StatusForbidden},
+		{"list as topic moderator", http.MethodGet, "/trash", "tom", nil, http.StatusForbidden},
+		{"list wrong method", http.MethodDelete, "/trash", "mona", nil, http.StatusMethodNotAllowed},
+		{"restore anonymous", http.MethodPost, "/trash/restore", "", restore, http.StatusUnauthorized},
+		{"restore as member", http.MethodPost, "/trash/restore", "bob", restore, http.StatusForbidden},
+		{"restore as topic moderator", http.MethodPost, "/trash/restore", "tom", restore, http.StatusForbidden},
+		{"restore a visible post", http.MethodPost, "/trash/restore", "mona", restore, http.StatusNotFound},
+		{"restore unknown comment", http.MethodPost, "/trash/restore", "mona", RestoreRequest{Type: "comment", ID: 9999}, http.StatusNotFound},
+		{"restore invalid JSON", http.MethodPost, "/trash/restore", "mona", "{", http.StatusBadRequest},
+		{"restore wrong method", http.MethodGet, "/trash/restore", "mona", nil, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestPurgeTrash(t *testing.T) {
+	ts := newTestServer(t)
+	wantStatus(t, ts.do(http.MethodDelete, "/posts/1", "bob", nil), http.StatusNoContent)
+	wantStatus(t, ts.do(http.MethodDelete, "/posts/2", "carol", nil), http.StatusNoContent)
+
+	// Nothing was deleted before an hour ago
+	posts, _, err := ts.store.PurgeTrash(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posts != 0 {
+		t.Errorf("purged %d posts, want none", posts)
+	}
+
+	posts, _, err = ts.store.PurgeTrash(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posts != 2 {
+		t.Errorf("purged %d posts, want 2", posts)
+	}
+	trash, err := ts.store.TrashedPosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 0 {
+		t.Errorf("trash = %+v, want it empty", trash)
+	}
+	// Purged content is gone for good, comments with their posts
+	w := ts.do(http.MethodPost, "/trash/restore", "mona", RestoreRequest{Type: "post", ID: ts.post.ID})
+	wantError(t, w, http.StatusNotFound, "")
+	if _, _, _, err := ts.store.CommentOwner(ts.comment.ID); err != errNotFound {
+		t.Errorf("CommentOwner of a purged comment: err = %v, want errNotFound", err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Length limits, in characters, for what users write.
+const (
+	maxTopicTitleLength       = 100
+	maxTopicDescriptionLength = 1000
+	maxPostTitleLength        = 200
+	maxPostContentLength      = 20000
+	maxCommentContentLength   = 10000
+	maxReasonLength           = 1000
+	minUsernameLength         = 3
+	maxUsernameLength         = 30
+)
+
+// usernamePattern allows ASCII letters, digits and underscores, with single
+// dots or hyphens between them, so every username can be @mentioned.
+var usernamePattern = regexp.MustCompile(`^\w+(?:[.-]\w+)*$`)
+
+// APIError is the JSON body of every error response. Code is a stable
+// identifier for programs, Message is for people, and Fields says what
+// is wrong with each invalid field of the request.
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// errorCode derives the code of an error response from its status,
+// e.g. "not_found" for 404.
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// writeError answers a request with an error. It takes the same
+// arguments as http.Error, which it replaces.
+func writeError(w http.ResponseWriter, message string, status int) {
+	writeAPIError(w, status, APIError{Code: errorCode(status), Message: message})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, body APIError) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// fieldErrors maps request fields to what is wrong with them. Returned
+// from a transaction, it is answered with 400 like any other invalid
+// request.
+type fieldErrors map[string]string
+
+func (e fieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = e[field]
+	}
+	return strings.Join(messages, "; ")
+}
+
+// writeFieldErrors answers an invalid request with 400.
+func writeFieldErrors(w http.ResponseWriter, errs fieldErrors) {
+	writeAPIError(w, http.StatusBadRequest, APIError{
+		Code:    "validation_failed",
+		Message: errs.Error(),
+		Fields:  errs,
+	})
+}
+
+// writeFieldError answers a request with 400 for a single invalid field.
+func writeFieldError(w http.ResponseWriter, field, message string) {
+	writeFieldErrors(w, fieldErrors{field: message})
+}
+
+// validator cleans up the fields of a request and collects what is
+// wrong with them, so that all problems are reported at once.
+type validator struct {
+	errs fieldErrors
+}
+
+// fail records an error for field, keeping the first one.
+func (v *validator) fail(field, message string) {
+	if v.errs == nil {
+		v.errs = fieldErrors{}
+	}
+	if _, ok := v.errs[field]; !ok {
+		v.errs[field] = message
+	}
+}
+
+// check records message for field unless ok.
+func (v *validator) check(ok bool, field, message string) {
+	if !ok {
+		v.fail(field, message)
+	}
+}
+
+// normalize puts text in Unicode NFC form, so the same characters are
+// always stored and compared the same way, and trims surrounding space.
+func normalize(s string) string {
+	return strings.TrimSpace(norm.NFC.String(s))
+}
+
+// text normalizes value and checks that it has at least min and at most
+// max characters; min 1 makes the field required. label names the field
+// in messages.
+func (v *validator) text(field, label, value string, min, max int) string {
+	value = normalize(value)
+	n := utf8.RuneCountInString(value)
+	switch {
+	case n == 0 && min > 0:
+		v.fail(field, label+" is required")
+	case n < min:
+		v.fail(field, label+" must be at least "+strconv.Itoa(min)+" characters")
+	case n > max:
+		v.fail(field, label+" must be at most "+strconv.Itoa(max)+" characters")
+	}
+	return value
+}
+
+// username normalizes a new username and checks it against the rules.
+func (v *validator) username(field, value string) string {
+	value = v.text(field, "Username", value, minUsernameLength, maxUsernameLength)
+	if value != "" && !usernamePattern.MatchString(value) {
+		v.fail(field, "Username can only contain a-z, A-Z, 0-9 and underscores, with single dots or hyphens between them")
+	}
+	return value
+}
+
+// password checks a new password. It is not normalized or trimmed:
+// whatever was typed is what is hashed.
+func (v *validator) password(field, value string) {
+	switch {
+	case utf8.RuneCountInString(value) < minPasswordLength:
+		v.fail(field, "Password must be at least "+strconv.Itoa(minPasswordLength)+" characters")
+	case len(value) > maxPasswordBytes:
+		v.fail(field, "Password must be at most "+strconv.Itoa(maxPasswordBytes)+" bytes")
+	}
+}
+
+// valid reports whether every field passed. If not, it answers the
+// request with the errors.
+func (v *validator) valid(w http.ResponseWriter) bool {
+	if len(v.errs) == 0 {
+		return true
+	}
+	writeFieldErrors(w, v.errs)
+	return false
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// VoteRequest represents the JSON body for casting or changing a vote.
+type VoteRequest struct {
+	Value int `json:"value"`
+}
+
+// viewerID returns the id of the logged-in user, or 0 for visitors.
+func viewerID(r *http.Request) int {
+	if user := currentUser(r); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// setMyPostVotes fills in MyVote on each post for the given user.
+func setMyPostVotes(st PostStore, userID int, posts []Post) error {
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+
+	votes, err := st.PostVotes(userID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].MyVote = votes[posts[i].ID]
+	}
+	return nil
+}
+
+// setMyCommentVotes fills in MyVote on each comment and all of its
+// replies for the given user.
+func setMyCommentVotes(st CommentStore, userID int, comments []Comment) error {
+	var ids []int
+	var collect func(list []Comment)
+	collect = func(list []Comment) {
+		for _, c := range list {
+			ids = append(ids, c.ID)
+			collect(c.Replies)
+		}
+	}
+	collect(comments)
+
+	votes, err := st.CommentVotes(userID, ids)
+	if err != nil {
+		return err
+	}
+
+	var apply func(list []Comment)
+	apply = func(list []Comment) {
+		for i := range list {
+			if !list[i].IsDeleted {
+				list[i].MyVote = votes[list[i].ID]
+			}
+			apply(list[i].Replies)
+		}
+	}
+	apply(comments)
+	return nil
+}
+
+// readVote reads the vote value for PUT (1 or -1) or returns 0 for
+// DELETE, writing 400/405 if the request is not valid.
+func readVote(w http.ResponseWriter, r *http.Request) (int, bool) {
+	switch r.Method {
+	case http.MethodPut:
+		var req VoteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid JSON body", http.StatusBadRequest)
+			return 0, false
+		}
+		if req.Value != 1 && req.Value != -1 {
+			writeFieldError(w, "value", "Value must be 1 or -1")
+			return 0, false
+		}
+		return req.Value, true
+	case http.MethodDelete:
+		return 0, true
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return 0, false
+	}
+}
+
+// postVoteHandler handles:
+//   - PUT    /posts/{id}/vote → cast or change a vote, body { "value": 1 | -1 }
+//   - DELETE /posts/{id}/vote → retract the caller's vote
+//
+// It returns the post with its new score.
+func (s *server) postVoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	value, ok := readVote(w, r)
+	if !ok {
+		return
+	}
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+
+	var voted Post
+	err := s.store.Tx(func(tx Store) error {
+		post, err := tx.Post(id)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Post not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if post.Author == user.Username {
+			return &statusError{http.StatusForbidden, "You cannot vote on your own post"}
+		}
+
+		archived, err := isTopicArchived(tx, post.TopicID)
+		if err != nil {
+			return err
+		}
+		if archived {
+			return &statusError{http.StatusForbidden, "This topic is archived and read-only"}
+		}
+
+		allowed, err := tx.Authorize(user.ID, permVote, post.TopicID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Your account cannot vote"}
+		}
+
+		if err := tx.VotePost(user.ID, id, value); err != nil {
+			return err
+		}
+
+		voted, err = tx.Post(id)
+		voted.MyVote = value
+		return err
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to save vote")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(voted); err != nil {
+		writeError(w, "Failed to encode voted post", http.StatusInternalServerError)
+	}
+}
+
+// commentVoteHandler handles:
+//   - PUT    /comments/{id}/vote → cast or change a vote, body { "value": 1 | -1 }
+//   - DELETE /comments/{id}/vote → retract the caller's vote
+//
+// It returns the comment with its new score.
+func (s *server) commentVoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	value, ok := readVote(w, r)
+	if !ok {
+		return
+	}
+	user, ok := requireActiveUser(w, r)
+	if !ok {
+		return
+	}
+
+	var voted Comment
+	err := s.store.Tx(func(tx Store) error {
+		comment, err := tx.Comment(id)
+		if err == errNotFound {
+			return &statusError{http.StatusNotFound, "Comment not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if comment.Author == user.Username {
+			return &statusError{http.StatusForbidden, "You cannot vote on your own comment"}
+		}
+
+		_, _, topicID, err := tx.CommentOwner(id)
+		if err != nil {
+			return err
+		}
+		archived, err := isTopicArchived(tx, topicID)
+		if err != nil {
+			return err
+		}
+		if archived {
+			return &statusError{http.StatusForbidden, "This topic is archived and read-only"}
+		}
+
+		allowed, err := tx.Authorize(user.ID, permVote, topicID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &statusError{http.StatusForbidden, "Your account cannot vote"}
+		}
+
+		if err := tx.VoteComment(user.ID, id, value); err != nil {
+			return err
+		}
+
+		voted, err = tx.Comment(id)
+		voted.MyVote = value
+		return err
+	})
+	if err != nil {
+		writeTxError(w, err, "Failed to save vote")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(voted); err != nil {
+		writeError(w, "Failed to encode voted comment", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPostVote(t *testing.T) {
+	ts := newTestServer(t)
+	for _, tt := range []struct {
+		username string
+		value    int
+		score    int
+	}{
+		{"carol", 1, 1},
+		{"tom", 1, 2},
+		// Changing a vote replaces it
+		{"carol", -1, 0},
+	} {
+		w := ts.do(http.MethodPut, "/posts/1/vote", tt.username, VoteRequest{Value: tt.value})
+		wantStatus(t, w, http.StatusOK)
+		if post := decode[Post](t, w); post.Score != tt.score || post.MyVote != tt.value {
+			t.Errorf("after %s voted %d: score = %d, myVote = %d, want %d and %d", tt.username, tt.value, post.Score, post.MyVote, tt.score, tt.value)
+		}
+	}
+
+	w := ts.do(http.MethodDelete, "/posts/1/vote", "carol", nil)
+	wantStatus(t, w, http.StatusOK)
+	if post := decode[Post](t, w); post.Score != 1 || post.MyVote != 0 {
+		t.Errorf("after carol retracted: score = %d, myVote = %d, want 1 and 0", post.Score, post.MyVote)
+	}
+	wantError(t, ts.do(http.MethodPut, "/posts/1/vote", "carol", VoteRequest{Value: 2}), http.StatusBadRequest, "value")
+
+	up := VoteRequest{Value: 1}
+	runRequests(t, []request{
+		{"anonymous", http.MethodPut, "/posts/1/vote", "", up, http.StatusUnauthorized},
+		{"own post", http.MethodPut, "/posts/1/vote", "bob", up, http.StatusForbidden},
+		{"read-only", http.MethodPut, "/posts/1/vote", "rita", up, http.StatusForbidden},
+		{"banned", http.MethodPut, "/posts/1/vote", "ben", up, http.StatusForbidden},
+		{"archived topic", http.MethodPut, "/posts/3/vote", "carol", up, http.StatusForbidden},
+		{"unknown post", http.MethodPut, "/posts/9999/vote", "carol", up, http.StatusNotFound},
+		{"invalid id", http.MethodPut, "/posts/x/vote", "carol", up, http.StatusBadRequest},
+		{"invalid JSON", http.MethodPut, "/posts/1/vote", "carol", "{", http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/posts/1/vote", "carol", up, http.StatusMethodNotAllowed},
+	})
+}
+
+func TestCommentVote(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do(http.MethodPut, "/comments/1/vote", "bob", VoteRequest{Value: -1})
+	wantStatus(t, w, http.StatusOK)
+	if comment := decode[Comment](t, w); comment.Score != -1 || comment.MyVote != -1 {
+		t.Errorf("score = %d, myVote = %d, want -1 and -1", comment.Score, comment.MyVote)
+	}
+
+	w = ts.do(http.MethodDelete, "/comments/1/vote", "bob", nil)
+	wantStatus(t, w, http.StatusOK)
+	if comment := decode[Comment](t, w); comment.Score != 0 || comment.MyVote != 0 {
+		t.Errorf("after retracting: score = %d, myVote = %d, want 0 and 0", comment.Score, comment.MyVote)
+	}
+	wantError(t, ts.do(http.MethodPut, "/comments/1/vote", "bob", VoteRequest{}), http.StatusBadRequest, "value")
+
+	up := VoteRequest{Value: 1}
+	runRequests(t, []request{
+		{"anonymous", http.MethodPut, "/comments/1/vote", "", up, http.StatusUnauthorized},
+		{"own comment", http.MethodPut, "/comments/1/vote", "carol", up, http.StatusForbidden},
+		{"read-only", http.MethodPut, "/comments/1/vote", "rita", up, http.StatusForbidden},
+		{"banned", http.MethodDelete, "/comments/1/vote", "ben", nil, http.StatusForbidden},
+		{"unknown comment", http.MethodPut, "/comments/9999/vote", "bob", up, http.StatusNotFound},
+		{"invalid id", http.MethodPut, "/comments/x/vote", "bob", up, http.StatusBadRequest},
+		{"invalid JSON", http.MethodPut, "/comments/1/vote", "bob", "{", http.StatusBadRequest},
+		{"wrong method", http.MethodGet, "/comments/1/vote", "bob", nil, http.StatusMethodNotAllowed},
+	})
+}
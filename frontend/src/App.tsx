@@ -1,10 +1,11 @@
-import { useEffect, useState, FormEvent } from "react";
+import { useEffect, useState, FormEvent, ReactElement } from "react";
 import "./App.css";
 
 type Topic = {
   id: number;
   title: string;
   description: string;
+  isArchived: boolean;
 };
 
 type Post = {
@@ -12,22 +13,155 @@ type Post = {
   topicId: number;
   title: string;
   content: string;
+  // Rendered from the Markdown in content and sanitized by the server
+  contentHtml: string;
   author: string;
   isPinned: boolean;
+  score: number;
+  myVote: number;
+  acceptedCommentId: number | null;
+  isResolved: boolean;
+  createdAt: string;
+  updatedAt: string | null;
 };
 
 type Comment = {
   id: number;
   postId: number;
+  parentId: number | null;
+  depth: number;
   content: string;
+  contentHtml: string;
   author: string;
   isPinned: boolean;
+  score: number;
+  myVote: number;
+  isDeleted: boolean;
+  createdAt: string;
+  updatedAt: string | null;
+  replies?: Comment[];
+};
+
+// A ban has no expiry; a suspension ends at expiresAt
+type Sanction = {
+  id: number;
+  reason: string;
+  issuedBy: string;
+  expiresAt: string | null;
 };
 
 type User = {
   id: number;
   username: string;
+  role: "admin" | "moderator" | "member" | "readonly";
   isModerator: boolean;
+  moderatedTopicIds: number[];
+  sanction?: Sanction;
+};
+
+type Notification = {
+  id: number;
+  kind: "comment" | "reply" | "mention" | "edit" | "pin";
+  actor: string;
+  postId: number;
+  postTitle: string;
+  commentId: number | null;
+  isRead: boolean;
+  createdAt: string;
+};
+
+// What each kind of notification says after the actor's name
+const NOTIFICATION_TEXT: Record<Notification["kind"], string> = {
+  comment: "commented on your post",
+  reply: "replied to your comment on",
+  mention: "mentioned you in",
+  edit: "edited your content in",
+  pin: "pinned your content in",
+};
+
+// The JSON body of an error response. fields says what is wrong with
+// each invalid field of the request.
+type ApiErrorBody = {
+  code: string;
+  message: string;
+  fields?: Record<string, string>;
+};
+
+class ApiError extends Error {
+  code: string;
+  fields: Record<string, string>;
+
+  constructor(status: number, body: Partial<ApiErrorBody>) {
+    super(body.message || `HTTP error ${status}`);
+    this.code = body.code ?? "unknown";
+    this.fields = body.fields ?? {};
+  }
+}
+
+// Reject with the server's explanation of a failed request
+const failWith = (res: Response): Promise<never> =>
+  res
+    .json()
+    .catch(() => ({}))
+    .then((body: Partial<ApiErrorBody>) => {
+      throw new ApiError(res.status, body);
+    });
+
+type Page<T> = {
+  items: T[];
+  nextCursor: string | null;
+};
+
+// Replies can be nested this many levels below a top-level comment
+const MAX_COMMENT_DEPTH = 5;
+
+// Apply fn to every comment in a thread, replies included
+const mapComments = (
+  list: Comment[],
+  fn: (c: Comment) => Comment
+): Comment[] =>
+  list.map((c) =>
+    fn({ ...c, replies: c.replies && mapComments(c.replies, fn) })
+  );
+
+// Remove a deleted comment from a thread; one with replies stays as a placeholder
+const removeComment = (list: Comment[], id: number): Comment[] =>
+  list
+    .filter((c) => c.id !== id || (c.replies?.length ?? 0) > 0)
+    .map((c) =>
+      c.id === id
+        ? { ...c, isDeleted: true, content: "", contentHtml: "", author: "" }
+        : { ...c, replies: c.replies && removeComment(c.replies, id) }
+    );
+
+// Whether a thread already holds the comment with this id
+const hasComment = (list: Comment[], id: number): boolean =>
+  list.some((c) => c.id === id || hasComment(c.replies ?? [], id));
+
+// Add a new comment to a thread, under its parent if it is a reply
+const addComment = (list: Comment[], created: Comment): Comment[] => {
+  if (hasComment(list, created.id)) return list;
+  if (created.parentId === null) return [...list, created];
+  return mapComments(list, (c) =>
+    c.id === created.parentId
+      ? { ...c, replies: [...(c.replies ?? []), created] }
+      : c
+  );
+};
+
+// A change to a post or comment pushed by GET /events
+type ServerEvent = {
+  type: string;
+  topicId: number;
+  postId: number;
+  data: Post | Comment | { id: number };
+};
+
+const EVENT_TYPES = ["created", "updated", "deleted", "pinned", "unpinned"];
+
+type Session = {
+  token: string;
+  user: User;
 };
 
 function App() {
@@ -39,25 +173,34 @@ function App() {
   const [posts, setPosts] = useState<Post[]>([]);
   const [loadingPosts, setLoadingPosts] = useState(false);
   const [postsError, setPostsError] = useState<string | null>(null);
+  const [postsCursor, setPostsCursor] = useState<string | null>(null);
+  const [unresolvedOnly, setUnresolvedOnly] = useState(false);
 
   const [selectedPost, setSelectedPost] = useState<Post | null>(null);
   const [comments, setComments] = useState<Comment[]>([]);
   const [loadingComments, setLoadingComments] = useState(false);
   const [commentsError, setCommentsError] = useState<string | null>(null);
+  const [commentsCursor, setCommentsCursor] = useState<string | null>(null);
 
   const [newPostTitle, setNewPostTitle] = useState("");
   const [newPostContent, setNewPostContent] = useState("");
   const [creatingPost, setCreatingPost] = useState(false);
   const [createPostError, setCreatePostError] = useState<string | null>(null);
+  const [createPostFields, setCreatePostFields] = useState<
+    Record<string, string>
+  >({});
 
   const [newCommentContent, setNewCommentContent] = useState("");
+  const [replyTo, setReplyTo] = useState<Comment | null>(null);
   const [creatingComment, setCreatingComment] = useState(false);
   const [createCommentError, setCreateCommentError] = useState<string | null>(
     null
   );
 
   const [currentUser, setCurrentUser] = useState<User | null>(null);
+  const [authToken, setAuthToken] = useState<string | null>(null);
   const [loginName, setLoginName] = useState("");
+  const [loginPassword, setLoginPassword] = useState("");
   const [loginError, setLoginError] = useState<string | null>(null);
   const [loggingIn, setLoggingIn] = useState(false);
 
@@ -67,6 +210,10 @@ function App() {
   const [editPostError, setEditPostError] = useState<string | null>(null);
   const [savingPost, setSavingPost] = useState(false);
 
+  const [notifications, setNotifications] = useState<Notification[]>([]);
+  const [unreadCount, setUnreadCount] = useState(0);
+  const [showNotifications, setShowNotifications] = useState(false);
+
   const [editingCommentId, setEditingCommentId] = useState<number | null>(null);
   const [editCommentContent, setEditCommentContent] = useState("");
   const [editCommentError, setEditCommentError] = useState<string | null>(null);
@@ -77,7 +224,7 @@ function App() {
     fetch("http://localhost:8080/topics")
       .then((res) => {
         if (!res.ok) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
         return res.json();
       })
@@ -93,33 +240,128 @@ function App() {
       });
   }, []);
 
-  // Login handler
-  const handleLogin = (e: FormEvent<HTMLFormElement>) => {
-    e.preventDefault();
+  // Apply changes made by other users while a topic is open
+  useEffect(() => {
+    if (!selectedTopic) return;
+    const postId = selectedPost?.id;
+    const source = new EventSource(
+      `http://localhost:8080/events?topicId=${selectedTopic.id}`
+    );
+
+    const handleEvent = (msg: MessageEvent<string>) => {
+      const e: ServerEvent = JSON.parse(msg.data);
+      const [kind, change] = e.type.split(".");
+
+      if (kind === "post") {
+        if (change === "deleted") {
+          setPosts((prev) => prev.filter((p) => p.id !== e.postId));
+          setSelectedPost((prev) => (prev?.id === e.postId ? null : prev));
+          return;
+        }
+        const post = e.data as Post;
+        if (change === "created") {
+          setPosts((prev) =>
+            prev.some((p) => p.id === post.id) ? prev : [...prev, post]
+          );
+          return;
+        }
+        // Keep our own vote; the event carries nobody's
+        setPosts((prev) =>
+          prev.map((p) =>
+            p.id === post.id ? { ...post, myVote: p.myVote } : p
+          )
+        );
+        setSelectedPost((prev) =>
+          prev?.id === post.id ? { ...post, myVote: prev.myVote } : prev
+        );
+        return;
+      }
+
+      if (e.postId !== postId) return;
+      if (change === "deleted") {
+        setComments((prev) => removeComment(prev, e.data.id));
+        return;
+      }
+      const comment = e.data as Comment;
+      if (change === "created") {
+        setComments((prev) => addComment(prev, comment));
+        return;
+      }
+      setComments((prev) =>
+        mapComments(prev, (c) =>
+          c.id === comment.id
+            ? { ...comment, myVote: c.myVote, replies: c.replies }
+            : c
+        )
+      );
+    };
+
+    for (const kind of ["post", "comment"]) {
+      for (const change of EVENT_TYPES) {
+        source.addEventListener(`${kind}.${change}`, handleEvent);
+      }
+    }
+    return () => source.close();
+  }, [selectedTopic?.id, selectedPost?.id]);
+
+  // Check for new notifications while logged in
+  useEffect(() => {
+    if (!authToken) return;
+    const loadCount = () => {
+      fetch("http://localhost:8080/notifications/unread-count", {
+        headers: { Authorization: `Bearer ${authToken}` },
+      })
+        .then((res) => (res.ok ? res.json() : { count: 0 }))
+        .then((data: { count: number }) => setUnreadCount(data.count))
+        .catch(() => {
+          // Try again on the next tick
+        });
+    };
+    loadCount();
+    const timer = window.setInterval(loadCount, 60000);
+    return () => window.clearInterval(timer);
+  }, [authToken]);
+
+  // Headers for requests that act as the logged-in user
+  const authHeaders = (): Record<string, string> => {
+    const headers: Record<string, string> = {
+      "Content-Type": "application/json",
+    };
+    if (authToken) {
+      headers.Authorization = `Bearer ${authToken}`;
+    }
+    return headers;
+  };
+
+  // Login / register handler
+  const submitAuth = (endpoint: "login" | "register") => {
     const trimmed = loginName.trim();
-    if (!trimmed) {
-      setLoginError("Username cannot be empty.");
+    if (!trimmed || !loginPassword) {
+      setLoginError("Username and password cannot be empty.");
       return;
     }
 
     setLoggingIn(true);
     setLoginError(null);
 
-    fetch("http://localhost:8080/login", {
+    fetch(`http://localhost:8080/${endpoint}`, {
       method: "POST",
       headers: {
         "Content-Type": "application/json",
       },
-      body: JSON.stringify({ username: trimmed }),
+      body: JSON.stringify({ username: trimmed, password: loginPassword }),
     })
       .then((res) => {
         if (!res.ok) {
-          throw new Error(`HTTP error ${res.status}`);
+          // Show why the server refused, e.g. a ban or a wrong password
+          return failWith(res);
         }
         return res.json();
       })
-      .then((user: User) => {
-        setCurrentUser(user);
+      .then((session: Session) => {
+        setCurrentUser(session.user);
+        setAuthToken(session.token);
+        setLoginPassword("");
         setLoggingIn(false);
       })
       .catch((err: unknown) => {
@@ -130,26 +372,87 @@ function App() {
       });
   };
 
+  const handleLogin = (e: FormEvent<HTMLFormElement>) => {
+    e.preventDefault();
+    submitAuth("login");
+  };
+
   const handleLogout = () => {
+    fetch("http://localhost:8080/logout", {
+      method: "POST",
+      headers: authHeaders(),
+    }).catch(() => {
+      // The session is dropped locally either way
+    });
     setCurrentUser(null);
+    setAuthToken(null);
+    setNotifications([]);
+    setUnreadCount(0);
+    setShowNotifications(false);
+  };
+
+  // Open the notification list and mark everything in it read
+  const toggleNotifications = () => {
+    if (showNotifications) {
+      setShowNotifications(false);
+      return;
+    }
+    setShowNotifications(true);
+
+    fetch("http://localhost:8080/notifications", { headers: authHeaders() })
+      .then((res) => {
+        if (!res.ok) {
+          return failWith(res);
+        }
+        return res.json();
+      })
+      .then((data: Page<Notification>) => {
+        setNotifications(data.items ?? []);
+        return fetch("http://localhost:8080/notifications/read", {
+          method: "POST",
+          headers: authHeaders(),
+          body: JSON.stringify({}),
+        });
+      })
+      .then(() => setUnreadCount(0))
+      .catch(() => {
+        setNotifications([]);
+      });
+  };
+
+  const isModerator = currentUser?.isModerator === true;
+
+  // Read-only, banned and suspended users can look around but not post,
+  // comment or vote
+  const canWrite =
+    currentUser !== null &&
+    currentUser.role !== "readonly" &&
+    !currentUser.sanction;
+
+  // Forum-wide moderators moderate every topic, others only their own
+  const canModerateTopic = (topicId: number | undefined) => {
+    if (!currentUser || currentUser.sanction || topicId === undefined) {
+      return false;
+    }
+    return isModerator || currentUser.moderatedTopicIds.includes(topicId);
   };
 
   const canModifyPost = (p: Post) => {
     if (!currentUser) return false;
     return (
-      currentUser.username === p.author || currentUser.isModerator === true
+      (canWrite && currentUser.username === p.author) ||
+      canModerateTopic(p.topicId)
     );
   };
 
   const canModifyComment = (c: Comment) => {
     if (!currentUser) return false;
     return (
-      currentUser.username === c.author || currentUser.isModerator === true
+      (canWrite && currentUser.username === c.author) ||
+      canModerateTopic(selectedPost?.topicId)
     );
   };
 
-  const isModerator = currentUser?.isModerator === true;
-
   // When a topic is clicked, load its posts
   const handleTopicClick = (topic: Topic) => {
     setSelectedTopic(topic);
@@ -165,20 +468,36 @@ function App() {
     setNewPostTitle("");
     setNewPostContent("");
     setCreatePostError(null);
+    setCreatePostFields({});
     setNewCommentContent("");
     setCreateCommentError(null);
     setEditingPostId(null);
     setEditingCommentId(null);
 
-    fetch(`http://localhost:8080/posts?topicId=${topic.id}`)
+    setPostsCursor(null);
+    loadPosts(topic.id, null);
+  };
+
+  // Load one page of posts; a cursor appends the next page
+  const loadPosts = (
+    topicId: number,
+    cursor: string | null,
+    unresolved: boolean = unresolvedOnly
+  ) => {
+    const params = new URLSearchParams({ topicId: String(topicId) });
+    if (cursor) params.set("cursor", cursor);
+    if (unresolved) params.set("unresolved", "true");
+
+    fetch(`http://localhost:8080/posts?${params}`, { headers: authHeaders() })
       .then((res) => {
         if (!res.ok) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
         return res.json();
       })
-      .then((data: Post[]) => {
-        setPosts(data);
+      .then((data: Page<Post>) => {
+        setPosts((prev) => (cursor ? [...prev, ...data.items] : data.items));
+        setPostsCursor(data.nextCursor);
         setLoadingPosts(false);
       })
       .catch((err: unknown) => {
@@ -198,18 +517,33 @@ function App() {
 
     // Reset comment form
     setNewCommentContent("");
+    setReplyTo(null);
     setCreateCommentError(null);
     setEditingCommentId(null);
 
-    fetch(`http://localhost:8080/comments?postId=${post.id}`)
+    setCommentsCursor(null);
+    loadComments(post.id, null);
+  };
+
+  // Load one page of comments; a cursor appends the next page
+  const loadComments = (postId: number, cursor: string | null) => {
+    const params = new URLSearchParams({ postId: String(postId) });
+    if (cursor) params.set("cursor", cursor);
+
+    fetch(`http://localhost:8080/comments?${params}`, {
+      headers: authHeaders(),
+    })
       .then((res) => {
         if (!res.ok) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
         return res.json();
       })
-      .then((data: Comment[]) => {
-        setComments(data);
+      .then((data: Page<Comment>) => {
+        setComments((prev) =>
+          cursor ? [...prev, ...data.items] : data.items
+        );
+        setCommentsCursor(data.nextCursor);
         setLoadingComments(false);
       })
       .catch((err: unknown) => {
@@ -237,37 +571,43 @@ function App() {
 
     setCreatingPost(true);
     setCreatePostError(null);
+    setCreatePostFields({});
 
     const body = {
       topicId: selectedTopic.id,
-      userId: currentUser.id,
       title: newPostTitle.trim(),
       content: newPostContent.trim(),
     };
 
     fetch("http://localhost:8080/posts", {
       method: "POST",
-      headers: {
-        "Content-Type": "application/json",
-      },
+      headers: authHeaders(),
       body: JSON.stringify(body),
     })
       .then((res) => {
         if (!res.ok) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
         return res.json();
       })
       .then((created: Post) => {
-        setPosts((prev) => [...prev, created]);
+        // The event for it may have arrived first
+        setPosts((prev) =>
+          prev.some((p) => p.id === created.id) ? prev : [...prev, created]
+        );
         setNewPostTitle("");
         setNewPostContent("");
         setCreatingPost(false);
       })
       .catch((err: unknown) => {
-        setCreatePostError(
-          err instanceof Error ? err.message : "Unknown error creating post"
-        );
+        // Field errors are shown next to their fields
+        if (err instanceof ApiError && Object.keys(err.fields).length > 0) {
+          setCreatePostFields(err.fields);
+        } else {
+          setCreatePostError(
+            err instanceof Error ? err.message : "Unknown error creating post"
+          );
+        }
         setCreatingPost(false);
       });
   };
@@ -302,21 +642,18 @@ function App() {
 
     const body = {
       id: editingPostId,
-      userId: currentUser.id,
       title: editPostTitle.trim(),
       content: editPostContent.trim(),
     };
 
     fetch("http://localhost:8080/posts", {
       method: "PUT",
-      headers: {
-        "Content-Type": "application/json",
-      },
+      headers: authHeaders(),
       body: JSON.stringify(body),
     })
       .then((res) => {
         if (!res.ok) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
         return res.json();
       })
@@ -343,19 +680,16 @@ function App() {
 
     const body = {
       id: p.id,
-      userId: currentUser.id,
     };
 
     fetch("http://localhost:8080/posts", {
       method: "DELETE",
-      headers: {
-        "Content-Type": "application/json",
-      },
+      headers: authHeaders(),
       body: JSON.stringify(body),
     })
       .then((res) => {
         if (!res.ok && res.status !== 204) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
         setPosts((prev) => prev.filter((post) => post.id !== p.id));
         setSelectedPost((prev) => (prev && prev.id === p.id ? null : prev));
@@ -370,33 +704,30 @@ function App() {
       });
   };
 
-  // Handle pin/unpin post (moderator only)
+  // Handle pin/unpin post (moderators of its topic only)
   const handleTogglePinPost = (p: Post) => {
-    if (!currentUser || !isModerator) return;
+    if (!currentUser || !canModerateTopic(p.topicId)) return;
 
     const body = {
       id: p.id,
-      userId: currentUser.id,
       pinned: !p.isPinned,
     };
 
     fetch("http://localhost:8080/posts/pin", {
       method: "POST",
-      headers: {
-        "Content-Type": "application/json",
-      },
+      headers: authHeaders(),
       body: JSON.stringify(body),
     })
       .then((res) => {
         if (!res.ok) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
         return res.json();
       })
       .then((updated: Post) => {
         setPosts((prev) => {
           const next = prev.map((post) =>
-            post.id === updated.id ? updated : post
+            post.id === updated.id ? { ...updated, myVote: post.myVote } : post
           );
           // Ensure pinned first visually
           return next.slice().sort((a, b) => {
@@ -413,6 +744,123 @@ function App() {
       });
   };
 
+  // Handle voting on a post; voting the same way again retracts the vote
+  const handleVotePost = (p: Post, value: number) => {
+    if (!currentUser) return;
+    const retract = p.myVote === value;
+
+    fetch(`http://localhost:8080/posts/${p.id}/vote`, {
+      method: retract ? "DELETE" : "PUT",
+      headers: authHeaders(),
+      body: retract ? undefined : JSON.stringify({ value }),
+    })
+      .then((res) => {
+        if (!res.ok) {
+          return failWith(res);
+        }
+        return res.json();
+      })
+      .then((updated: Post) => {
+        setPosts((prev) =>
+          prev.map((post) => (post.id === updated.id ? updated : post))
+        );
+        setSelectedPost((prev) => (prev && prev.id === updated.id ? updated : prev));
+      })
+      .catch((err: unknown) => {
+        const msg =
+          err instanceof Error ? err.message : "Unknown error voting";
+        alert(`Error voting on post: ${msg}`);
+      });
+  };
+
+  // Handle voting on a comment; voting the same way again retracts the vote
+  const handleVoteComment = (c: Comment, value: number) => {
+    if (!currentUser) return;
+    const retract = c.myVote === value;
+
+    fetch(`http://localhost:8080/comments/${c.id}/vote`, {
+      method: retract ? "DELETE" : "PUT",
+      headers: authHeaders(),
+      body: retract ? undefined : JSON.stringify({ value }),
+    })
+      .then((res) => {
+        if (!res.ok) {
+          return failWith(res);
+        }
+        return res.json();
+      })
+      .then((updated: Comment) => {
+        setComments((prev) =>
+          mapComments(prev, (comment) =>
+            comment.id === updated.id
+              ? { ...updated, replies: comment.replies }
+              : comment
+          )
+        );
+      })
+      .catch((err: unknown) => {
+        const msg =
+          err instanceof Error ? err.message : "Unknown error voting";
+        alert(`Error voting on comment: ${msg}`);
+      });
+  };
+
+  // Handle reporting a post or comment to the moderators
+  const handleReport = (targetType: "post" | "comment", targetId: number) => {
+    if (!currentUser) return;
+    const reason = window.prompt(`Why are you reporting this ${targetType}?`);
+    if (!reason || !reason.trim()) return;
+
+    fetch("http://localhost:8080/reports", {
+      method: "POST",
+      headers: authHeaders(),
+      body: JSON.stringify({ targetType, targetId, reason: reason.trim() }),
+    })
+      .then((res) => {
+        if (!res.ok) {
+          return failWith(res);
+        }
+        alert("Thanks, the moderators will take a look.");
+      })
+      .catch((err: unknown) => {
+        const msg =
+          err instanceof Error ? err.message : "Unknown error reporting";
+        alert(`Error reporting ${targetType}: ${msg}`);
+      });
+  };
+
+  // Handle accepting a comment as the answer to the selected post;
+  // accepting the current answer again unmarks it
+  const handleAcceptAnswer = (c: Comment) => {
+    if (!currentUser || !selectedPost) return;
+    const unaccept = selectedPost.acceptedCommentId === c.id;
+
+    fetch(`http://localhost:8080/posts/${selectedPost.id}/answer`, {
+      method: unaccept ? "DELETE" : "PUT",
+      headers: authHeaders(),
+      body: unaccept ? undefined : JSON.stringify({ commentId: c.id }),
+    })
+      .then((res) => {
+        if (!res.ok) {
+          return failWith(res);
+        }
+        return res.json();
+      })
+      .then((updated: Post) => {
+        setPosts((prev) =>
+          prev.map((post) => (post.id === updated.id ? updated : post))
+        );
+        setSelectedPost(updated);
+        // The accepted answer is listed first, so reload the comments
+        loadComments(updated.id, null);
+      })
+      .catch((err: unknown) => {
+        const msg =
+          err instanceof Error ? err.message : "Unknown error accepting answer";
+        alert(`Error accepting answer: ${msg}`);
+      });
+  };
+
   // Handle creating a new comment under the selected post
   const handleCreateComment = (e: FormEvent<HTMLFormElement>) => {
     e.preventDefault();
@@ -431,26 +879,25 @@ function App() {
 
     const body = {
       postId: selectedPost.id,
-      userId: currentUser.id,
+      parentId: replyTo ? replyTo.id : 0,
       content: newCommentContent.trim(),
     };
 
     fetch("http://localhost:8080/comments", {
       method: "POST",
-      headers: {
-        "Content-Type": "application/json",
-      },
+      headers: authHeaders(),
       body: JSON.stringify(body),
     })
       .then((res) => {
         if (!res.ok) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
         return res.json();
       })
       .then((created: Comment) => {
-        setComments((prev) => [...prev, created]);
+        setComments((prev) => addComment(prev, created));
         setNewCommentContent("");
+        setReplyTo(null);
         setCreatingComment(false);
       })
       .catch((err: unknown) => {
@@ -489,26 +936,25 @@ function App() {
 
     const body = {
       id: editingCommentId,
-      userId: currentUser.id,
       content: editCommentContent.trim(),
     };
 
     fetch("http://localhost:8080/comments", {
       method: "PUT",
-      headers: {
-        "Content-Type": "application/json",
-      },
+      headers: authHeaders(),
       body: JSON.stringify(body),
     })
       .then((res) => {
         if (!res.ok) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
         return res.json();
       })
       .then((updated: Comment) => {
         setComments((prev) =>
-          prev.map((c) => (c.id === updated.id ? updated : c))
+          mapComments(prev, (c) =>
+            c.id === updated.id ? { ...updated, replies: c.replies } : c
+          )
         );
         cancelEditComment();
       })
@@ -530,21 +976,18 @@ function App() {
 
     const body = {
       id: c.id,
-      userId: currentUser.id,
     };
 
     fetch("http://localhost:8080/comments", {
       method: "DELETE",
-      headers: {
-        "Content-Type": "application/json",
-      },
+      headers: authHeaders(),
       body: JSON.stringify(body),
     })
       .then((res) => {
         if (!res.ok && res.status !== 204) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
-        setComments((prev) => prev.filter((comment) => comment.id !== c.id));
+        setComments((prev) => removeComment(prev, c.id));
       })
       .catch((err: unknown) => {
         const msg =
@@ -553,33 +996,32 @@ function App() {
       });
   };
 
-  // Handle pin/unpin comment (moderator only)
+  // Handle pin/unpin comment (moderators of its topic only)
   const handleTogglePinComment = (c: Comment) => {
-    if (!currentUser || !isModerator) return;
+    if (!currentUser || !canModerateTopic(selectedPost?.topicId)) return;
 
     const body = {
       id: c.id,
-      userId: currentUser.id,
       pinned: !c.isPinned,
     };
 
     fetch("http://localhost:8080/comments/pin", {
       method: "POST",
-      headers: {
-        "Content-Type": "application/json",
-      },
+      headers: authHeaders(),
       body: JSON.stringify(body),
     })
       .then((res) => {
         if (!res.ok) {
-          throw new Error(`HTTP error ${res.status}`);
+          return failWith(res);
         }
         return res.json();
       })
       .then((updated: Comment) => {
         setComments((prev) => {
-          const next = prev.map((comment) =>
-            comment.id === updated.id ? updated : comment
+          const next = mapComments(prev, (comment) =>
+            comment.id === updated.id
+              ? { ...updated, myVote: comment.myVote, replies: comment.replies }
+              : comment
           );
           return next.slice().sort((a, b) => {
             if (a.isPinned === b.isPinned) return a.id - b.id;
@@ -596,6 +1038,167 @@ function App() {
       });
   };
 
+  // Render a comment with its replies nested below it
+  const renderComment = (c: Comment): ReactElement => (
+    <li key={c.id} className="comment-item">
+      {editingCommentId === c.id ? (
+        <form onSubmit={handleSaveComment}>
+          <div className="form-field">
+            <label>
+              Comment
+              <textarea
+                className="form-textarea"
+                rows={2}
+                value={editCommentContent}
+                onChange={(e) =>
+                  setEditCommentContent(e.target.value)
+                }
+              />
+            </label>
+          </div>
+          {editCommentError && (
+            <p className="error-text">
+              Error: {editCommentError}
+            </p>
+          )}
+          <div className="actions">
+            <button
+              className="btn btn-primary"
+              type="submit"
+              disabled={savingComment}
+            >
+              {savingComment ? "Saving..." : "Save"}
+            </button>
+            <button
+              className="btn"
+              type="button"
+              onClick={cancelEditComment}
+            >
+              Cancel
+            </button>
+          </div>
+        </form>
+      ) : (
+        <>
+          {c.isDeleted ? (
+            <div className="comment-body">
+              <em>[deleted]</em>
+            </div>
+          ) : (
+            <div
+              className="comment-body"
+              dangerouslySetInnerHTML={{ __html: c.contentHtml }}
+            />
+          )}
+          {!c.isDeleted && (
+            <div className="comment-meta">
+              by {c.author} on{" "}
+              {new Date(c.createdAt).toLocaleString()}
+              {c.updatedAt && " (edited)"} · {c.score} points{" "}
+              {c.isPinned && (
+                <span className="chip">Pinned</span>
+              )}{" "}
+              {selectedPost?.acceptedCommentId === c.id && (
+                <span className="chip">Accepted answer</span>
+              )}
+            </div>
+          )}
+          <div className="actions">
+            {currentUser &&
+              canWrite &&
+              !c.isDeleted &&
+              currentUser.username !== c.author && (
+                <>
+                  <button
+                    className={c.myVote === 1 ? "btn btn-primary" : "btn"}
+                    type="button"
+                    onClick={() => handleVoteComment(c, 1)}
+                  >
+                    ▲
+                  </button>
+                  <button
+                    className={c.myVote === -1 ? "btn btn-primary" : "btn"}
+                    type="button"
+                    onClick={() => handleVoteComment(c, -1)}
+                  >
+                    ▼
+                  </button>
+                  <button
+                    className="btn"
+                    type="button"
+                    onClick={() => handleReport("comment", c.id)}
+                  >
+                    Report
+                  </button>
+                </>
+              )}
+            {canWrite &&
+              !c.isDeleted &&
+              c.depth < MAX_COMMENT_DEPTH && (
+                <button
+                  className="btn"
+                  type="button"
+                  onClick={() => setReplyTo(c)}
+                >
+                  Reply
+                </button>
+              )}
+            {!c.isDeleted && canModifyComment(c) && (
+              <>
+                <button
+                  className="btn"
+                  type="button"
+                  onClick={() => startEditComment(c)}
+                >
+                  Edit
+                </button>
+                <button
+                  className="btn btn-danger"
+                  type="button"
+                  onClick={() =>
+                    handleDeleteComment(c)
+                  }
+                >
+                  Delete
+                </button>
+              </>
+            )}
+            {selectedPost &&
+              canModifyPost(selectedPost) &&
+              c.parentId === null &&
+              !c.isDeleted && (
+                <button
+                  className="btn"
+                  type="button"
+                  onClick={() => handleAcceptAnswer(c)}
+                >
+                  {selectedPost.acceptedCommentId === c.id
+                    ? "Unaccept"
+                    : "Accept answer"}
+                </button>
+              )}
+            {canModerateTopic(selectedPost?.topicId) && !c.isDeleted && (
+              <button
+                className="btn"
+                type="button"
+                onClick={() =>
+                  handleTogglePinComment(c)
+                }
+              >
+                {c.isPinned ? "Unpin" : "Pin"}
+              </button>
+            )}
+          </div>
+        </>
+      )}
+      {c.replies && c.replies.length > 0 && (
+        <ul className="comments-list comment-replies">
+          {c.replies.map(renderComment)}
+        </ul>
+      )}
+    </li>
+  );
+
   return (
     <div className="app">
       <div className="app-shell">
@@ -616,9 +1219,43 @@ function App() {
                   Logged in as{" "}
                   <strong>
                     {currentUser.username}
-                    {currentUser.isModerator ? " (moderator)" : ""}
+                    {currentUser.role !== "member"
+                      ? ` (${currentUser.role})`
+                      : ""}
                   </strong>
                 </p>
+                {currentUser.sanction && (
+                  <p className="error-text">
+                    {currentUser.sanction.expiresAt
+                      ? `Suspended until ${new Date(
+                          currentUser.sanction.expiresAt
+                        ).toLocaleString()}`
+                      : "Banned"}
+                    : {currentUser.sanction.reason}
+                  </p>
+                )}
+                <button
+                  className="btn"
+                  type="button"
+                  onClick={toggleNotifications}
+                >
+                  Notifications{unreadCount > 0 ? ` (${unreadCount})` : ""}
+                </button>
+                {showNotifications && (
+                  <ul className="notification-list">
+                    {notifications.length === 0 && (
+                      <li className="helper-text">No notifications yet.</li>
+                    )}
+                    {notifications.map((n) => (
+                      <li
+                        key={n.id}
+                        style={{ fontWeight: n.isRead ? "normal" : "bold" }}
+                      >
+                        {n.actor} {NOTIFICATION_TEXT[n.kind]} "{n.postTitle}"
+                      </li>
+                    ))}
+                  </ul>
+                )}
                 <button className="btn" type="button" onClick={handleLogout}>
                   Log out
                 </button>
@@ -632,12 +1269,26 @@ function App() {
                     placeholder="Enter username"
                     onChange={(e) => setLoginName(e.target.value)}
                   />
+                  <input
+                    type="password"
+                    value={loginPassword}
+                    placeholder="Enter password"
+                    onChange={(e) => setLoginPassword(e.target.value)}
+                  />
                   <button
                     className="btn btn-primary"
                     type="submit"
                     disabled={loggingIn}
                   >
-                    {loggingIn ? "Logging in..." : "Login / Sign up"}
+                    {loggingIn ? "Logging in..." : "Log in"}
+                  </button>
+                  <button
+                    className="btn"
+                    type="button"
+                    disabled={loggingIn}
+                    onClick={() => submitAuth("register")}
+                  >
+                    Sign up
                   </button>
                 </form>
                 {loginError && (
@@ -669,7 +1320,10 @@ function App() {
                     className="topic-item"
                     onClick={() => handleTopicClick(t)}
                   >
-                    <div className="topic-item-title">{t.title}</div>
+                    <div className="topic-item-title">
+                      {t.title}{" "}
+                      {t.isArchived && <span className="chip">Archived</span>}
+                    </div>
                     <div className="topic-item-description">
                       {t.description}
                     </div>
@@ -689,6 +1343,20 @@ function App() {
                   <p className="card-subtitle">
                     Click a post title to view and reply with comments.
                   </p>
+                  <label className="helper-text">
+                    <input
+                      type="checkbox"
+                      checked={unresolvedOnly}
+                      onChange={(e) => {
+                        const unresolved = e.target.checked;
+                        setUnresolvedOnly(unresolved);
+                        setPosts([]);
+                        setLoadingPosts(true);
+                        loadPosts(selectedTopic.id, null, unresolved);
+                      }}
+                    />{" "}
+                    Unresolved questions only
+                  </label>
 
                   {loadingPosts && <p>Loading posts...</p>}
                   {postsError && (
@@ -770,11 +1438,61 @@ function App() {
                                   {p.isPinned && (
                                     <span className="chip">Pinned</span>
                                   )}
+                                  {p.isResolved && (
+                                    <span className="chip">Resolved</span>
+                                  )}
                                 </div>
                               </div>
-                              <div className="post-body">{p.content}</div>
-                              <div className="post-meta">by {p.author}</div>
+                              <div
+                                className="post-body"
+                                dangerouslySetInnerHTML={{
+                                  __html: p.contentHtml,
+                                }}
+                              />
+                              <div className="post-meta">
+                                by {p.author} on{" "}
+                                {new Date(p.createdAt).toLocaleString()}
+                                {p.updatedAt && " (edited)"} ·{" "}
+                                {p.score} points
+                              </div>
                               <div className="actions">
+                                {currentUser &&
+                                  canWrite &&
+                                  currentUser.username !== p.author && (
+                                    <>
+                                      <button
+                                        className={
+                                          p.myVote === 1
+                                            ? "btn btn-primary"
+                                            : "btn"
+                                        }
+                                        type="button"
+                                        onClick={() => handleVotePost(p, 1)}
+                                      >
+                                        ▲
+                                      </button>
+                                      <button
+                                        className={
+                                          p.myVote === -1
+                                            ? "btn btn-primary"
+                                            : "btn"
+                                        }
+                                        type="button"
+                                        onClick={() => handleVotePost(p, -1)}
+                                      >
+                                        ▼
+                                      </button>
+                                      <button
+                                        className="btn"
+                                        type="button"
+                                        onClick={() =>
+                                          handleReport("post", p.id)
+                                        }
+                                      >
+                                        Report
+                                      </button>
+                                    </>
+                                  )}
                                 {canModifyPost(p) && (
                                   <>
                                     <button
@@ -793,7 +1511,7 @@ function App() {
                                     </button>
                                   </>
                                 )}
-                                {isModerator && (
+                                {canModerateTopic(p.topicId) && (
                                   <button
                                     className="btn"
                                     type="button"
@@ -810,6 +1528,16 @@ function App() {
                     </ul>
                   )}
 
+                  {postsCursor && (
+                    <button
+                      className="btn"
+                      type="button"
+                      onClick={() => loadPosts(selectedTopic.id, postsCursor)}
+                    >
+                      Load more posts
+                    </button>
+                  )}
+
                   <div className="form-section">
                     <h3 style={{ margin: "0 0 0.5rem" }}>
                       Create a new post
@@ -827,6 +1555,9 @@ function App() {
                             }
                           />
                         </label>
+                        {createPostFields.title && (
+                          <p className="error-text">{createPostFields.title}</p>
+                        )}
                       </div>
                       <div className="form-field">
                         <label>
@@ -840,6 +1571,11 @@ function App() {
                             }
                           />
                         </label>
+                        {createPostFields.content && (
+                          <p className="error-text">
+                            {createPostFields.content}
+                          </p>
+                        )}
                       </div>
                       {createPostError && (
                         <p className="error-text">
@@ -897,98 +1633,38 @@ function App() {
                     !commentsError &&
                     comments.length > 0 && (
                       <ul className="comments-list">
-                        {comments.map((c) => (
-                          <li key={c.id} className="comment-item">
-                            {editingCommentId === c.id ? (
-                              <form onSubmit={handleSaveComment}>
-                                <div className="form-field">
-                                  <label>
-                                    Comment
-                                    <textarea
-                                      className="form-textarea"
-                                      rows={2}
-                                      value={editCommentContent}
-                                      onChange={(e) =>
-                                        setEditCommentContent(e.target.value)
-                                      }
-                                    />
-                                  </label>
-                                </div>
-                                {editCommentError && (
-                                  <p className="error-text">
-                                    Error: {editCommentError}
-                                  </p>
-                                )}
-                                <div className="actions">
-                                  <button
-                                    className="btn btn-primary"
-                                    type="submit"
-                                    disabled={savingComment}
-                                  >
-                                    {savingComment ? "Saving..." : "Save"}
-                                  </button>
-                                  <button
-                                    className="btn"
-                                    type="button"
-                                    onClick={cancelEditComment}
-                                  >
-                                    Cancel
-                                  </button>
-                                </div>
-                              </form>
-                            ) : (
-                              <>
-                                <div className="comment-body">
-                                  {c.content}
-                                </div>
-                                <div className="comment-meta">
-                                  by {c.author}{" "}
-                                  {c.isPinned && (
-                                    <span className="chip">Pinned</span>
-                                  )}
-                                </div>
-                                <div className="actions">
-                                  {canModifyComment(c) && (
-                                    <>
-                                      <button
-                                        className="btn"
-                                        type="button"
-                                        onClick={() => startEditComment(c)}
-                                      >
-                                        Edit
-                                      </button>
-                                      <button
-                                        className="btn btn-danger"
-                                        type="button"
-                                        onClick={() =>
-                                          handleDeleteComment(c)
-                                        }
-                                      >
-                                        Delete
-                                      </button>
-                                    </>
-                                  )}
-                                  {isModerator && (
-                                    <button
-                                      className="btn"
-                                      type="button"
-                                      onClick={() =>
-                                        handleTogglePinComment(c)
-                                      }
-                                    >
-                                      {c.isPinned ? "Unpin" : "Pin"}
-                                    </button>
-                                  )}
-                                </div>
-                              </>
-                            )}
-                          </li>
-                        ))}
+                        {comments.map(renderComment)}
                       </ul>
                     )}
 
+                  {commentsCursor && (
+                    <button
+                      className="btn"
+                      type="button"
+                      onClick={() => loadComments(selectedPost.id, commentsCursor)}
+                    >
+                      Load more comments
+                    </button>
+                  )}
+
                   <div className="form-section">
-                    <h3 style={{ margin: "0 0 0.5rem" }}>Add a comment</h3>
+                    <h3 style={{ margin: "0 0 0.5rem" }}>
+                      {replyTo
+                        ? `Reply to ${replyTo.author}`
+                        : "Add a comment"}
+                    </h3>
+                    {replyTo && (
+                      <p className="helper-text">
+                        "{replyTo.content}"{" "}
+                        <button
+                          className="btn"
+                          type="button"
+                          onClick={() => setReplyTo(null)}
+                        >
+                          Cancel reply
+                        </button>
+                      </p>
+                    )}
                     <form onSubmit={handleCreateComment}>
                       <div className="form-field">
                         <textarea
@@ -1010,7 +1686,11 @@ function App() {
                         type="submit"
                         disabled={creatingComment}
                       >
-                        {creatingComment ? "Adding..." : "Add comment"}
+                        {creatingComment
+                          ? "Adding..."
+                          : replyTo
+                            ? "Add reply"
+                            : "Add comment"}
                       </button>
                     </form>
                     {!currentUser && (